@@ -1,30 +1,80 @@
 package cli
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/bucks-go-wallet/models"
 	"github.com/bucks-go-wallet/utils"
 	"github.com/dgraph-io/badger"
+	"io"
 	"log"
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	gcInterval = time.Minute // how often long running commands reclaim badger value log space
+
+	defaultFeeBlocks = 6 // confirmation target of the fee send estimates when -fee is not given
 )
 
 type CommandLine struct {
 	BlockChain *models.BlockChain
+	DBCache    int // UTXO cache size in MiB, 0 disables the cache
+}
+
+// enableUTXOCache puts the -dbcache sized cache in front of the chain's UTXO set
+func (cli CommandLine) enableUTXOCache(chain *models.BlockChain) {
+	if cli.DBCache > 0 {
+		chain.EnableUTXOCache(cli.DBCache << 20)
+	}
 }
 
 func (cli *CommandLine) PrintUsage() {
 	fmt.Println("Usage:")
 	fmt.Println(" getbalance -address ADDRESS - get the balance for an address")
-	fmt.Println(" createblockchain -address ADDRESS creates a blockchain and sends cody reward to address")
+	fmt.Println(" createblockchain -address ADDRESS [-flatfiles] creates a blockchain and sends cody reward to address, -flatfiles stores blocks in blkNNNNN.dat files")
 	fmt.Println(" printchain - Prints the blocks in the chain")
-	fmt.Println(" send -from FROM -to TO -amount AMOUNT - Send amount of coins")
-	fmt.Println(" createwallet - Create a new wallet")
+	fmt.Println(" send -from FROM -to TO -amount AMOUNT [-fee FEE] [-queue] [-locktime LOCKTIME] [-memo TEXT] [-coins STRATEGY] - Send amount of coins, amounts are in coins with up to 8 decimals, -fee defaults to the estimate for 6 blocks, -queue leaves the payment in the mempool, -locktime holds the payment until a block height or unix time, -memo stores up to 80 bytes of text with it, -coins picks the outputs to spend: largest, smallest, random or bnb")
+	fmt.Println(" createpsbt -from FROM -to ADDRESS:AMOUNT,... -out FILE [-fee FEE] [-locktime LOCKTIME] [-coins STRATEGY] - Fund a payment and write it unsigned, with the outputs it spends, for keys held elsewhere")
+	fmt.Println(" signpsbt -in FILE [-out FILE] [-sighash TYPE] - Sign a PSBT with the wallet file only, -out defaults to the input file")
+	fmt.Println(" combinepsbt -in FILE,FILE,... -out FILE - Merge the signatures of copies of a PSBT signed by different key holders")
+	fmt.Println(" finalizepsbt -in FILE (-miner ADDRESS | -queue) - Check a PSBT is fully signed and send its transaction, the block reward goes to the miner address")
+	fmt.Println(" createrawtransaction -inputs TXID:OUT[:SEQUENCE],... [-outputs ADDRESS:AMOUNT,...] [-data HEX] [-locktime LOCKTIME] - Print an unsigned transaction in hex, the inputs not paid out are the fee")
+	fmt.Println(" decoderawtransaction -hex HEX - Show a hex transaction as JSON")
+	fmt.Println(" signrawtransaction -hex HEX [-sighash TYPE] - Sign the inputs of a hex transaction the wallet has keys for")
+	fmt.Println(" sendrawtransaction -hex HEX (-miner ADDRESS | -queue) - Validate a signed hex transaction and mine it with the block reward to the miner address, or queue it in the mempool")
+	fmt.Println(" estimatefee [-blocks N] - Estimate the fee rate, in units of 10^-8 coin per byte, that gets a transaction mined within N blocks")
+	fmt.Println(" mine -miner ADDRESS - Mine the mempool transactions paying the highest fee rates into a block, its subsidy and fees go to the miner address")
+	fmt.Println(" getdeploymentinfo - Show the state of each soft fork deployment and the signalling of the current window")
+	fmt.Println(" sendmany -from FROM [-to ADDRESS:AMOUNT,...] [-file PAYOUTS.CSV] [-fee FEE] [-coins STRATEGY] - Pay many addresses in one transaction, the file has an address and an amount per line, -fee defaults to the estimate for 6 blocks")
+	fmt.Println(" createwallet [-type TYPE] - Create a new wallet with a p256 or ed25519 key")
+	fmt.Println(" createmultisig -m M -pubkeys KEY,KEY,... - Create an M-of-N multisig address from public keys or wallet addresses")
 	fmt.Println(" listaddress - Lists the addresses in our wallet file")
-	fmt.Println(" reindexutxo - Rebuild the UTXO set")
+	fmt.Println(" createhtlc -from FROM -to TO -amount AMOUNT -locktime HEIGHT [-hash HASH] - Lock coins to TO until HEIGHT, behind a new secret or the sha256 HASH of one")
+	fmt.Println(" redeemhtlc -script SCRIPT -preimage SECRET [-to ADDRESS] - Claim the coins of an HTLC as its recipient")
+	fmt.Println(" refundhtlc -script SCRIPT [-to ADDRESS] - Take back the coins of an HTLC after its lock height")
+	fmt.Println(" inspecthtlc -script SCRIPT - Show the terms and balance of an HTLC and the secret once it was redeemed")
+	fmt.Println(" anchor -file PATH -from ADDRESS - Commit the sha256 of a file to the chain")
+	fmt.Println(" anchor -file PATH -verify - Show where the sha256 of a file was committed")
+	fmt.Println(" reindexutxo - Rebuild the UTXO set, resumes an interrupted rebuild")
+	fmt.Println(" dumptxoutset FILE - Write a snapshot of the UTXO set at the chain tip to FILE")
+	fmt.Println(" exportchain -out FILE [-from HEIGHT -to HEIGHT] - Write the blocks in height order to a bootstrap file")
+	fmt.Println(" importchain -in FILE [-flatfiles] - Validate and connect the blocks of a bootstrap file, resumes where it stopped")
+	fmt.Println(" verifyblockfiles - Check the block files against the block index")
+	fmt.Println(" dbstats - Show the database size per key prefix")
+	fmt.Println(" compactdb - Flatten the database and reclaim value log space")
+	fmt.Println(" loadtxoutset FILE - Start from the UTXO snapshot in FILE, importchain validates its hash in the background once it has the history")
+	fmt.Println("The commands that spend, mine, import or reindex take -dbcache MIB, the size of the UTXO cache kept in memory, 16 by default and 0 to disable it")
 }
 
 func (cli *CommandLine) ValidateArgs() {
@@ -47,6 +97,9 @@ func (cli *CommandLine) PrintChain() {
 		block := iter.Next()
 		fmt.Printf("Previous Hash: %x\n", block.PrevHash)
 		fmt.Printf("Hash: %x\n", block.Hash)
+		fmt.Printf("Height: %d\n", block.Height)
+		fmt.Printf("Version: %#08x\n", block.Version)
+		fmt.Printf("Time: %s\n", time.Unix(block.Timestamp, 0).UTC().Format(time.RFC3339))
 		pow := models.NewProof(block)
 		fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
 		for _, tx := range block.Transactions {
@@ -60,15 +113,17 @@ func (cli *CommandLine) PrintChain() {
 	}
 }
 
-func (cli CommandLine) CreateBlockchain(address string) {
+func (cli CommandLine) CreateBlockchain(address string, flatFiles bool) {
 	if !models.ValidateAddress(address) {
 		log.Panic("Address is invalid")
 	}
-	chain := models.InitBlockChain(address)
-	err := chain.Database.Close()
-	if err != nil {
-		return
-	}
+	chain := models.InitBlockChain(address, flatFiles)
+	defer func(Database *badger.DB) {
+		err := Database.Close()
+		if err != nil {
+
+		}
+	}(chain.Database)
 
 	UTXOSet := models.UTXOSet{BlockChain: chain}
 	UTXOSet.Reindex()
@@ -80,24 +135,26 @@ func (cli CommandLine) GetBalance(address string) {
 		log.Panic("Address is invalid")
 	}
 	chain := models.ContinueBlockChain(address)
+	cli.enableUTXOCache(chain)
 	UTXOSet := models.UTXOSet{BlockChain: chain}
-	defer func(Database *badger.DB) {
-		err := Database.Close()
+	defer func(chain *models.BlockChain) {
+		utils.Handle(chain.FlushUTXOCache())
+		err := chain.Database.Close()
 		if err != nil {
 
 		}
-	}(chain.Database)
+	}(chain)
 
-	balance := 0
-	pubKeyHash := utils.Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := UTXOSet.FindUnspentTransactions(pubKeyHash)
+	var balance models.Amount
+	script, err := models.AddressScript(address)
+	utils.Handle(err)
+	UTXOs := UTXOSet.FindUnspentTransactions(script)
 
 	for _, out := range UTXOs {
 		balance += out.Value
 	}
 
-	fmt.Printf("Balance of %s: %d\n", address, balance)
+	fmt.Printf("Balance of %s: %s\n", address, balance)
 }
 
 func (cli *CommandLine) ListAddresses() {
@@ -105,18 +162,211 @@ func (cli *CommandLine) ListAddresses() {
 	addresses := wallets.GetAllAddresses()
 
 	for _, address := range addresses {
+		if keyType := wallets.GetWallet(address).PrivateKey.Type(); keyType != models.KeyP256 {
+			fmt.Printf("%s (%s)\n", address, keyType)
+			continue
+		}
 		fmt.Println(address)
 	}
+	for address := range wallets.RedeemScripts {
+		fmt.Printf("%s (multisig)\n", address)
+	}
 }
 
-func (cli *CommandLine) CreateWallet() {
+func (cli *CommandLine) CreateWallet(keyType models.KeyType) {
 	wallets, _ := models.CreateWallets()
-	address := wallets.AddWallet()
+	address := wallets.AddWallet(keyType)
 	wallets.SaveFile()
 
 	fmt.Printf("New wallet created on the address: %s\n", address)
+	fmt.Printf("Public key: %x\n", wallets.GetWallet(address).PublicKey)
 }
-func (cli *CommandLine) ReindexUTXO() {
+
+// CreateMultiSig builds the m-of-n redeem script for the keys, given as hex public keys or as
+// addresses of wallets in the wallet file, and remembers it so its address can be spent from
+func (cli *CommandLine) CreateMultiSig(m int, keys []string) {
+	wallets, _ := models.CreateWallets()
+
+	var pubKeys [][]byte
+	for _, key := range keys {
+		if wallet, ok := wallets.Wallets[key]; ok {
+			pubKeys = append(pubKeys, wallet.PublicKey)
+			continue
+		}
+		pubKey, err := hex.DecodeString(key)
+		if err != nil {
+			log.Panicf("%s is neither a public key nor an address in the wallet file", key)
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+
+	redeemScript, err := models.MultiSigRedeemScript(m, pubKeys)
+	utils.Handle(err)
+	address := wallets.AddMultiSig(redeemScript)
+	wallets.SaveFile()
+
+	fmt.Printf("Multisig address: %s\n", address)
+	fmt.Printf("Redeem script: %x\n", redeemScript)
+}
+
+// CreateHTLC locks amount from a wallet to an HTLC paying to, refundable to from after
+// lockHeight. Without a hash a new secret is made and printed, the other side of a swap reuses
+// its hash.
+func (cli CommandLine) CreateHTLC(from, to string, amount models.Amount, lockHeight int, hashHex string) {
+	var secret, hash []byte
+	if hashHex == "" {
+		secret = make([]byte, 32)
+		_, err := rand.Read(secret)
+		utils.Handle(err)
+		sum := sha256.Sum256(secret)
+		hash = sum[:]
+	} else {
+		var err error
+		hash, err = hex.DecodeString(hashHex)
+		utils.Handle(err)
+	}
+
+	htlc, err := models.NewHTLC(hash, to, from, lockHeight)
+	utils.Handle(err)
+	redeemScript, err := htlc.Script()
+	utils.Handle(err)
+	address, err := htlc.Address()
+	utils.Handle(err)
+
+	chain := models.ContinueBlockChain(from)
+	cli.enableUTXOCache(chain)
+	UTXOSet := models.UTXOSet{BlockChain: chain}
+	defer func(chain *models.BlockChain) {
+		utils.Handle(chain.FlushUTXOCache())
+		err := chain.Database.Close()
+		if err != nil {
+
+		}
+	}(chain)
+
+	var opts models.SendOptions
+	setFee(chain, nil, &opts)
+	tx := models.NewTransaction(from, address, amount, opts, &UTXOSet)
+	if !submit(&UTXOSet, tx, from, false) {
+		return
+	}
+
+	fmt.Printf("HTLC address: %s\n", address)
+	fmt.Printf("Redeem script: %x\n", redeemScript)
+	fmt.Printf("Hash: %x\n", hash)
+	if secret != nil {
+		fmt.Printf("Secret: %x\n", secret)
+	}
+	fmt.Printf("Refundable in blocks above height %d, the chain is at height %d\n", lockHeight, chain.Height())
+}
+
+// SpendHTLC redeems the HTLC with the preimage, or refunds it when preimageHex is empty
+func (cli CommandLine) SpendHTLC(scriptHex, preimageHex, to string) {
+	redeemScript, err := hex.DecodeString(scriptHex)
+	utils.Handle(err)
+	var preimage []byte
+	if preimageHex != "" {
+		preimage, err = hex.DecodeString(preimageHex)
+		utils.Handle(err)
+	}
+	if to != "" && !models.ValidateAddress(to) {
+		log.Panic("Address is invalid")
+	}
+
+	chain := models.ContinueBlockChain("")
+	cli.enableUTXOCache(chain)
+	UTXOSet := models.UTXOSet{BlockChain: chain}
+	defer func(chain *models.BlockChain) {
+		utils.Handle(chain.FlushUTXOCache())
+		err := chain.Database.Close()
+		if err != nil {
+
+		}
+	}(chain)
+
+	tx, err := models.NewHTLCSpend(redeemScript, preimage, to, chain.EstimateFee(defaultFeeBlocks).Rate, &UTXOSet)
+	utils.Handle(err)
+	address, err := tx.Outputs[0].Address()
+	utils.Handle(err)
+	if !submit(&UTXOSet, tx, address, false) {
+		return
+	}
+
+	if preimage != nil {
+		fmt.Printf("Redeemed %s coins in transaction %x\n", tx.Outputs[0].Value, tx.ID)
+	} else {
+		fmt.Printf("Refunded %s coins in transaction %x\n", tx.Outputs[0].Value, tx.ID)
+	}
+}
+
+// InspectHTLC prints the terms and balance of an HTLC, and its secret once redeemed
+func (cli CommandLine) InspectHTLC(scriptHex string) {
+	redeemScript, err := hex.DecodeString(scriptHex)
+	utils.Handle(err)
+	htlc, err := models.ParseHTLC(redeemScript)
+	utils.Handle(err)
+	address, err := htlc.Address()
+	utils.Handle(err)
+
+	chain := models.ContinueBlockChain("")
+	cli.enableUTXOCache(chain)
+	UTXOSet := models.UTXOSet{BlockChain: chain}
+	defer func(chain *models.BlockChain) {
+		utils.Handle(chain.FlushUTXOCache())
+		err := chain.Database.Close()
+		if err != nil {
+
+		}
+	}(chain)
+
+	var balance models.Amount
+	for _, out := range UTXOSet.FindUnspentTransactions(models.PayToScriptHashScript(models.ScriptHash(redeemScript))) {
+		balance += out.Value
+	}
+
+	fmt.Printf("HTLC address: %s\n", address)
+	fmt.Printf("Hash: %x\n", htlc.Hash)
+	fmt.Printf("Recipient pubkey hash: %x\n", htlc.Recipient)
+	fmt.Printf("Refund pubkey hash: %x\n", htlc.Refund)
+	fmt.Printf("Lock height: %d, chain height: %d\n", htlc.LockHeight, chain.Height())
+	fmt.Printf("Balance: %s\n", balance)
+	if secret, err := chain.FindHTLCPreimage(redeemScript); err == nil {
+		fmt.Printf("Secret: %x\n", secret)
+	}
+}
+
+// Anchor commits the sha256 of a file to the chain in a data output paid for by from
+func (cli CommandLine) Anchor(path, from string) {
+	hash := fileHash(path)
+	if !models.ValidateAddress(from) {
+		log.Panic("Address is invalid")
+	}
+
+	chain := models.ContinueBlockChain(from)
+	cli.enableUTXOCache(chain)
+	UTXOSet := models.UTXOSet{BlockChain: chain}
+	defer func(chain *models.BlockChain) {
+		utils.Handle(chain.FlushUTXOCache())
+		err := chain.Database.Close()
+		if err != nil {
+
+		}
+	}(chain)
+
+	var opts models.SendOptions
+	setFee(chain, nil, &opts)
+	tx := models.NewDataTransaction(from, hash, opts, &UTXOSet)
+	if !submit(&UTXOSet, tx, from, false) {
+		return
+	}
+
+	fmt.Printf("Anchored sha256 %x of %s in transaction %x at height %d\n", hash, path, tx.ID, chain.Height())
+}
+
+// VerifyAnchor looks for the sha256 of a file in the data outputs of the chain
+func (cli CommandLine) VerifyAnchor(path string) {
+	hash := fileHash(path)
+
 	chain := models.ContinueBlockChain("")
 	defer func(Database *badger.DB) {
 		err := Database.Close()
@@ -125,24 +375,68 @@ func (cli *CommandLine) ReindexUTXO() {
 		}
 	}(chain.Database)
 
+	tx, block, err := chain.FindData(hash)
+	if err != nil {
+		fmt.Printf("sha256 %x of %s is not anchored on this chain\n", hash, path)
+		return
+	}
+	fmt.Printf("sha256 %x of %s was anchored in transaction %x\n", hash, path, tx.ID)
+	fmt.Printf("Block %x at height %d, %s\n", block.Hash, block.Height, time.Unix(block.Timestamp, 0).UTC().Format(time.RFC3339))
+}
+
+func fileHash(path string) []byte {
+	content, err := os.ReadFile(path)
+	utils.Handle(err)
+	hash := sha256.Sum256(content)
+	return hash[:]
+}
+
+func (cli *CommandLine) ReindexUTXO() {
+	chain := models.ContinueBlockChain("")
+	cli.enableUTXOCache(chain)
+	defer func(chain *models.BlockChain) {
+		utils.Handle(chain.FlushUTXOCache())
+		err := chain.Database.Close()
+		if err != nil {
+
+		}
+	}(chain)
+	stopGC := chain.StartValueLogGC(gcInterval)
+	defer stopGC()
+
 	UTXUSet := models.UTXOSet{BlockChain: chain}
-	UTXUSet.Reindex()
+	UTXUSet.ReindexWithProgress(func(progress models.ReindexProgress) {
+		fmt.Printf("\rReindexed %d/%d blocks (%.1f%%), elapsed %s, ETA %s",
+			progress.Height, progress.Total, float64(progress.Height)*100/float64(progress.Total),
+			progress.Elapsed.Round(time.Second), progress.ETA.Round(time.Second))
+	})
+	fmt.Println()
 
 	count := UTXUSet.CountTransactions()
 
 	fmt.Printf("Done, there is %d transactions in this UTXO Set\n", count)
 }
 
-func (cli CommandLine) Send(from, to string, amount int) {
-	if !models.ValidateAddress(from) {
-		log.Panic("From Address is invalid")
-	}
+func (cli *CommandLine) DumpTxOutSet(path string) {
+	chain := models.ContinueBlockChain("")
+	defer func(Database *badger.DB) {
+		err := Database.Close()
+		if err != nil {
+
+		}
+	}(chain.Database)
 
-	if !models.ValidateAddress(from) {
-		log.Panic("To Address is invalid")
-	}
-	chain := models.ContinueBlockChain(from)
 	UTXOSet := models.UTXOSet{BlockChain: chain}
+	info := UTXOSet.DumpSnapshot(path)
+
+	fmt.Printf("Wrote %d transactions at block %x to %s\n", info.Count, info.BaseHash, path)
+	fmt.Printf("Snapshot hash: %x\n", info.ContentHash)
+}
+
+// LoadTxOutSet starts the node from a UTXO snapshot. importchain validates the snapshot in the
+// background once it has stored the history below it.
+func (cli *CommandLine) LoadTxOutSet(path string) {
+	chain, info := models.LoadSnapshot(path)
 	defer func(Database *badger.DB) {
 		err := Database.Close()
 		if err != nil {
@@ -150,95 +444,1179 @@ func (cli CommandLine) Send(from, to string, amount int) {
 		}
 	}(chain.Database)
 
-	tx := models.NewTransaction(from, to, amount, &UTXOSet)
-	block := chain.AddBlock([]*models.Transaction{tx})
-	UTXOSet.Update(block)
-	fmt.Println("Send transaction successfully")
+	fmt.Printf("Loaded %d transactions, node is ready at block %x\n", info.Count, info.BaseHash)
+	fmt.Printf("Snapshot hash: %x\n", info.ContentHash)
 }
 
-func (cli *CommandLine) Run() {
-	cli.ValidateArgs()
+func (cli *CommandLine) ExportChain(path string, from, to int) {
+	chain := models.ContinueBlockChain("")
+	defer func(Database *badger.DB) {
+		err := Database.Close()
+		if err != nil {
 
-	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
-	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
-	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
-	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
-	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
-	listAddressesCmd := flag.NewFlagSet("listaddress", flag.ExitOnError)
-	reindexCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+		}
+	}(chain.Database)
 
-	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
-	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
-	sendFrom := sendCmd.String("from", "", "Source wallet address")
-	sendTo := sendCmd.String("to", "", "Destination wallet address")
-	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	count, err := chain.ExportChain(path, from, to)
+	utils.Handle(err)
 
-	switch os.Args[1] {
-	case "getbalance":
-		err := getBalanceCmd.Parse(os.Args[2:])
-		utils.Handle(err)
-	case "createblockchain":
-		err := createBlockchainCmd.Parse(os.Args[2:])
-		utils.Handle(err)
-	case "printchain":
-		err := printChainCmd.Parse(os.Args[2:])
-		utils.Handle(err)
-	case "send":
-		err := sendCmd.Parse(os.Args[2:])
-		utils.Handle(err)
-	case "createwallet":
-		err := createWalletCmd.Parse(os.Args[2:])
-		utils.Handle(err)
-	case "listaddress":
-		err := listAddressesCmd.Parse(os.Args[2:])
-		utils.Handle(err)
-	case "reindexutxo":
-		err := reindexCmd.Parse(os.Args[2:])
-		utils.Handle(err)
+	fmt.Printf("Exported %d blocks to %s\n", count, path)
+}
 
-	default:
-		cli.PrintUsage()
-		runtime.Goexit()
+func (cli *CommandLine) ImportChain(path string, flatFiles bool) {
+	chain := models.OpenBlockChain()
+	if flatFiles {
+		utils.Handle(chain.UseBlockFiles())
 	}
+	cli.enableUTXOCache(chain)
+	defer func(chain *models.BlockChain) {
+		utils.Handle(chain.FlushUTXOCache())
+		err := chain.Database.Close()
+		if err != nil {
 
-	if getBalanceCmd.Parsed() {
-		if *getBalanceAddress == "" {
-			getBalanceCmd.Usage()
-			runtime.Goexit()
 		}
-		cli.GetBalance(*getBalanceAddress)
+	}(chain)
+	stopGC := chain.StartValueLogGC(gcInterval)
+	defer stopGC()
+
+	stats, err := chain.ImportChain(path)
+	fmt.Printf("Connected %d blocks, skipped %d already known\n", stats.Connected, stats.Skipped)
+	if stats.Validation != nil {
+		fmt.Println("Snapshot history complete, waiting for the validation started in the background")
+		if err := <-stats.Validation; err != nil {
+			fmt.Printf("Snapshot not validated: %s\n", err)
+		} else {
+			fmt.Println("Snapshot validated, history matches the snapshot hash")
+		}
 	}
+	utils.Handle(err)
+}
+
+func (cli *CommandLine) VerifyBlockFiles() {
+	chain := models.ContinueBlockChain("")
+	defer func(Database *badger.DB) {
+		err := Database.Close()
+		if err != nil {
 
-	if createBlockchainCmd.Parsed() {
-		if *createBlockchainAddress == "" {
-			createBlockchainCmd.Usage()
-			runtime.Goexit()
 		}
-		cli.CreateBlockchain(*createBlockchainAddress)
+	}(chain.Database)
+
+	report, err := chain.VerifyBlockFiles()
+	utils.Handle(err)
+
+	for _, problem := range report.Problems {
+		fmt.Println(problem)
 	}
+	fmt.Printf("Checked %d blocks in %d files, %d problems\n", report.Blocks, report.Files, len(report.Problems))
+}
 
-	if printChainCmd.Parsed() {
-		cli.PrintChain()
+func (cli *CommandLine) DBStats() {
+	chain := models.ContinueBlockChain("")
+	defer func(Database *badger.DB) {
+		err := Database.Close()
+		if err != nil {
+
+		}
+	}(chain.Database)
+
+	stats, err := chain.Stats()
+	utils.Handle(err)
+	printDBStats(stats)
+}
+
+func (cli *CommandLine) CompactDB() {
+	chain := models.ContinueBlockChain("")
+	defer func(Database *badger.DB) {
+		err := Database.Close()
+		if err != nil {
+
+		}
+	}(chain.Database)
+
+	result, err := chain.CompactDB()
+	utils.Handle(err)
+
+	fmt.Println("Before:")
+	printDBStats(result.Before)
+	fmt.Println("After:")
+	printDBStats(result.After)
+	fmt.Printf("Value log GC rewrote %d files\n", result.ValueLogCycles)
+}
+
+func printDBStats(stats models.DBStats) {
+	for _, prefix := range stats.Prefixes {
+		fmt.Printf("  %-12s %8d keys %12d bytes\n", prefix.Name, prefix.Keys, prefix.Bytes)
 	}
+	fmt.Printf("  LSM tree %d bytes, value log %d bytes", stats.LSMSize, stats.ValueLogSize)
+	if stats.BlockFilesSize > 0 {
+		fmt.Printf(", block files %d bytes", stats.BlockFilesSize)
+	}
+	fmt.Println()
+}
 
-	if createWalletCmd.Parsed() {
-		cli.CreateWallet()
+// Send pays amount through the mempool and mines a block unless queue is set. Without a fee the
+// estimate for defaultFeeBlocks is used. With a lock time the payment only goes into a block
+// above that height, or after that unix time for values from 500000000 on.
+func (cli CommandLine) Send(from, to string, amount models.Amount, fee *models.Amount, queue bool, opts models.SendOptions) {
+	if !models.ValidateAddress(from) {
+		log.Panic("From Address is invalid")
 	}
 
-	if listAddressesCmd.Parsed() {
-		cli.ListAddresses()
+	if !models.ValidateAddress(to) {
+		log.Panic("To Address is invalid")
 	}
+	chain := models.ContinueBlockChain(from)
+	cli.enableUTXOCache(chain)
+	UTXOSet := models.UTXOSet{BlockChain: chain}
+	defer func(chain *models.BlockChain) {
+		utils.Handle(chain.FlushUTXOCache())
+		err := chain.Database.Close()
+		if err != nil {
 
-	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
-			sendCmd.Usage()
-			runtime.Goexit()
 		}
+	}(chain)
 
-		cli.Send(*sendFrom, *sendTo, *sendAmount)
+	setFee(chain, fee, &opts)
+	tx := models.NewTransaction(from, to, amount, opts, &UTXOSet)
+	if submit(&UTXOSet, tx, from, queue) {
+		fmt.Println("Send transaction successfully")
 	}
+}
 
-	if reindexCmd.Parsed() {
-		cli.ReindexUTXO()
+// setFee sets the fee of opts, or the fee rate estimated for defaultFeeBlocks when fee is nil
+func setFee(chain *models.BlockChain, fee *models.Amount, opts *models.SendOptions) {
+	if fee == nil {
+		opts.FeeRate = chain.EstimateFee(defaultFeeBlocks).Rate
+	} else {
+		opts.Fee = *fee
+	}
+}
+
+// submit adds tx to the mempool and, unless queue is set, mines a block paying miner. It reports
+// whether tx was mined.
+func submit(UTXOSet *models.UTXOSet, tx *models.Transaction, miner string, queue bool) bool {
+	chain := UTXOSet.BlockChain
+	entry, err := chain.AddToMempool(tx)
+	utils.Handle(err)
+	fmt.Printf("Transaction %x pays a fee of %s, %d per byte\n", tx.ID, entry.Fee, entry.FeeRate())
+
+	if queue {
+		fmt.Println("Transaction queued in the mempool")
+		return false
+	}
+	block := chain.MineBlock(miner)
+	for _, mined := range block.Transactions {
+		if bytes.Equal(mined.ID, tx.ID) {
+			return true
+		}
+	}
+	fmt.Printf("Transaction left in the mempool, the block at height %d was full or it is locked\n", block.Height)
+	return false
+}
+
+// Mine mines the mempool transactions paying the most into a new block, whose subsidy and fees
+// go to miner
+func (cli CommandLine) Mine(miner string) {
+	chain := models.ContinueBlockChain("")
+	cli.enableUTXOCache(chain)
+	defer func(chain *models.BlockChain) {
+		utils.Handle(chain.FlushUTXOCache())
+		err := chain.Database.Close()
+		if err != nil {
+
+		}
+	}(chain)
+
+	block := chain.MineBlock(miner)
+	fmt.Printf("Mined block %x at height %d with %d transactions, %d left in the mempool\n",
+		block.Hash, block.Height, len(block.Transactions), len(chain.MempoolEntries()))
+}
+
+// EstimateFee prints the fee rate expected to get a transaction mined within blocks blocks
+func (cli CommandLine) EstimateFee(blocks int) {
+	chain := models.ContinueBlockChain("")
+	defer func(Database *badger.DB) {
+		err := Database.Close()
+		if err != nil {
+
+		}
+	}(chain.Database)
+
+	estimate := chain.EstimateFee(blocks)
+	fmt.Printf("Fee rate: %d per byte, %s for a 250 byte transaction\n", estimate.Rate, estimate.Rate.Fee(250))
+	if estimate.FromHistory {
+		fmt.Printf("Based on %d transactions mined in the last blocks\n", estimate.Observations)
+	} else {
+		fmt.Printf("Not enough history, %d transactions mined in the last blocks, using the minimum\n", estimate.Observations)
+	}
+	fmt.Printf("Mempool: %d bytes waiting, blocks take %d\n", estimate.MempoolBytes, models.MaxBlockSize)
+}
+
+// GetDeploymentInfo prints the state of every deployment for the next block and how many blocks
+// of the current window signal it
+func (cli CommandLine) GetDeploymentInfo() {
+	chain := models.ContinueBlockChain("")
+	defer func(Database *badger.DB) {
+		err := Database.Close()
+		if err != nil {
+
+		}
+	}(chain.Database)
+
+	infos, height := chain.DeploymentInfos()
+	fmt.Printf("Next block: height %d, windows of %d blocks lock a deployment in with %d signalling\n",
+		height, models.DeploymentWindow, models.DeploymentThreshold)
+	for _, info := range infos {
+		fmt.Printf("%s (bit %d): %s", info.Name, info.Bit, info.State)
+		switch info.State {
+		case models.DeploymentDefined:
+			fmt.Printf(", signalling counts from height %d\n", info.SignallingStart())
+		case models.DeploymentStarted:
+			fmt.Printf(" at height %d, %d of %d blocks signalling in this window, fails from height %d\n",
+				info.Since, info.Signals, info.Elapsed, info.TimeoutHeight)
+		case models.DeploymentLockedIn:
+			fmt.Printf(" at height %d, active from height %d\n", info.Since, info.ActivationHeight())
+		default:
+			fmt.Printf(" from height %d\n", info.Since)
+		}
+	}
+}
+
+// SendMany pays every payment in one transaction through the mempool, after checking all the
+// addresses. Without a fee the estimate for defaultFeeBlocks is used.
+func (cli CommandLine) SendMany(from string, payments []models.Payment, fee *models.Amount, opts models.SendOptions) {
+	if !models.ValidateAddress(from) {
+		log.Panic("From Address is invalid")
+	}
+	var total models.Amount
+	for _, payment := range payments {
+		if !models.ValidateAddress(payment.Address) {
+			log.Panicf("To Address %s is invalid", payment.Address)
+		}
+		var err error
+		total, err = total.Add(payment.Amount)
+		utils.Handle(err)
+	}
+
+	chain := models.ContinueBlockChain(from)
+	cli.enableUTXOCache(chain)
+	UTXOSet := models.UTXOSet{BlockChain: chain}
+	defer func(chain *models.BlockChain) {
+		utils.Handle(chain.FlushUTXOCache())
+		err := chain.Database.Close()
+		if err != nil {
+
+		}
+	}(chain)
+
+	setFee(chain, fee, &opts)
+	tx := models.NewSendManyTransaction(from, payments, opts, &UTXOSet)
+	if !submit(&UTXOSet, tx, from, false) {
+		return
+	}
+
+	change, err := models.SendManyChange(tx, from, payments)
+	utils.Handle(err)
+	fmt.Printf("Paid %d recipients a total of %s, change %s\n", len(payments), total, change)
+	fmt.Printf("Transaction: %x\n", tx.ID)
+}
+
+// CreatePSBT funds the payments from an address and writes the unsigned transaction with the
+// outputs it spends to path, for signpsbt to sign where the keys are. Without a fee the estimate
+// for defaultFeeBlocks is used.
+func (cli CommandLine) CreatePSBT(from string, payments []models.Payment, fee *models.Amount, opts models.SendOptions, path string) {
+	if !models.ValidateAddress(from) {
+		log.Panic("From Address is invalid")
+	}
+	for _, payment := range payments {
+		if !models.ValidateAddress(payment.Address) {
+			log.Panicf("To Address %s is invalid", payment.Address)
+		}
+	}
+
+	chain := models.ContinueBlockChain(from)
+	cli.enableUTXOCache(chain)
+	UTXOSet := models.UTXOSet{BlockChain: chain}
+	defer func(chain *models.BlockChain) {
+		utils.Handle(chain.FlushUTXOCache())
+		err := chain.Database.Close()
+		if err != nil {
+
+		}
+	}(chain)
+
+	setFee(chain, fee, &opts)
+	psbt := models.NewPSBT(from, payments, opts, &UTXOSet)
+	err := os.WriteFile(path, psbt.Serialize(), 0644)
+	utils.Handle(err)
+
+	printPSBT(psbt)
+	fmt.Printf("Wrote %s\n", path)
+}
+
+// SignPSBT signs what it can of the PSBT in path with the keys in the wallet file and writes it
+// to out. It does not open the chain, so it runs on a machine holding only the wallet file.
+func (cli CommandLine) SignPSBT(path, out string, hashType models.SigHashType) {
+	psbt := readPSBT(path)
+	wallets, err := models.CreateWallets()
+	utils.Handle(err)
+
+	printPSBT(psbt)
+	signed := psbt.Sign(wallets, hashType)
+	err = os.WriteFile(out, psbt.Serialize(), 0644)
+	utils.Handle(err)
+
+	fmt.Printf("Signed %d of %d inputs, wrote %s\n", signed, len(psbt.Tx.Inputs), out)
+	if _, err := psbt.Finalize(); err != nil {
+		fmt.Printf("Not complete yet: %s\n", err)
+	} else {
+		fmt.Println("Complete, ready for finalizepsbt")
+	}
+}
+
+// CombinePSBTs merges the signatures of PSBTs signed by different key holders into out
+func (cli CommandLine) CombinePSBTs(paths []string, out string) {
+	var psbts []*models.PSBT
+	for _, path := range paths {
+		psbts = append(psbts, readPSBT(path))
+	}
+
+	combined, err := models.CombinePSBTs(psbts)
+	utils.Handle(err)
+	err = os.WriteFile(out, combined.Serialize(), 0644)
+	utils.Handle(err)
+
+	fmt.Printf("Combined %d PSBTs into %s\n", len(paths), out)
+	if _, err := combined.Finalize(); err != nil {
+		fmt.Printf("Not complete yet: %s\n", err)
+	} else {
+		fmt.Println("Complete, ready for finalizepsbt")
+	}
+}
+
+// FinalizePSBT checks that every input of the PSBT in path is signed and submits the transaction
+// like send
+func (cli CommandLine) FinalizePSBT(path, miner string, queue bool) {
+	tx, err := readPSBT(path).Finalize()
+	utils.Handle(err)
+
+	chain := models.ContinueBlockChain("")
+	cli.enableUTXOCache(chain)
+	UTXOSet := models.UTXOSet{BlockChain: chain}
+	defer func(chain *models.BlockChain) {
+		utils.Handle(chain.FlushUTXOCache())
+		err := chain.Database.Close()
+		if err != nil {
+
+		}
+	}(chain)
+
+	if submit(&UTXOSet, tx, miner, queue) {
+		fmt.Println("Send transaction successfully")
+	}
+}
+
+func readPSBT(path string) *models.PSBT {
+	data, err := os.ReadFile(path)
+	utils.Handle(err)
+	psbt, err := models.DeserializePSBT(data)
+	utils.Handle(err)
+	return psbt
+}
+
+// printPSBT shows what signing the PSBT agrees to
+func printPSBT(psbt *models.PSBT) {
+	fmt.Printf("Transaction %x\n", psbt.Tx.ID)
+	for i, prevOut := range psbt.PrevOutputs {
+		in := psbt.Tx.Inputs[i]
+		address, err := prevOut.Address()
+		if err != nil {
+			address = models.DisassembleScript(prevOut.Script)
+		}
+		fmt.Printf("  spends %x:%d, %s from %s\n", in.ID, in.Out, prevOut.Value, address)
+	}
+	for _, out := range psbt.Tx.Outputs {
+		if data, ok := out.Data(); ok {
+			fmt.Printf("  data %x\n", data)
+			continue
+		}
+		address, err := out.Address()
+		if err != nil {
+			address = models.DisassembleScript(out.Script)
+		}
+		fmt.Printf("  pays %s to %s\n", out.Value, address)
+	}
+	fee, err := psbt.Fee()
+	utils.Handle(err)
+	fmt.Printf("  fee %s\n", fee)
+}
+
+// CreateRawTransaction writes an unsigned transaction spending exactly inputs and paying
+// exactly outputs, without looking at the chain or the wallet. Whatever the inputs hold beyond
+// the outputs is the fee.
+func (cli CommandLine) CreateRawTransaction(inputs []models.TxInput, payments []models.Payment, data []byte, lockTime int) {
+	var outputs []models.TxOutput
+	for _, payment := range payments {
+		if !models.ValidateAddress(payment.Address) {
+			log.Panicf("To Address %s is invalid", payment.Address)
+		}
+		outputs = append(outputs, *models.NewTxOutput(payment.Amount, payment.Address))
+	}
+	if data != nil {
+		out, err := models.NewDataOutput(data)
+		utils.Handle(err)
+		outputs = append(outputs, *out)
+	}
+
+	tx := models.Transaction{Inputs: inputs, Outputs: outputs, LockTime: lockTime, Version: models.TxVersion}
+	tx.SetID()
+	fmt.Println(tx.Hex())
+}
+
+// rawTransaction is the JSON view of a transaction printed by decoderawtransaction
+type rawTransaction struct {
+	TxID     string      `json:"txid"`
+	Hash     string      `json:"hash"`
+	Version  uint32      `json:"version"`
+	Size     int         `json:"size"`
+	LockTime int         `json:"locktime"`
+	Inputs   []rawInput  `json:"vin"`
+	Outputs  []rawOutput `json:"vout"`
+}
+
+type rawInput struct {
+	TxID      string    `json:"txid,omitempty"`
+	Out       int       `json:"vout"`
+	Coinbase  bool      `json:"coinbase,omitempty"`
+	ScriptSig rawScript `json:"scriptSig"`
+	Sequence  uint32    `json:"sequence"`
+}
+
+type rawOutput struct {
+	Value        json.Number `json:"value"`
+	N            int         `json:"n"`
+	ScriptPubKey rawScript   `json:"scriptPubKey"`
+	Address      string      `json:"address,omitempty"`
+	Data         string      `json:"data,omitempty"`
+}
+
+type rawScript struct {
+	Asm  string `json:"asm"`
+	Hex  string `json:"hex"`
+	Type string `json:"type,omitempty"`
+}
+
+// DecodeRawTransaction prints a hex transaction as JSON
+func (cli CommandLine) DecodeRawTransaction(txHex string) {
+	tx, err := models.TransactionFromHex(txHex)
+	utils.Handle(err)
+
+	view := rawTransaction{
+		TxID:     hex.EncodeToString(tx.ID),
+		Hash:     hex.EncodeToString(tx.WitnessHash()),
+		Version:  tx.Version,
+		Size:     len(tx.Serialize()),
+		LockTime: tx.LockTime,
+		Inputs:   []rawInput{},
+		Outputs:  []rawOutput{},
+	}
+	for _, in := range tx.Inputs {
+		input := rawInput{
+			TxID:      hex.EncodeToString(in.ID),
+			Out:       in.Out,
+			ScriptSig: rawScript{Asm: models.DisassembleScript(in.ScriptSig), Hex: hex.EncodeToString(in.ScriptSig)},
+			Sequence:  in.Sequence,
+		}
+		if tx.IsCoinbase() {
+			input.Coinbase = true
+		}
+		view.Inputs = append(view.Inputs, input)
+	}
+	for i, out := range tx.Outputs {
+		output := rawOutput{
+			Value: json.Number(out.Value.String()),
+			N:     i,
+			ScriptPubKey: rawScript{
+				Asm:  models.DisassembleScript(out.Script),
+				Hex:  hex.EncodeToString(out.Script),
+				Type: models.ClassifyScript(out.Script).String(),
+			},
+		}
+		if address, err := out.Address(); err == nil {
+			output.Address = address
+		}
+		if data, ok := out.Data(); ok {
+			output.Data = hex.EncodeToString(data)
+		}
+		view.Outputs = append(view.Outputs, output)
+	}
+
+	encoded, err := json.MarshalIndent(view, "", "  ")
+	utils.Handle(err)
+	fmt.Println(string(encoded))
+}
+
+// SignRawTransaction signs the inputs of a hex transaction that the keys in the wallet file can
+// sign and prints the result. The outputs the inputs spend are looked up on the chain.
+func (cli CommandLine) SignRawTransaction(txHex string, hashType models.SigHashType) {
+	tx, err := models.TransactionFromHex(txHex)
+	utils.Handle(err)
+	wallets, err := models.CreateWallets()
+	utils.Handle(err)
+
+	chain := models.ContinueBlockChain("")
+	defer func(Database *badger.DB) {
+		err := Database.Close()
+		if err != nil {
+
+		}
+	}(chain.Database)
+
+	prevOuts, err := chain.SpentOutputs(tx)
+	utils.Handle(err)
+	psbt := &models.PSBT{Tx: *tx, PrevOutputs: prevOuts, RedeemScripts: make([][]byte, len(prevOuts))}
+	signed := psbt.Sign(wallets, hashType)
+
+	fmt.Println(psbt.Tx.Hex())
+	fmt.Printf("Signed %d of %d inputs\n", signed, len(tx.Inputs))
+	if _, err := psbt.Finalize(); err != nil {
+		fmt.Printf("Not complete yet: %s\n", err)
+	} else {
+		fmt.Println("Complete, ready for sendrawtransaction")
+	}
+}
+
+// SendRawTransaction validates a signed hex transaction and submits it like send
+func (cli CommandLine) SendRawTransaction(txHex, miner string, queue bool) {
+	tx, err := models.TransactionFromHex(txHex)
+	utils.Handle(err)
+
+	chain := models.ContinueBlockChain("")
+	cli.enableUTXOCache(chain)
+	UTXOSet := models.UTXOSet{BlockChain: chain}
+	defer func(chain *models.BlockChain) {
+		utils.Handle(chain.FlushUTXOCache())
+		err := chain.Database.Close()
+		if err != nil {
+
+		}
+	}(chain)
+
+	if submit(&UTXOSet, tx, miner, queue) {
+		fmt.Println("Send transaction successfully")
+	}
+}
+
+// parseInputs reads inputs written as TXID:OUT[:SEQUENCE],... with the sequence in decimal
+func parseInputs(list string) ([]models.TxInput, error) {
+	var inputs []models.TxInput
+	for _, item := range strings.Split(list, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 2 && len(parts) != 3 {
+			return nil, fmt.Errorf("input %q is not TXID:OUT or TXID:OUT:SEQUENCE", item)
+		}
+		txID, err := hex.DecodeString(parts[0])
+		if err != nil || len(txID) != 32 {
+			return nil, fmt.Errorf("input %q does not start with a transaction ID", item)
+		}
+		out, err := strconv.Atoi(parts[1])
+		if err != nil || out < 0 {
+			return nil, fmt.Errorf("input %q has an invalid output index", item)
+		}
+		sequence := models.SequenceFinal
+		if len(parts) == 3 {
+			parsed, err := strconv.ParseUint(parts[2], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("input %q has an invalid sequence", item)
+			}
+			sequence = uint32(parsed)
+		}
+		inputs = append(inputs, models.TxInput{ID: txID, Out: out, Sequence: sequence})
+	}
+	return inputs, nil
+}
+
+// parsePayments reads payments written as ADDRESS:AMOUNT,ADDRESS:AMOUNT,...
+func parsePayments(list string) ([]models.Payment, error) {
+	var payments []models.Payment
+	for _, item := range strings.Split(list, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("payment %q is not ADDRESS:AMOUNT", item)
+		}
+		payment, err := newPayment(parts[0], parts[1])
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+	return payments, nil
+}
+
+// readPayments reads a CSV file with an address and an amount per line. A first line of
+// "address,amount" is taken as a header and lines starting with # are skipped.
+func readPayments(path string) ([]models.Payment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	var payments []models.Payment
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return payments, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if first && strings.EqualFold(record[0], "address") {
+			continue
+		}
+		payment, err := newPayment(record[0], record[1])
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		payments = append(payments, payment)
+	}
+}
+
+// parseFee reads a fee in coins, an empty one gives nil for the estimate
+func parseFee(s string) (*models.Amount, error) {
+	if s == "" {
+		return nil, nil
+	}
+	fee, err := models.ParseAmount(s)
+	if err != nil {
+		return nil, fmt.Errorf("fee: %w", err)
+	}
+	return &fee, nil
+}
+
+func newPayment(address, amount string) (models.Payment, error) {
+	address = strings.TrimSpace(address)
+	if _, err := models.AddressScript(address); err != nil {
+		return models.Payment{}, err
+	}
+	value, err := models.ParseAmount(amount)
+	if err != nil {
+		return models.Payment{}, fmt.Errorf("payment to %s: %w", address, err)
+	}
+	if value == 0 {
+		return models.Payment{}, fmt.Errorf("amount %q for %s is not a positive number", amount, address)
+	}
+	return models.Payment{Address: address, Amount: value}, nil
+}
+
+func (cli *CommandLine) Run() {
+	cli.ValidateArgs()
+
+	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
+	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
+	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
+	sendManyCmd := flag.NewFlagSet("sendmany", flag.ExitOnError)
+	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
+	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
+	createMultiSigCmd := flag.NewFlagSet("createmultisig", flag.ExitOnError)
+	createHTLCCmd := flag.NewFlagSet("createhtlc", flag.ExitOnError)
+	redeemHTLCCmd := flag.NewFlagSet("redeemhtlc", flag.ExitOnError)
+	refundHTLCCmd := flag.NewFlagSet("refundhtlc", flag.ExitOnError)
+	inspectHTLCCmd := flag.NewFlagSet("inspecthtlc", flag.ExitOnError)
+	anchorCmd := flag.NewFlagSet("anchor", flag.ExitOnError)
+	listAddressesCmd := flag.NewFlagSet("listaddress", flag.ExitOnError)
+	reindexCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	dumpTxOutSetCmd := flag.NewFlagSet("dumptxoutset", flag.ExitOnError)
+	loadTxOutSetCmd := flag.NewFlagSet("loadtxoutset", flag.ExitOnError)
+	exportChainCmd := flag.NewFlagSet("exportchain", flag.ExitOnError)
+	importChainCmd := flag.NewFlagSet("importchain", flag.ExitOnError)
+	verifyBlockFilesCmd := flag.NewFlagSet("verifyblockfiles", flag.ExitOnError)
+	dbStatsCmd := flag.NewFlagSet("dbstats", flag.ExitOnError)
+	compactDBCmd := flag.NewFlagSet("compactdb", flag.ExitOnError)
+	estimateFeeCmd := flag.NewFlagSet("estimatefee", flag.ExitOnError)
+	getDeploymentInfoCmd := flag.NewFlagSet("getdeploymentinfo", flag.ExitOnError)
+	mineCmd := flag.NewFlagSet("mine", flag.ExitOnError)
+	createPSBTCmd := flag.NewFlagSet("createpsbt", flag.ExitOnError)
+	signPSBTCmd := flag.NewFlagSet("signpsbt", flag.ExitOnError)
+	combinePSBTCmd := flag.NewFlagSet("combinepsbt", flag.ExitOnError)
+	finalizePSBTCmd := flag.NewFlagSet("finalizepsbt", flag.ExitOnError)
+	createRawTxCmd := flag.NewFlagSet("createrawtransaction", flag.ExitOnError)
+	decodeRawTxCmd := flag.NewFlagSet("decoderawtransaction", flag.ExitOnError)
+	signRawTxCmd := flag.NewFlagSet("signrawtransaction", flag.ExitOnError)
+	sendRawTxCmd := flag.NewFlagSet("sendrawtransaction", flag.ExitOnError)
+
+	// the commands that look up or change many UTXOs share the cache size
+	for _, cmd := range []*flag.FlagSet{getBalanceCmd, sendCmd, sendManyCmd, createHTLCCmd, redeemHTLCCmd,
+		refundHTLCCmd, inspectHTLCCmd, anchorCmd, reindexCmd, importChainCmd, mineCmd, createPSBTCmd,
+		finalizePSBTCmd, sendRawTxCmd} {
+		cmd.IntVar(&cli.DBCache, "dbcache", 16, "UTXO cache size in MiB, 0 disables it")
+	}
+
+	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
+	createWalletType := createWalletCmd.String("type", "p256", "Key type: p256 or ed25519")
+	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
+	createBlockchainFlatFiles := createBlockchainCmd.Bool("flatfiles", false, "Store blocks in block files with badger as the index")
+	sendFrom := sendCmd.String("from", "", "Source wallet address")
+	sendTo := sendCmd.String("to", "", "Destination wallet address")
+	sendAmount := sendCmd.String("amount", "", "Amount of coins to send, with up to 8 decimals")
+	sendLockTime := sendCmd.Int("locktime", 0, "Block height, or unix time from 500000000 on, the payment waits for")
+	sendMemo := sendCmd.String("memo", "", "Text stored with the payment in a data output")
+	sendCoins := sendCmd.String("coins", "", "Coin selection: largest, smallest, random or bnb for no change")
+	sendFee := sendCmd.String("fee", "", "Fee in coins paid to the miner, estimated when not given")
+	sendQueue := sendCmd.Bool("queue", false, "Leave the transaction in the mempool instead of mining a block")
+	sendManyFrom := sendManyCmd.String("from", "", "Source wallet address")
+	sendManyTo := sendManyCmd.String("to", "", "Comma separated ADDRESS:AMOUNT payments")
+	sendManyFile := sendManyCmd.String("file", "", "CSV file with an address and an amount per line")
+	sendManyFee := sendManyCmd.String("fee", "", "Fee in coins paid to the miner, estimated when not given")
+	sendManyCoins := sendManyCmd.String("coins", "", "Coin selection: largest, smallest, random or bnb for no change")
+	createMultiSigM := createMultiSigCmd.Int("m", 0, "Number of signatures required")
+	createMultiSigPubKeys := createMultiSigCmd.String("pubkeys", "", "Comma separated public keys or wallet addresses")
+	createHTLCFrom := createHTLCCmd.String("from", "", "Wallet address paying into the HTLC, refunded after the lock height")
+	createHTLCTo := createHTLCCmd.String("to", "", "Address that can claim the coins with the secret")
+	createHTLCAmount := createHTLCCmd.String("amount", "", "Amount of coins to lock, with up to 8 decimals")
+	createHTLCLockTime := createHTLCCmd.Int("locktime", 0, "Block height after which the coins can be refunded")
+	createHTLCHash := createHTLCCmd.String("hash", "", "Hex sha256 of the secret, a new secret is made when empty")
+	redeemHTLCScript := redeemHTLCCmd.String("script", "", "Hex redeem script of the HTLC")
+	redeemHTLCPreimage := redeemHTLCCmd.String("preimage", "", "Hex secret of the HTLC")
+	redeemHTLCTo := redeemHTLCCmd.String("to", "", "Address to send the coins to, the recipient's own by default")
+	refundHTLCScript := refundHTLCCmd.String("script", "", "Hex redeem script of the HTLC")
+	refundHTLCTo := refundHTLCCmd.String("to", "", "Address to send the coins to, the refunder's own by default")
+	inspectHTLCScript := inspectHTLCCmd.String("script", "", "Hex redeem script of the HTLC")
+	anchorFile := anchorCmd.String("file", "", "File whose sha256 is anchored")
+	anchorFrom := anchorCmd.String("from", "", "Wallet address paying for the anchor")
+	anchorVerify := anchorCmd.Bool("verify", false, "Look the file up on the chain instead of anchoring it")
+	exportChainOut := exportChainCmd.String("out", "", "Bootstrap file to write")
+	exportChainFrom := exportChainCmd.Int("from", 0, "First block height to export")
+	exportChainTo := exportChainCmd.Int("to", -1, "Last block height to export, -1 for the tip")
+	importChainIn := importChainCmd.String("in", "", "Bootstrap file to read")
+	importChainFlatFiles := importChainCmd.Bool("flatfiles", false, "Store blocks in block files when importing into an empty chain")
+	estimateFeeBlocks := estimateFeeCmd.Int("blocks", defaultFeeBlocks, "Number of blocks the transaction should be mined within")
+	mineMiner := mineCmd.String("miner", "", "Address the block subsidy and fees are paid to")
+	createPSBTFrom := createPSBTCmd.String("from", "", "Address paying, its keys may be on another machine")
+	createPSBTTo := createPSBTCmd.String("to", "", "Comma separated ADDRESS:AMOUNT payments")
+	createPSBTOut := createPSBTCmd.String("out", "", "PSBT file to write")
+	createPSBTFee := createPSBTCmd.String("fee", "", "Fee in coins paid to the miner, estimated when not given")
+	createPSBTLockTime := createPSBTCmd.Int("locktime", 0, "Block height, or unix time from 500000000 on, the payment waits for")
+	createPSBTCoins := createPSBTCmd.String("coins", "", "Coin selection: largest, smallest, random or bnb for no change")
+	signPSBTIn := signPSBTCmd.String("in", "", "PSBT file to sign")
+	signPSBTOut := signPSBTCmd.String("out", "", "PSBT file to write, the input file when empty")
+	signPSBTSigHash := signPSBTCmd.String("sighash", "ALL", "What the signatures cover: ALL, NONE or SINGLE, optionally with |ANYONECANPAY")
+	combinePSBTIn := combinePSBTCmd.String("in", "", "Comma separated PSBT files")
+	combinePSBTOut := combinePSBTCmd.String("out", "", "PSBT file to write")
+	finalizePSBTIn := finalizePSBTCmd.String("in", "", "Signed PSBT file")
+	finalizePSBTMiner := finalizePSBTCmd.String("miner", "", "Address the block subsidy and fees are paid to")
+	finalizePSBTQueue := finalizePSBTCmd.Bool("queue", false, "Leave the transaction in the mempool instead of mining a block")
+	createRawTxInputs := createRawTxCmd.String("inputs", "", "Comma separated TXID:OUT or TXID:OUT:SEQUENCE inputs")
+	createRawTxOutputs := createRawTxCmd.String("outputs", "", "Comma separated ADDRESS:AMOUNT outputs")
+	createRawTxData := createRawTxCmd.String("data", "", "Hex data for a data output after the payments")
+	createRawTxLockTime := createRawTxCmd.Int("locktime", 0, "Block height, or unix time from 500000000 on, the transaction waits for")
+	decodeRawTxHex := decodeRawTxCmd.String("hex", "", "Hex transaction")
+	signRawTxHex := signRawTxCmd.String("hex", "", "Hex transaction")
+	signRawTxSigHash := signRawTxCmd.String("sighash", "ALL", "What the signatures cover: ALL, NONE or SINGLE, optionally with |ANYONECANPAY")
+	sendRawTxHex := sendRawTxCmd.String("hex", "", "Signed hex transaction")
+	sendRawTxMiner := sendRawTxCmd.String("miner", "", "Address the block subsidy and fees are paid to")
+	sendRawTxQueue := sendRawTxCmd.Bool("queue", false, "Leave the transaction in the mempool instead of mining a block")
+
+	switch os.Args[1] {
+	case "getbalance":
+		err := getBalanceCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "createblockchain":
+		err := createBlockchainCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "printchain":
+		err := printChainCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "send":
+		err := sendCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "sendmany":
+		err := sendManyCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "createwallet":
+		err := createWalletCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "createmultisig":
+		err := createMultiSigCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "listaddress":
+		err := listAddressesCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "createhtlc":
+		err := createHTLCCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "redeemhtlc":
+		err := redeemHTLCCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "refundhtlc":
+		err := refundHTLCCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "inspecthtlc":
+		err := inspectHTLCCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "anchor":
+		err := anchorCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "reindexutxo":
+		err := reindexCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "dumptxoutset":
+		err := dumpTxOutSetCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "loadtxoutset":
+		err := loadTxOutSetCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "exportchain":
+		err := exportChainCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "importchain":
+		err := importChainCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "verifyblockfiles":
+		err := verifyBlockFilesCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "dbstats":
+		err := dbStatsCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "compactdb":
+		err := compactDBCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "estimatefee":
+		err := estimateFeeCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "getdeploymentinfo":
+		err := getDeploymentInfoCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "mine":
+		err := mineCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "createpsbt":
+		err := createPSBTCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "signpsbt":
+		err := signPSBTCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "combinepsbt":
+		err := combinePSBTCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "finalizepsbt":
+		err := finalizePSBTCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "createrawtransaction":
+		err := createRawTxCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "decoderawtransaction":
+		err := decodeRawTxCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "signrawtransaction":
+		err := signRawTxCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+	case "sendrawtransaction":
+		err := sendRawTxCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+
+	default:
+		cli.PrintUsage()
+		runtime.Goexit()
+	}
+
+	if getBalanceCmd.Parsed() {
+		if *getBalanceAddress == "" {
+			getBalanceCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.GetBalance(*getBalanceAddress)
+	}
+
+	if createBlockchainCmd.Parsed() {
+		if *createBlockchainAddress == "" {
+			createBlockchainCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.CreateBlockchain(*createBlockchainAddress, *createBlockchainFlatFiles)
+	}
+
+	if printChainCmd.Parsed() {
+		cli.PrintChain()
+	}
+
+	if createWalletCmd.Parsed() {
+		keyType, err := models.ParseKeyType(*createWalletType)
+		utils.Handle(err)
+		cli.CreateWallet(keyType)
+	}
+
+	if createMultiSigCmd.Parsed() {
+		if *createMultiSigM <= 0 || *createMultiSigPubKeys == "" {
+			createMultiSigCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.CreateMultiSig(*createMultiSigM, strings.Split(*createMultiSigPubKeys, ","))
+	}
+
+	if listAddressesCmd.Parsed() {
+		cli.ListAddresses()
+	}
+
+	if createHTLCCmd.Parsed() {
+		if *createHTLCFrom == "" || *createHTLCTo == "" || *createHTLCAmount == "" || *createHTLCLockTime <= 0 {
+			createHTLCCmd.Usage()
+			runtime.Goexit()
+		}
+		amount, err := models.ParseAmount(*createHTLCAmount)
+		utils.Handle(err)
+		cli.CreateHTLC(*createHTLCFrom, *createHTLCTo, amount, *createHTLCLockTime, *createHTLCHash)
+	}
+
+	if redeemHTLCCmd.Parsed() {
+		if *redeemHTLCScript == "" || *redeemHTLCPreimage == "" {
+			redeemHTLCCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.SpendHTLC(*redeemHTLCScript, *redeemHTLCPreimage, *redeemHTLCTo)
+	}
+
+	if refundHTLCCmd.Parsed() {
+		if *refundHTLCScript == "" {
+			refundHTLCCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.SpendHTLC(*refundHTLCScript, "", *refundHTLCTo)
+	}
+
+	if inspectHTLCCmd.Parsed() {
+		if *inspectHTLCScript == "" {
+			inspectHTLCCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.InspectHTLC(*inspectHTLCScript)
+	}
+
+	if anchorCmd.Parsed() {
+		if *anchorFile == "" || (*anchorFrom == "" && !*anchorVerify) {
+			anchorCmd.Usage()
+			runtime.Goexit()
+		}
+		if *anchorVerify {
+			cli.VerifyAnchor(*anchorFile)
+		} else {
+			cli.Anchor(*anchorFile, *anchorFrom)
+		}
+	}
+
+	if sendCmd.Parsed() {
+		if *sendFrom == "" || *sendTo == "" || *sendAmount == "" || *sendLockTime < 0 {
+			sendCmd.Usage()
+			runtime.Goexit()
+		}
+
+		if len(*sendMemo) > models.MaxDataCarrierSize {
+			fmt.Printf("Memo is %d bytes, the limit is %d\n", len(*sendMemo), models.MaxDataCarrierSize)
+			runtime.Goexit()
+		}
+		amount, err := models.ParseAmount(*sendAmount)
+		utils.Handle(err)
+		fee, err := parseFee(*sendFee)
+		utils.Handle(err)
+		coins, err := models.NewCoinSelector(*sendCoins)
+		utils.Handle(err)
+		opts := models.SendOptions{LockTime: *sendLockTime, Coins: coins}
+		if *sendMemo != "" {
+			opts.Memo = []byte(*sendMemo)
+		}
+		cli.Send(*sendFrom, *sendTo, amount, fee, *sendQueue, opts)
+	}
+
+	if sendManyCmd.Parsed() {
+		if *sendManyFrom == "" || (*sendManyTo == "" && *sendManyFile == "") {
+			sendManyCmd.Usage()
+			runtime.Goexit()
+		}
+
+		var payments []models.Payment
+		if *sendManyTo != "" {
+			listed, err := parsePayments(*sendManyTo)
+			utils.Handle(err)
+			payments = append(payments, listed...)
+		}
+		if *sendManyFile != "" {
+			read, err := readPayments(*sendManyFile)
+			utils.Handle(err)
+			payments = append(payments, read...)
+		}
+		if len(payments) == 0 {
+			fmt.Println("No payments to send")
+			runtime.Goexit()
+		}
+		fee, err := parseFee(*sendManyFee)
+		utils.Handle(err)
+		coins, err := models.NewCoinSelector(*sendManyCoins)
+		utils.Handle(err)
+		cli.SendMany(*sendManyFrom, payments, fee, models.SendOptions{Coins: coins})
+	}
+
+	if reindexCmd.Parsed() {
+		cli.ReindexUTXO()
+	}
+
+	if dumpTxOutSetCmd.Parsed() {
+		if dumpTxOutSetCmd.NArg() != 1 {
+			dumpTxOutSetCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.DumpTxOutSet(dumpTxOutSetCmd.Arg(0))
+	}
+
+	if loadTxOutSetCmd.Parsed() {
+		if loadTxOutSetCmd.NArg() != 1 {
+			loadTxOutSetCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.LoadTxOutSet(loadTxOutSetCmd.Arg(0))
+	}
+
+	if exportChainCmd.Parsed() {
+		if *exportChainOut == "" {
+			exportChainCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.ExportChain(*exportChainOut, *exportChainFrom, *exportChainTo)
+	}
+
+	if importChainCmd.Parsed() {
+		if *importChainIn == "" {
+			importChainCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.ImportChain(*importChainIn, *importChainFlatFiles)
+	}
+
+	if verifyBlockFilesCmd.Parsed() {
+		cli.VerifyBlockFiles()
+	}
+
+	if dbStatsCmd.Parsed() {
+		cli.DBStats()
+	}
+
+	if compactDBCmd.Parsed() {
+		cli.CompactDB()
+	}
+
+	if estimateFeeCmd.Parsed() {
+		if *estimateFeeBlocks < 1 {
+			estimateFeeCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.EstimateFee(*estimateFeeBlocks)
+	}
+
+	if getDeploymentInfoCmd.Parsed() {
+		cli.GetDeploymentInfo()
+	}
+
+	if mineCmd.Parsed() {
+		if !models.ValidateAddress(*mineMiner) {
+			mineCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.Mine(*mineMiner)
+	}
+
+	if createPSBTCmd.Parsed() {
+		if *createPSBTFrom == "" || *createPSBTTo == "" || *createPSBTOut == "" || *createPSBTLockTime < 0 {
+			createPSBTCmd.Usage()
+			runtime.Goexit()
+		}
+		payments, err := parsePayments(*createPSBTTo)
+		utils.Handle(err)
+		fee, err := parseFee(*createPSBTFee)
+		utils.Handle(err)
+		coins, err := models.NewCoinSelector(*createPSBTCoins)
+		utils.Handle(err)
+		opts := models.SendOptions{LockTime: *createPSBTLockTime, Coins: coins}
+		cli.CreatePSBT(*createPSBTFrom, payments, fee, opts, *createPSBTOut)
+	}
+
+	if signPSBTCmd.Parsed() {
+		if *signPSBTIn == "" {
+			signPSBTCmd.Usage()
+			runtime.Goexit()
+		}
+		out := *signPSBTOut
+		if out == "" {
+			out = *signPSBTIn
+		}
+		hashType, err := models.ParseSigHashType(*signPSBTSigHash)
+		utils.Handle(err)
+		cli.SignPSBT(*signPSBTIn, out, hashType)
+	}
+
+	if combinePSBTCmd.Parsed() {
+		if *combinePSBTIn == "" || *combinePSBTOut == "" {
+			combinePSBTCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.CombinePSBTs(strings.Split(*combinePSBTIn, ","), *combinePSBTOut)
+	}
+
+	if finalizePSBTCmd.Parsed() {
+		if *finalizePSBTIn == "" || (!*finalizePSBTQueue && !models.ValidateAddress(*finalizePSBTMiner)) {
+			finalizePSBTCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.FinalizePSBT(*finalizePSBTIn, *finalizePSBTMiner, *finalizePSBTQueue)
+	}
+
+	if createRawTxCmd.Parsed() {
+		if *createRawTxInputs == "" || *createRawTxLockTime < 0 {
+			createRawTxCmd.Usage()
+			runtime.Goexit()
+		}
+		inputs, err := parseInputs(*createRawTxInputs)
+		utils.Handle(err)
+		var payments []models.Payment
+		if *createRawTxOutputs != "" {
+			payments, err = parsePayments(*createRawTxOutputs)
+			utils.Handle(err)
+		}
+		var data []byte
+		if *createRawTxData != "" {
+			data, err = hex.DecodeString(*createRawTxData)
+			utils.Handle(err)
+		}
+		cli.CreateRawTransaction(inputs, payments, data, *createRawTxLockTime)
+	}
+
+	if decodeRawTxCmd.Parsed() {
+		if *decodeRawTxHex == "" {
+			decodeRawTxCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.DecodeRawTransaction(*decodeRawTxHex)
+	}
+
+	if signRawTxCmd.Parsed() {
+		if *signRawTxHex == "" {
+			signRawTxCmd.Usage()
+			runtime.Goexit()
+		}
+		hashType, err := models.ParseSigHashType(*signRawTxSigHash)
+		utils.Handle(err)
+		cli.SignRawTransaction(*signRawTxHex, hashType)
+	}
+
+	if sendRawTxCmd.Parsed() {
+		if *sendRawTxHex == "" || (!*sendRawTxQueue && !models.ValidateAddress(*sendRawTxMiner)) {
+			sendRawTxCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.SendRawTransaction(*sendRawTxHex, *sendRawTxMiner, *sendRawTxQueue)
 	}
 }
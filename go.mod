@@ -1,6 +1,6 @@
 module github.com/bucks-go-wallet
 
-go 1.18
+go 1.24
 
 require (
 	github.com/dgraph-io/badger v1.6.2
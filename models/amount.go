@@ -0,0 +1,82 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Amount is a number of coins in the smallest unit, a hundred millionth of a coin
+type Amount uint64
+
+const (
+	AmountDecimals = 8
+	UnitsPerCoin   = Amount(100000000)
+
+	// MaxMoney is the most an output, or all outputs or inputs of a transaction together, may
+	// hold. Amounts up to it cannot overflow when a few are added.
+	MaxMoney = 21000000 * UnitsPerCoin
+)
+
+var ErrAmountRange = fmt.Errorf("amount is above the limit of %s coins", MaxMoney)
+
+// ParseAmount reads a decimal number of coins with up to 8 decimals, like 50 or 1.2345
+func ParseAmount(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	whole, fraction, hasPoint := strings.Cut(s, ".")
+	if whole == "" && fraction == "" || hasPoint && fraction == "" {
+		return 0, fmt.Errorf("amount %q is not a decimal number", s)
+	}
+	if len(fraction) > AmountDecimals {
+		return 0, fmt.Errorf("amount %q has more than %d decimals", s, AmountDecimals)
+	}
+
+	var coins, units uint64
+	var err error
+	if whole != "" {
+		if coins, err = strconv.ParseUint(whole, 10, 64); err != nil {
+			return 0, fmt.Errorf("amount %q is not a decimal number", s)
+		}
+	}
+	if fraction != "" {
+		padded := fraction + strings.Repeat("0", AmountDecimals-len(fraction))
+		if units, err = strconv.ParseUint(padded, 10, 64); err != nil {
+			return 0, fmt.Errorf("amount %q is not a decimal number", s)
+		}
+	}
+	if coins > uint64(MaxMoney/UnitsPerCoin) {
+		return 0, ErrAmountRange
+	}
+	amount := Amount(coins)*UnitsPerCoin + Amount(units)
+	if amount > MaxMoney {
+		return 0, ErrAmountRange
+	}
+	return amount, nil
+}
+
+// String formats the amount in coins, without trailing zeros: 50, 0.5, 0.00000001
+func (a Amount) String() string {
+	s := strconv.FormatUint(uint64(a/UnitsPerCoin), 10)
+	if units := a % UnitsPerCoin; units != 0 {
+		fraction := fmt.Sprintf("%0*d", AmountDecimals, units)
+		s += "." + strings.TrimRight(fraction, "0")
+	}
+	return s
+}
+
+// Add returns a + b, or ErrAmountRange when the sum is above MaxMoney
+func (a Amount) Add(b Amount) (Amount, error) {
+	if a > MaxMoney || b > MaxMoney-a {
+		return 0, ErrAmountRange
+	}
+	return a + b, nil
+}
+
+// Sub returns a - b, or an error when b is larger
+func (a Amount) Sub(b Amount) (Amount, error) {
+	if b > a {
+		return 0, errors.New("amount would go below zero")
+	}
+	return a - b, nil
+}
@@ -0,0 +1,111 @@
+package models
+
+import "testing"
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+		ok   bool
+	}{
+		{"50", 50 * UnitsPerCoin, true},
+		{"1.2345", 123450000, true},
+		{"0.00000001", 1, true},
+		{".5", UnitsPerCoin / 2, true},
+		{" 2.5 ", 250000000, true},
+		{"0", 0, true},
+		{"21000000", MaxMoney, true},
+		{"20999999.99999999", MaxMoney - 1, true},
+
+		{"0.000000001", 0, false},
+		{"1.123456789", 0, false},
+		{"-1", 0, false},
+		{"-0.5", 0, false},
+		{"1.-5", 0, false},
+		{"+1", 0, false},
+		{"21000000.00000001", 0, false},
+		{"21000001", 0, false},
+		{"18446744073709551616", 0, false},
+		{"", 0, false},
+		{".", 0, false},
+		{"1.", 0, false},
+		{"1e8", 0, false},
+		{"1,5", 0, false},
+	}
+	for _, test := range tests {
+		got, err := ParseAmount(test.in)
+		if test.ok && (err != nil || got != test.want) {
+			t.Errorf("ParseAmount(%q) = %d, %v, want %d", test.in, got, err, test.want)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("ParseAmount(%q) = %d, want an error", test.in, got)
+		}
+	}
+
+	if _, err := ParseAmount("21000000.00000001"); err != ErrAmountRange {
+		t.Errorf("amount above MaxMoney: error %v, want %v", err, ErrAmountRange)
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	tests := []struct {
+		in   Amount
+		want string
+	}{
+		{0, "0"},
+		{1, "0.00000001"},
+		{UnitsPerCoin / 2, "0.5"},
+		{50 * UnitsPerCoin, "50"},
+		{123450000, "1.2345"},
+		{MaxMoney, "21000000"},
+	}
+	for _, test := range tests {
+		if got := test.in.String(); got != test.want {
+			t.Errorf("Amount(%d).String() = %q, want %q", uint64(test.in), got, test.want)
+		}
+	}
+
+	for _, a := range []Amount{0, 1, 10, 99999999, UnitsPerCoin, 123456789, MaxMoney - 1, MaxMoney} {
+		if back, err := ParseAmount(a.String()); err != nil || back != a {
+			t.Errorf("%d formats as %q and parses back as %d, %v", uint64(a), a.String(), back, err)
+		}
+	}
+}
+
+func TestAmountArithmetic(t *testing.T) {
+	tests := []struct {
+		a, b   Amount
+		sum    Amount
+		sumOK  bool
+		diff   Amount
+		diffOK bool
+	}{
+		{5, 3, 8, true, 2, true},
+		{3, 5, 8, true, 0, false},
+		{0, 0, 0, true, 0, true},
+		{MaxMoney, 0, MaxMoney, true, MaxMoney, true},
+		{MaxMoney, 1, 0, false, MaxMoney - 1, true},
+		{MaxMoney - 1, 1, MaxMoney, true, MaxMoney - 2, true},
+		{MaxMoney + 1, 0, 0, false, MaxMoney + 1, true},
+		// a sum that would wrap around uint64 is caught too
+		{1, ^Amount(0), 0, false, 0, false},
+		{^Amount(0), ^Amount(0), 0, false, 0, true},
+	}
+	for _, test := range tests {
+		sum, err := test.a.Add(test.b)
+		if test.sumOK && (err != nil || sum != test.sum) {
+			t.Errorf("%d + %d = %d, %v, want %d", uint64(test.a), uint64(test.b), sum, err, test.sum)
+		}
+		if !test.sumOK && err != ErrAmountRange {
+			t.Errorf("%d + %d = %d, %v, want %v", uint64(test.a), uint64(test.b), sum, err, ErrAmountRange)
+		}
+
+		diff, err := test.a.Sub(test.b)
+		if test.diffOK && (err != nil || diff != test.diff) {
+			t.Errorf("%d - %d = %d, %v, want %d", uint64(test.a), uint64(test.b), diff, err, test.diff)
+		}
+		if !test.diffOK && err == nil {
+			t.Errorf("%d - %d = %d, want an error", uint64(test.a), uint64(test.b), diff)
+		}
+	}
+}
@@ -3,8 +3,9 @@ package models
 import (
 	"bytes"
 	"crypto/sha256"
-	"encoding/gob"
+	"fmt"
 	"github.com/bucks-go-wallet/utils"
+	"time"
 )
 
 type Block struct {
@@ -12,11 +13,14 @@ type Block struct {
 	Transactions []*Transaction
 	PrevHash     []byte //represents last block hash, allow to link block together
 	Nonce        int
+	Height       int    // number of blocks before this one, 0 for the genesis block
+	Timestamp    int64  // unix time the block was mined
+	Version      uint32 // VersionBitsTopBits with the bits of the deployments the miner signals
 }
 
 // CreateBlock creates new block
-func CreateBlock(txs []*Transaction, prevHash []byte) *Block {
-	block := &Block{[]byte{}, txs, prevHash, 0}
+func CreateBlock(txs []*Transaction, prevHash []byte, height int, timestamp int64, version uint32) *Block {
+	block := &Block{[]byte{}, txs, prevHash, 0, height, timestamp, version}
 	pow := NewProof(block)
 	nonce, hash := pow.Run()
 	block.Nonce = nonce
@@ -25,27 +29,39 @@ func CreateBlock(txs []*Transaction, prevHash []byte) *Block {
 }
 
 func Cody(coinbase *Transaction) *Block {
-	return CreateBlock([]*Transaction{coinbase}, []byte{})
+	return CreateBlock([]*Transaction{coinbase}, []byte{}, 0, time.Now().Unix(), VersionBitsTopBits)
 }
 
 // Serialize converts block data structure to byte, used for badgerDB
 func (block *Block) Serialize() []byte {
-	var res bytes.Buffer
-	encoder := gob.NewEncoder(&res)
-
-	err := encoder.Encode(block)
-	utils.Handle(err)
-
-	return res.Bytes()
+	var e encoder
+	encodeBlock(&e, block)
+	return e.buf.Bytes()
 }
 
 // Deserialize converts from bytes into block structure
 func Deserialize(data []byte) *Block {
-	var block Block
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&block)
+	block, err := decodeBlock(data)
 	utils.Handle(err)
-	return &block
+	return block
+}
+
+// Validate checks that the stored hash is the proof of work over the block contents
+func (block *Block) Validate() error {
+	pow := NewProof(block)
+	if !pow.Validate() {
+		return fmt.Errorf("block %x has invalid proof of work", block.Hash)
+	}
+	hash := sha256.Sum256(pow.InitData(block.Nonce))
+	if !bytes.Equal(hash[:], block.Hash) {
+		return fmt.Errorf("block %x does not match its contents", block.Hash)
+	}
+	for _, tx := range block.Transactions {
+		if !bytes.Equal(tx.ID, tx.Hash()) {
+			return fmt.Errorf("block %x: transaction ID %x does not match its contents", block.Hash, tx.ID)
+		}
+	}
+	return nil
 }
 
 // HashTransactions allows to hashing mechanism to provide a unique representation of transactions combined
@@ -60,3 +76,16 @@ func (block *Block) HashTransactions() []byte {
 
 	return txHash[:]
 }
+
+// HashWitnesses commits to the transactions with their unlocking scripts. The IDs hashed by
+// HashTransactions leave the signatures out, this keeps them from being changed once mined.
+func (block *Block) HashWitnesses() []byte {
+	var witnessHashes [][]byte
+
+	for _, tx := range block.Transactions {
+		witnessHashes = append(witnessHashes, tx.WitnessHash())
+	}
+	hash := sha256.Sum256(bytes.Join(witnessHashes, []byte{}))
+
+	return hash[:]
+}
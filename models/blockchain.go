@@ -2,7 +2,6 @@ package models
 
 import (
 	"bytes"
-	"crypto/ecdsa"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -11,6 +10,8 @@ import (
 	"log"
 	"os"
 	"runtime"
+	"sync"
+	"time"
 )
 
 const (
@@ -19,20 +20,34 @@ const (
 	codyData = "First Transaction from Cody"
 )
 
+var ErrBlockKnown = errors.New("block already in chain")
+
+// BlockChain can be shared by many goroutines reading the chain and the UTXO set while a single
+// goroutine connects blocks. Readers use badger read transactions, which always see a consistent
+// view, and go through Tip for the last hash. Writers take turns on writeLock.
 type BlockChain struct {
 	LastHash []byte
 	Database *badger.DB
+
+	tipLock    sync.RWMutex
+	writeLock  sync.Mutex
+	utxoCache  *UTXOCache
+	blockFiles *blockFiles // nil when blocks are stored as badger values
+
+	deploymentLock  sync.Mutex
+	deploymentCache map[string]DeploymentStatus // by deployment name and the last block of a window
 }
 
 type BlockChainIterator struct {
 	CurrentHash []byte
 	Database    *badger.DB
-}
 
-// InitBlockChain create a new blockchain
-func InitBlockChain(address string) *BlockChain {
-	var lastHash []byte
+	chain *BlockChain
+}
 
+// InitBlockChain create a new blockchain, flatFiles stores the blocks in block files instead of
+// badger values
+func InitBlockChain(address string, flatFiles bool) *BlockChain {
 	if DBExists() {
 		fmt.Println("Blockchain already exists")
 		runtime.Goexit()
@@ -42,18 +57,24 @@ func InitBlockChain(address string) *BlockChain {
 	db, err := badger.Open(opts)
 	utils.Handle(err)
 
+	chain := &BlockChain{Database: db}
+	if flatFiles {
+		utils.Handle(chain.UseBlockFiles())
+	}
+
+	cbtx := CoinbaseTx(address, codyData)
+	cody := Cody(cbtx)
+	fmt.Println("Cody created")
+
 	err = db.Update(func(txn *badger.Txn) error {
-		cbtx := CoinbaseTx(address, codyData)
-		cody := Cody(cbtx)
-		fmt.Println("Cody created")
-		err = txn.Set(cody.Hash, cody.Serialize())
+		err := chain.putBlock(txn, cody)
 		utils.Handle(err)
-		err = txn.Set([]byte("lh"), cody.Hash)
-		lastHash = cody.Hash
-		return err
+		return txn.Set([]byte("lh"), cody.Hash)
 	})
 	utils.Handle(err)
-	return &BlockChain{lastHash, db}
+
+	chain.LastHash = cody.Hash
+	return chain
 }
 
 func ContinueBlockChain(address string) *BlockChain {
@@ -75,61 +96,270 @@ func ContinueBlockChain(address string) *BlockChain {
 	})
 	utils.Handle(err)
 
-	return &BlockChain{lastHash, db}
+	chain := &BlockChain{LastHash: lastHash, Database: db}
+	utils.Handle(chain.loadStorage())
+	utils.Handle(chain.checkSnapshotLoad())
+	utils.Handle(chain.catchUpUTXOSet())
+	return chain
 }
 
-func (bc *BlockChain) AddBlock(transactions []*Transaction) *Block {
+// OpenBlockChain opens the chain database, creating an empty one without a genesis block when
+// none exists yet. It is used by commands that fill the chain from elsewhere.
+func OpenBlockChain() *BlockChain {
+	chain := openBlockChain()
+	utils.Handle(chain.checkSnapshotLoad())
+	utils.Handle(chain.catchUpUTXOSet())
+	return chain
+}
+
+func openBlockChain() *BlockChain {
 	var lastHash []byte
 
-	for _, tx := range transactions {
-		if bc.VerifyTransaction(tx) != true {
-			log.Panic("Invalid Transaction")
+	opts := badger.DefaultOptions(dbPath)
+	db, err := badger.Open(opts)
+	utils.Handle(err)
+
+	err = db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte("lh"))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
 		}
+		lastHash, err = item.ValueCopy(nil)
+		return err
+	})
+	utils.Handle(err)
+
+	chain := &BlockChain{LastHash: lastHash, Database: db}
+	utils.Handle(chain.loadStorage())
+	return chain
+}
+
+// Tip returns the hash of the last block
+func (bc *BlockChain) Tip() []byte {
+	bc.tipLock.RLock()
+	defer bc.tipLock.RUnlock()
+
+	return bc.LastHash
+}
+
+func (bc *BlockChain) setTip(hash []byte) {
+	bc.tipLock.Lock()
+	defer bc.tipLock.Unlock()
+
+	bc.LastHash = hash
+}
+
+// AddBlock mines a block with the transactions on top of the tip and connects it, see
+// ConnectBlock
+func (bc *BlockChain) AddBlock(transactions []*Transaction) *Block {
+	bc.writeLock.Lock()
+	defer bc.writeLock.Unlock()
+
+	lastHash := bc.LastHash
+	lastHeight, err := bc.blockHeight(lastHash)
+	utils.Handle(err)
+
+	mtp := bc.MedianTimePast(lastHash)
+	view := newUTXOView(UTXOSet{bc})
+	if err := bc.connectTransactions(transactions, lastHeight+1, mtp, view); err != nil {
+		log.Panic("Invalid Transaction: ", err)
 	}
 
+	newBlock := CreateBlock(transactions, lastHash, lastHeight+1, blockTime(mtp), bc.blockVersion(lastHash))
+	utils.Handle(bc.connect(newBlock, view))
+
+	return newBlock
+}
+
+// GetBlock reads the block with the given hash from the database or the block files
+func (bc *BlockChain) GetBlock(hash []byte) (*Block, error) {
+	var encodedBlock []byte
+
 	err := bc.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		utils.Handle(err)
-		lastHash, _ = item.ValueCopy(nil)
+		if bc.blockFiles != nil {
+			location, err := bc.blockLocation(txn, hash)
+			if err != nil {
+				return err
+			}
+			encodedBlock, err = bc.blockFiles.read(location)
+			return err
+		}
 
+		item, err := txn.Get(hash)
+		if err != nil {
+			return err
+		}
+		encodedBlock, err = item.ValueCopy(nil)
 		return err
 	})
-	utils.Handle(err)
+	if err != nil {
+		return nil, err
+	}
 
-	newBlock := CreateBlock(transactions, lastHash)
+	return decodeBlock(encodedBlock)
+}
 
-	err = bc.Database.Update(func(txn *badger.Txn) error {
-		err := txn.Set(newBlock.Hash, newBlock.Serialize())
-		utils.Handle(err)
-		err = txn.Set([]byte("lh"), newBlock.Hash)
+// blockHeight returns the height of a stored block, or of the snapshot base on a chain started
+// from a snapshot that does not have the base block yet
+func (bc *BlockChain) blockHeight(hash []byte) (int, error) {
+	block, err := bc.GetBlock(hash)
+	if err == nil {
+		return block.Height, nil
+	}
+	if info, snapErr := bc.Snapshot(); snapErr == nil && bytes.Equal(info.BaseHash, hash) {
+		return info.BaseHeight, nil
+	}
+	return 0, err
+}
 
-		bc.LastHash = newBlock.Hash
+// Height returns the height of the last block, -1 for an empty chain
+func (bc *BlockChain) Height() int {
+	tip := bc.Tip()
+	if len(tip) == 0 {
+		return -1
+	}
+	height, err := bc.blockHeight(tip)
+	utils.Handle(err)
+	return height
+}
+
+// HasBlock reports whether the block is stored
+func (bc *BlockChain) HasBlock(hash []byte) bool {
+	key := hash
+	if bc.blockFiles != nil {
+		key = append(blockIdxPrefix, hash...)
+	}
 
+	err := bc.Database.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(key)
 		return err
 	})
+	return err == nil
+}
+
+// BlockHashes returns the hashes of the chain in height order, genesis first
+func (bc *BlockChain) BlockHashes() [][]byte {
+	hashes, err := bc.blockHashesTo(bc.Tip())
 	utils.Handle(err)
+	return hashes
+}
 
-	return newBlock
+// blockHashesTo returns the hashes of the blocks from genesis up to and including hash
+func (bc *BlockChain) blockHashesTo(hash []byte) ([][]byte, error) {
+	var hashes [][]byte
+
+	for len(hash) != 0 {
+		hashes = append(hashes, hash)
+
+		prevHash, err := bc.prevHash(hash)
+		if err != nil {
+			return nil, err
+		}
+		hash = prevHash
+	}
+
+	for i, j := 0, len(hashes)-1; i < j; i, j = i+1, j-1 {
+		hashes[i], hashes[j] = hashes[j], hashes[i]
+	}
+	return hashes, nil
 }
 
-func (bc *BlockChain) Iterator() *BlockChainIterator {
-	return &BlockChainIterator{bc.LastHash, bc.Database}
+// ConnectBlock validates a block received from outside, such as the network or a bootstrap file,
+// and makes it the new tip. The block, the tip and the UTXO changes are written in one
+// transaction so an interrupted connect leaves the chain as it was. With a UTXO cache the UTXO
+// changes are collected in the cache instead, and taken out again when the block is not stored.
+func (bc *BlockChain) ConnectBlock(block *Block) error {
+	bc.writeLock.Lock()
+	defer bc.writeLock.Unlock()
+
+	if bc.HasBlock(block.Hash) {
+		return ErrBlockKnown
+	}
+	if err := block.Validate(); err != nil {
+		return err
+	}
+	if !bytes.Equal(block.PrevHash, bc.LastHash) {
+		return fmt.Errorf("block %x does not connect to tip %x", block.Hash, bc.LastHash)
+	}
+	height := -1
+	if len(bc.LastHash) != 0 {
+		var err error
+		if height, err = bc.blockHeight(bc.LastHash); err != nil {
+			return err
+		}
+	}
+	if block.Height != height+1 {
+		return fmt.Errorf("block %x has height %d, expected %d", block.Hash, block.Height, height+1)
+	}
+
+	mtp := bc.MedianTimePast(block.PrevHash)
+	if block.Timestamp <= mtp {
+		return fmt.Errorf("block %x has timestamp %d, not after the median time past %d", block.Hash, block.Timestamp, mtp)
+	}
+	if block.Timestamp > time.Now().Add(maxFutureBlockTime).Unix() {
+		return fmt.Errorf("block %x has timestamp %d, too far in the future", block.Hash, block.Timestamp)
+	}
+
+	view := newUTXOView(UTXOSet{bc})
+	if err := bc.connectTransactions(block.Transactions, block.Height, mtp, view); err != nil {
+		return fmt.Errorf("block %x: %w", block.Hash, err)
+	}
+	return bc.connect(block, view)
 }
 
-func (i *BlockChainIterator) Next() *Block {
-	var block *Block
-	var encodedBlock []byte
-	err := i.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(i.CurrentHash)
-		utils.Handle(err)
+// connect stores a checked block as the new tip together with the UTXO changes view collected
+// for it. Callers hold the write lock.
+func (bc *BlockChain) connect(block *Block, view *utxoView) error {
+	set := UTXOSet{bc}
 
-		encodedBlock, err = item.ValueCopy(nil)
-		if err != nil {
+	// The cache takes the changes before the block is stored, so a failure to store it can
+	// still take them back
+	var rollback func()
+	if bc.utxoCache != nil {
+		rollback = bc.utxoCache.stage(block, view.changed)
+	}
+	err := bc.Database.Update(func(txn *badger.Txn) error {
+		if err := bc.putBlock(txn, block); err != nil {
+			return err
+		}
+		if err := txn.Set([]byte("lh"), block.Hash); err != nil {
 			return err
 		}
-		block = Deserialize(encodedBlock)
-		return nil
+		if err := bc.removeMined(txn, block); err != nil {
+			return err
+		}
+		if bc.utxoCache != nil {
+			return nil
+		}
+		return set.update(txn, block)
 	})
+	if err != nil {
+		if rollback != nil {
+			rollback()
+		}
+		return err
+	}
+	bc.setTip(block.Hash)
+
+	if bc.utxoCache != nil {
+		return bc.utxoCache.trim()
+	}
+	return nil
+}
+
+func (bc *BlockChain) Iterator() *BlockChainIterator {
+	return bc.iteratorFrom(bc.Tip())
+}
+
+func (bc *BlockChain) iteratorFrom(hash []byte) *BlockChainIterator {
+	return &BlockChainIterator{hash, bc.Database, bc}
+}
+
+func (i *BlockChainIterator) Next() *Block {
+	block, err := i.chain.GetBlock(i.CurrentHash)
 	utils.Handle(err)
 
 	i.CurrentHash = block.PrevHash
@@ -137,33 +367,44 @@ func (i *BlockChainIterator) Next() *Block {
 }
 
 func (bc *BlockChain) FindUTXO() map[string]TxOutputs {
+	return findUTXO(bc.Iterator())
+}
+
+// findUTXO walks the chain backwards from the iterator position and collects the outputs
+// that no later transaction spends
+func findUTXO(iter *BlockChainIterator) map[string]TxOutputs {
 	UTXOs := make(map[string]TxOutputs)
 	spentTXOs := make(map[string][]int)
 
-	iter := bc.Iterator()
-
 	for {
 		block := iter.Next()
+		mtp := iter.chain.MedianTimePast(block.PrevHash)
 
 		for _, tx := range block.Transactions {
 			txID := hex.EncodeToString(tx.ID)
 		Outputs:
 			for outIdx, out := range tx.Outputs {
+				if isUnspendable(out.Script) {
+					continue
+				}
 				if spentTXOs[txID] != nil {
 					for _, spentOut := range spentTXOs[txID] {
 						if spentOut == outIdx {
 							continue Outputs
 						}
 					}
-					outs := UTXOs[txID]
-					outs.Outputs = append(outs.Outputs, out)
-					UTXOs[txID] = outs
 				}
-				if tx.IsCoinbase() == false {
-					for _, in := range tx.Inputs {
-						inTxID := hex.EncodeToString(in.ID)
-						spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Out)
-					}
+				outs, ok := UTXOs[txID]
+				if !ok {
+					outs = TxOutputs{Height: block.Height, Time: mtp}
+				}
+				outs.add(outIdx, out)
+				UTXOs[txID] = outs
+			}
+			if tx.IsCoinbase() == false {
+				for _, in := range tx.Inputs {
+					inTxID := hex.EncodeToString(in.ID)
+					spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Out)
 				}
 			}
 		}
@@ -195,28 +436,148 @@ func (bc *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
 	return Transaction{}, errors.New("transaction does not exist")
 }
 
-func (bc *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
-	prevTXs := make(map[string]Transaction)
+// FindData returns the earliest transaction with a data output carrying data, and its block
+func (bc *BlockChain) FindData(data []byte) (Transaction, *Block, error) {
+	var found Transaction
+	var foundBlock *Block
 
-	for _, in := range tx.Inputs {
-		prevTX, err := bc.FindTransaction(in.ID)
-		utils.Handle(err)
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+	iter := bc.Iterator()
+	for len(iter.CurrentHash) != 0 {
+		block := iter.Next()
+		for _, tx := range block.Transactions {
+			for _, out := range tx.Outputs {
+				if outData, ok := out.Data(); ok && bytes.Equal(outData, data) {
+					found, foundBlock = *tx, block
+				}
+			}
+		}
+	}
+	if foundBlock == nil {
+		return Transaction{}, nil, errors.New("data is not on the chain")
+	}
+	return found, foundBlock, nil
+}
+
+// SpentOutputs returns the unspent outputs the inputs of tx spend, in input order
+func (bc *BlockChain) SpentOutputs(tx *Transaction) ([]TxOutput, error) {
+	spent, err := newUTXOView(UTXOSet{bc}).spentOutputs(tx)
+	if err != nil {
+		return nil, err
 	}
+	return outputsOf(spent), nil
+}
+
+func outputsOf(spent []spentOutput) []TxOutput {
+	prevOuts := make([]TxOutput, len(spent))
+	for i, out := range spent {
+		prevOuts[i] = out.TxOutput
+	}
+	return prevOuts
+}
+
+// SignTransaction signs the inputs of tx spending outputs of privKey, see Transaction.Sign
+func (bc *BlockChain) SignTransaction(tx *Transaction, privKey Signer) {
+	prevOuts, err := bc.SpentOutputs(tx)
+	utils.Handle(err)
+	tx.Sign(privKey, prevOuts, SigHashAll)
+}
 
-	tx.Sign(privKey, prevTXs)
+// SignMultiSigTransaction adds the signature of privKey to the inputs spending redeemScript
+func (bc *BlockChain) SignMultiSigTransaction(tx *Transaction, privKey Signer, redeemScript []byte) {
+	prevOuts, err := bc.SpentOutputs(tx)
+	utils.Handle(err)
+	tx.SignMultiSig(privKey, redeemScript, prevOuts, SigHashAll)
 }
 
+// VerifyTransaction checks the scripts, lock times and values of tx for the next block on the chain
 func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
-	prevTXs := make(map[string]Transaction)
+	tip := bc.Tip()
+	height, err := bc.blockHeight(tip)
+	if err != nil {
+		return false
+	}
+	_, err = bc.verifyTransaction(tx, height+1, bc.MedianTimePast(tip), newUTXOView(UTXOSet{bc}))
+	return err == nil
+}
 
-	for _, in := range tx.Inputs {
-		prevTX, err := bc.FindTransaction(in.ID)
-		utils.Handle(err)
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+// connectTransactions checks the transactions of a block at height, on top of a chain with
+// median time past mtp, and applies them to view in order. The first transaction, and no other,
+// is a coinbase, which pays out at most BlockSubsidy and the fees of the others.
+func (bc *BlockChain) connectTransactions(txs []*Transaction, height int, mtp int64, view *utxoView) error {
+	if len(txs) == 0 || !txs[0].IsCoinbase() {
+		return errors.New("the first transaction is not a coinbase")
 	}
 
-	return tx.Verify(prevTXs)
+	var fees Amount
+	for i, tx := range txs {
+		if i > 0 && tx.IsCoinbase() {
+			return fmt.Errorf("transaction %x is a coinbase after the first transaction", tx.ID)
+		}
+		fee, err := bc.verifyTransaction(tx, height, mtp, view)
+		if err != nil {
+			return err
+		}
+		if fees, err = fees.Add(fee); err != nil {
+			return fmt.Errorf("fees: %w", err)
+		}
+		if err := view.apply(tx, height, mtp); err != nil {
+			return err
+		}
+	}
+
+	limit, err := fees.Add(BlockSubsidy)
+	if err != nil {
+		return fmt.Errorf("fees: %w", err)
+	}
+	var paid Amount
+	for _, out := range txs[0].Outputs {
+		if paid, err = paid.Add(out.Value); err != nil {
+			return fmt.Errorf("coinbase %x: %w", txs[0].ID, err)
+		}
+	}
+	if paid > limit {
+		return fmt.Errorf("coinbase %x pays %s, the subsidy and fees are %s", txs[0].ID, paid, limit)
+	}
+	return nil
+}
+
+// verifyTransaction checks tx for a block at height on top of a chain with median time past mtp
+// and returns its fee, what its inputs hold beyond what its outputs pay. The inputs must spend
+// unspent outputs of view. Outputs, and the sums of the outputs and of the inputs, may not go
+// above MaxMoney.
+func (bc *BlockChain) verifyTransaction(tx *Transaction, height int, mtp int64, view *utxoView) (Amount, error) {
+	var outputs Amount
+	for outIdx, out := range tx.Outputs {
+		if len(out.Script) > 0 && out.Script[0] == OpReturn && ClassifyScript(out.Script) != DataCarrierScript {
+			return 0, fmt.Errorf("transaction %x output %d is not a data output of at most %d bytes", tx.ID, outIdx, MaxDataCarrierSize)
+		}
+		var err error
+		if outputs, err = outputs.Add(out.Value); err != nil {
+			return 0, fmt.Errorf("transaction %x output %d: %w", tx.ID, outIdx, err)
+		}
+	}
+	if tx.IsCoinbase() {
+		return 0, bc.checkLocks(tx, nil, height, mtp)
+	}
+
+	spent, err := view.spentOutputs(tx)
+	if err != nil {
+		return 0, err
+	}
+	if !tx.Verify(outputsOf(spent)) {
+		return 0, fmt.Errorf("transaction %x has invalid unlocking scripts", tx.ID)
+	}
+	var inputs Amount
+	for inId, out := range spent {
+		if inputs, err = inputs.Add(out.Value); err != nil {
+			return 0, fmt.Errorf("transaction %x input %d: %w", tx.ID, inId, err)
+		}
+	}
+	fee, err := inputs.Sub(outputs)
+	if err != nil {
+		return 0, fmt.Errorf("transaction %x pays %s from inputs holding %s", tx.ID, outputs, inputs)
+	}
+	return fee, bc.checkLocks(tx, spent, height, mtp)
 }
 
 func DBExists() bool {
@@ -0,0 +1,379 @@
+package models
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+const (
+	benchBlocks      = 64 // blocks connected before the benchmark starts over on a new chain
+	benchTxsPerBlock = 8
+
+	concurrentSubmitters = 4
+	coinsPerSubmitter    = 6
+	concurrentReaders    = 4
+)
+
+// chdirTemp moves into a new directory whose ../tmp/blocks is where the chain database goes
+func chdirTemp(tb testing.TB) {
+	base := tb.TempDir()
+	dir := filepath.Join(base, "run")
+	for _, d := range []string{dir, filepath.Join(base, "tmp")} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { os.Chdir(wd) })
+}
+
+// quiet runs fn with stdout discarded, mining prints every hash it tries
+func quiet(tb testing.TB, fn func()) {
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = null
+	defer func() {
+		os.Stdout = stdout
+		null.Close()
+	}()
+	fn()
+}
+
+type benchCoin struct {
+	txID  []byte
+	index int
+	out   TxOutput
+}
+
+// benchmarkBlocks mines a chain, genesis first, whose blocks hold a coinbase and transactions
+// splitting the oldest coins of one wallet in two, benchTxsPerBlock transactions in all
+func benchmarkBlocks(b testing.TB) []*Block {
+	chdirTemp(b)
+	w := MakeWallet(KeyP256)
+	address := string(w.Address())
+
+	var blocks []*Block
+	quiet(b, func() {
+		chain := InitBlockChain(address, false)
+		defer chain.Database.Close()
+		set := UTXOSet{chain}
+		set.Reindex()
+
+		genesis, err := chain.GetBlock(chain.Tip())
+		if err != nil {
+			b.Fatal(err)
+		}
+		blocks = append(blocks, genesis)
+		coins := []benchCoin{{genesis.Transactions[0].ID, 0, genesis.Transactions[0].Outputs[0]}}
+
+		for i := 1; i < benchBlocks; i++ {
+			txs := []*Transaction{CoinbaseTx(address, fmt.Sprintf("bench %d", i))}
+			for len(coins) > 0 && len(txs) < benchTxsPerBlock {
+				coin := coins[0]
+				coins = coins[1:]
+				half := coin.out.Value / 2
+				tx := Transaction{nil, []TxInput{{coin.txID, coin.index, nil, SequenceFinal}},
+					[]TxOutput{*NewTxOutput(half, address), *NewTxOutput(coin.out.Value-half, address)}, 0, TxVersion}
+				tx.SetID()
+				tx.Sign(w.PrivateKey, []TxOutput{coin.out}, SigHashAll)
+				txs = append(txs, &tx)
+			}
+
+			block := chain.AddBlock(txs)
+			blocks = append(blocks, block)
+			for _, tx := range txs {
+				for outIdx, out := range tx.Outputs {
+					coins = append(coins, benchCoin{tx.ID, outIdx, out})
+				}
+			}
+		}
+	})
+	return blocks
+}
+
+// BenchmarkConnectBlock connects mined blocks to a new chain, with the UTXO set in badger and
+// with a UTXO cache in front of it
+func BenchmarkConnectBlock(b *testing.B) {
+	blocks := benchmarkBlocks(b)
+
+	b.Run("badger", func(b *testing.B) { benchmarkConnectBlock(b, blocks, 0) })
+	b.Run("cache", func(b *testing.B) { benchmarkConnectBlock(b, blocks, 64<<20) })
+}
+
+func benchmarkConnectBlock(b *testing.B, blocks []*Block, cacheSize int) {
+	var chain *BlockChain
+	next := len(blocks)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if next == len(blocks) {
+			b.StopTimer()
+			if chain != nil {
+				chain.Database.Close()
+			}
+			chdirTemp(b)
+			chain = OpenBlockChain()
+			if cacheSize > 0 {
+				chain.EnableUTXOCache(cacheSize)
+			}
+			next = 0
+			b.StartTimer()
+		}
+		if err := chain.ConnectBlock(blocks[next]); err != nil {
+			b.Fatal(err)
+		}
+		next++
+	}
+	b.StopTimer()
+
+	if err := chain.FlushUTXOCache(); err != nil {
+		b.Fatal(err)
+	}
+	chain.Database.Close()
+}
+
+// TestConcurrentChainAccess runs wallets signing and submitting transactions, a miner and readers
+// of the chain and the UTXO set at the same time, run it with -race
+func TestConcurrentChainAccess(t *testing.T) {
+	t.Run("badger", func(t *testing.T) { testConcurrentChainAccess(t, 0) })
+	t.Run("cache", func(t *testing.T) { testConcurrentChainAccess(t, 64<<20) })
+}
+
+func testConcurrentChainAccess(t *testing.T, cacheSize int) {
+	chdirTemp(t)
+	w := MakeWallet(KeyP256)
+	address := string(w.Address())
+	wallets := &Wallets{map[string]*Wallet{address: w}, map[string][]byte{}}
+	script := PayToPubKeyHashScript(PublicKeyHash(w.PublicKey))
+	const fee = Amount(1000)
+
+	quiet(t, func() {
+		chain := InitBlockChain(address, false)
+		defer chain.Database.Close()
+		if cacheSize > 0 {
+			chain.EnableUTXOCache(cacheSize)
+		}
+		set := UTXOSet{chain}
+		set.Reindex()
+
+		// split the genesis coin so every submitter has coins of its own
+		genesis, err := chain.GetBlock(chain.Tip())
+		if err != nil {
+			t.Fatal(err)
+		}
+		coinbase := genesis.Transactions[0]
+		n := concurrentSubmitters * coinsPerSubmitter
+		split := Transaction{nil, []TxInput{{coinbase.ID, 0, nil, SequenceFinal}}, nil, 0, TxVersion}
+		for i := 0; i < n; i++ {
+			split.Outputs = append(split.Outputs, *NewTxOutput((coinbase.Outputs[0].Value-fee)/Amount(n), address))
+		}
+		split.SetID()
+		split.Sign(w.PrivateKey, coinbase.Outputs, SigHashAll)
+		if _, err := chain.AddToMempool(&split); err != nil {
+			t.Fatal(err)
+		}
+		chain.MineBlock(address)
+
+		var submitted sync.Map
+		var submitters, others sync.WaitGroup
+		done := make(chan struct{})
+
+		for i := 0; i < concurrentSubmitters; i++ {
+			submitters.Add(1)
+			go func(first int) {
+				defer submitters.Done()
+				for index := first; index < first+coinsPerSubmitter; index++ {
+					prevOut := split.Outputs[index]
+					half := (prevOut.Value - fee) / 2
+					tx := Transaction{nil, []TxInput{{split.ID, index, nil, SequenceFinal}},
+						[]TxOutput{*NewTxOutput(half, address), *NewTxOutput(prevOut.Value-fee-half, address)}, 0, TxVersion}
+					tx.SetID()
+					psbt := PSBT{tx, []TxOutput{prevOut}, make([][]byte, 1)}
+					if signed := psbt.Sign(wallets, SigHashAll); signed != 1 {
+						t.Errorf("signed %d inputs of %x", signed, tx.ID)
+						return
+					}
+					final, err := psbt.Finalize()
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if _, err := chain.AddToMempool(final); err != nil {
+						t.Error(err)
+						return
+					}
+					submitted.Store(hex.EncodeToString(final.ID), true)
+				}
+			}(i * coinsPerSubmitter)
+		}
+
+		others.Add(1)
+		go func() {
+			defer others.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				chain.MineBlock(address)
+			}
+		}()
+
+		for i := 0; i < concurrentReaders; i++ {
+			others.Add(1)
+			go func() {
+				defer others.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					readConcurrently(t, chain, wallets, script)
+				}
+			}()
+		}
+
+		// the submitters finish first, then the miner and readers are stopped
+		submitters.Wait()
+		close(done)
+		others.Wait()
+		if t.Failed() {
+			return
+		}
+		for len(chain.MempoolEntries()) > 0 {
+			chain.MineBlock(address)
+		}
+
+		submitted.Range(func(id, _ interface{}) bool {
+			txID, _ := hex.DecodeString(id.(string))
+			if _, err := chain.FindTransaction(txID); err != nil {
+				t.Errorf("transaction %s was not mined: %v", id, err)
+			}
+			return true
+		})
+
+		if err := chain.FlushUTXOCache(); err != nil {
+			t.Fatal(err)
+		}
+		chain.utxoCache = nil
+		got := make(map[string]TxOutputs)
+		set.forEach(func(txID []byte, outs TxOutputs) {
+			got[hex.EncodeToString(txID)] = outs
+		})
+		if want := chain.FindUTXO(); !reflect.DeepEqual(got, want) {
+			t.Errorf("UTXO set has %d entries, the chain %d", len(got), len(want))
+		}
+	})
+}
+
+// readConcurrently checks what readers see while blocks are added: the tip is a stored block
+// linked back to genesis, and since the miner gets every fee back the wallet balance is whole
+// block subsidies
+func readConcurrently(t *testing.T, chain *BlockChain, wallets *Wallets, script []byte) {
+	tip := chain.Tip()
+	block, err := chain.GetBlock(tip)
+	if err != nil {
+		t.Errorf("tip %x: %v", tip, err)
+		return
+	}
+	height := block.Height
+	for iter := chain.iteratorFrom(tip); ; {
+		b := iter.Next()
+		if b.Height != height {
+			t.Errorf("block %x at height %d, want %d", b.Hash, b.Height, height)
+			return
+		}
+		if len(b.PrevHash) == 0 {
+			break
+		}
+		height--
+	}
+	if height != 0 {
+		t.Errorf("chain from %x ends at height %d", tip, height)
+	}
+
+	set := UTXOSet{chain}
+	var balance Amount
+	for _, coin := range set.FindCoins(script) {
+		balance += coin.Value
+	}
+	if balance%BlockSubsidy != 0 {
+		t.Errorf("balance %s is not whole block subsidies", balance)
+	}
+	if _, ok := wallets.walletForPubKeyHash(script[3:23]); !ok {
+		t.Error("wallet not found for its own key")
+	}
+	chain.MempoolEntries()
+	chain.EstimateFee(6)
+}
+
+// testSpend pays value of the output index of prev, which w holds, to address
+func testSpend(w *Wallet, prev *Transaction, index int, value Amount, address string) *Transaction {
+	tx := Transaction{nil, []TxInput{{prev.ID, index, nil, SequenceFinal}}, []TxOutput{*NewTxOutput(value, address)}, 0, TxVersion}
+	tx.SetID()
+	tx.Sign(w.PrivateKey, []TxOutput{prev.Outputs[index]}, SigHashAll)
+	return &tx
+}
+
+// TestMineBlockConnectsUTXOChanges checks that once MineBlock returns, the UTXO set is at the
+// new tip, so the mempool turns away a transaction spending what the block spent
+func TestMineBlockConnectsUTXOChanges(t *testing.T) {
+	t.Run("badger", func(t *testing.T) { testMineBlockConnectsUTXOChanges(t, 0) })
+	t.Run("cache", func(t *testing.T) { testMineBlockConnectsUTXOChanges(t, 64<<20) })
+}
+
+func testMineBlockConnectsUTXOChanges(t *testing.T, cacheSize int) {
+	chdirTemp(t)
+	w := MakeWallet(KeyP256)
+	address := string(w.Address())
+
+	quiet(t, func() {
+		chain := InitBlockChain(address, false)
+		defer chain.Database.Close()
+		if cacheSize > 0 {
+			chain.EnableUTXOCache(cacheSize)
+		}
+		UTXOSet{chain}.Reindex()
+
+		genesis, err := chain.GetBlock(chain.Tip())
+		if err != nil {
+			t.Fatal(err)
+		}
+		coinbase := genesis.Transactions[0]
+		if _, err := chain.AddToMempool(testSpend(w, coinbase, 0, BlockSubsidy-1000, address)); err != nil {
+			t.Fatal(err)
+		}
+		block := chain.MineBlock(address)
+		if len(block.Transactions) != 2 {
+			t.Fatalf("block has %d transactions, want the coinbase and the spend", len(block.Transactions))
+		}
+
+		if cacheSize == 0 {
+			if tip, err := chain.utxoTip(); err != nil || !reflect.DeepEqual(tip, block.Hash) {
+				t.Errorf("UTXO tip %x, %v, want the new block %x", tip, err, block.Hash)
+			}
+		}
+		if _, ok := (UTXOSet{chain}).FindOutput(coinbase.ID, 0); ok {
+			t.Error("the output the block spent is still in the UTXO set")
+		}
+		if _, err := chain.AddToMempool(testSpend(w, coinbase, 0, BlockSubsidy-2000, address)); err == nil {
+			t.Error("the mempool took a transaction spending an output the block spent")
+		}
+	})
+}
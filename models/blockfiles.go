@@ -0,0 +1,334 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// In flat-file mode blocks are appended to rotating blkNNNNN.dat files and badger only keeps an
+// index entry per block under blkidx-<hash>. Each record in a file is
+//
+//	magic "BLK1" | length (u32) | serialized block
+//
+// and the index entry holds the file, the offset of the serialized block, its length and crc32,
+// plus the header fields needed to walk the chain without reading the files.
+const (
+	blockFilesPath   = "../tmp/blockfiles"
+	maxBlockFileSize = 128 << 20
+	storageFlat      = "flat"
+)
+
+var (
+	blockFileMagic = []byte("BLK1")
+	blockIdxPrefix = []byte("blkidx-")
+	storageKey     = []byte("storage")
+)
+
+type blockFiles struct {
+	dir     string
+	current int   // number of the file being appended to
+	size    int64 // its current size
+}
+
+// blockLocation is the index entry of a block stored in a block file
+type blockLocation struct {
+	File     int
+	Offset   int64
+	Length   int
+	Checksum uint32
+	PrevHash []byte
+	Nonce    int
+	TxCount  int
+}
+
+// BlockFileReport is the result of checking the block files against the index
+type BlockFileReport struct {
+	Blocks   int
+	Files    int
+	Problems []string
+}
+
+func blockFileName(dir string, number int) string {
+	return filepath.Join(dir, fmt.Sprintf("blk%05d.dat", number))
+}
+
+// openBlockFiles finds the last block file in dir so appends continue where they stopped
+func openBlockFiles(dir string) (*blockFiles, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	names, err := filepath.Glob(filepath.Join(dir, "blk*.dat"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	files := &blockFiles{dir: dir}
+	if len(names) > 0 {
+		last := names[len(names)-1]
+		if _, err := fmt.Sscanf(filepath.Base(last), "blk%05d.dat", &files.current); err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(last)
+		if err != nil {
+			return nil, err
+		}
+		files.size = info.Size()
+	}
+	return files, nil
+}
+
+// append writes the block to the current file, moving to a new file when it is full. Callers
+// hold the chain write lock.
+func (bf *blockFiles) append(block *Block) (blockLocation, error) {
+	data := block.Serialize()
+	recordSize := int64(len(blockFileMagic) + 4 + len(data))
+
+	if bf.size > 0 && bf.size+recordSize > maxBlockFileSize {
+		bf.current++
+		bf.size = 0
+	}
+
+	file, err := os.OpenFile(blockFileName(bf.dir, bf.current), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return blockLocation{}, err
+	}
+	defer file.Close()
+
+	record := make([]byte, recordSize)
+	copy(record, blockFileMagic)
+	binary.BigEndian.PutUint32(record[len(blockFileMagic):], uint32(len(data)))
+	copy(record[len(blockFileMagic)+4:], data)
+	if _, err := file.Write(record); err != nil {
+		return blockLocation{}, err
+	}
+	if err := file.Sync(); err != nil {
+		return blockLocation{}, err
+	}
+
+	location := blockLocation{
+		File:     bf.current,
+		Offset:   bf.size + int64(len(blockFileMagic)+4),
+		Length:   len(data),
+		Checksum: crc32.ChecksumIEEE(data),
+		PrevHash: block.PrevHash,
+		Nonce:    block.Nonce,
+		TxCount:  len(block.Transactions),
+	}
+	bf.size += recordSize
+	return location, nil
+}
+
+// read loads the serialized block at the location and checks it against the index checksum
+func (bf *blockFiles) read(location blockLocation) ([]byte, error) {
+	file, err := os.Open(blockFileName(bf.dir, location.File))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data := make([]byte, location.Length)
+	if _, err := file.ReadAt(data, location.Offset); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(data) != location.Checksum {
+		return nil, fmt.Errorf("checksum mismatch in %s at offset %d", blockFileName(bf.dir, location.File), location.Offset)
+	}
+	return data, nil
+}
+
+func (location blockLocation) serialize() []byte {
+	var e encoder
+	e.putUint32(uint32(location.File))
+	e.putInt64(location.Offset)
+	e.putUint32(uint32(location.Length))
+	e.putUint32(location.Checksum)
+	e.putBytes(location.PrevHash)
+	e.putInt64(int64(location.Nonce))
+	e.putUint32(uint32(location.TxCount))
+	return e.buf.Bytes()
+}
+
+func deserializeBlockLocation(data []byte) (blockLocation, error) {
+	var location blockLocation
+	d := decoder{data: data}
+
+	location.File = int(d.uint32())
+	location.Offset = d.int64()
+	location.Length = int(d.uint32())
+	location.Checksum = d.uint32()
+	location.PrevHash = d.bytes()
+	location.Nonce = int(d.int64())
+	location.TxCount = int(d.uint32())
+
+	return location, d.finish()
+}
+
+// loadStorage switches the chain to block files when the database was created in that mode
+func (bc *BlockChain) loadStorage() error {
+	var mode []byte
+	err := bc.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(storageKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		mode, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil || string(mode) != storageFlat {
+		return err
+	}
+
+	bc.blockFiles, err = openBlockFiles(blockFilesPath)
+	return err
+}
+
+// UseBlockFiles makes an empty chain store its blocks in flat files from now on
+func (bc *BlockChain) UseBlockFiles() error {
+	if len(bc.Tip()) != 0 {
+		return errors.New("the storage mode can only be chosen for an empty chain")
+	}
+
+	files, err := openBlockFiles(blockFilesPath)
+	if err != nil {
+		return err
+	}
+	err = bc.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(storageKey, []byte(storageFlat))
+	})
+	if err != nil {
+		return err
+	}
+
+	bc.blockFiles = files
+	return nil
+}
+
+// putBlock stores the block inside an open transaction, either as a badger value or appended to
+// a block file with an index entry
+func (bc *BlockChain) putBlock(txn *badger.Txn, block *Block) error {
+	if bc.blockFiles == nil {
+		return txn.Set(block.Hash, block.Serialize())
+	}
+
+	location, err := bc.blockFiles.append(block)
+	if err != nil {
+		return err
+	}
+	return txn.Set(append(blockIdxPrefix, block.Hash...), location.serialize())
+}
+
+// blockLocation reads the index entry of a block stored in a block file
+func (bc *BlockChain) blockLocation(txn *badger.Txn, hash []byte) (blockLocation, error) {
+	item, err := txn.Get(append(blockIdxPrefix, hash...))
+	if err != nil {
+		return blockLocation{}, err
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return blockLocation{}, err
+	}
+	return deserializeBlockLocation(v)
+}
+
+// prevHash returns the parent of a block, from the index alone in flat-file mode
+func (bc *BlockChain) prevHash(hash []byte) ([]byte, error) {
+	if bc.blockFiles == nil {
+		block, err := bc.GetBlock(hash)
+		if err != nil {
+			return nil, err
+		}
+		return block.PrevHash, nil
+	}
+
+	var location blockLocation
+	err := bc.Database.View(func(txn *badger.Txn) error {
+		var err error
+		location, err = bc.blockLocation(txn, hash)
+		return err
+	})
+	return location.PrevHash, err
+}
+
+// VerifyBlockFiles reads every indexed block back from the block files, checks its checksum and
+// hash, and reports file data that no index entry points to
+func (bc *BlockChain) VerifyBlockFiles() (BlockFileReport, error) {
+	var report BlockFileReport
+
+	if bc.blockFiles == nil {
+		return report, errors.New("chain does not use block files")
+	}
+
+	extents := make(map[int]int64)
+	err := bc.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(blockIdxPrefix); it.ValidForPrefix(blockIdxPrefix); it.Next() {
+			hash := bytes.TrimPrefix(it.Item().KeyCopy(nil), blockIdxPrefix)
+			v, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			location, err := deserializeBlockLocation(v)
+			if err != nil {
+				report.Problems = append(report.Problems, fmt.Sprintf("block %x: bad index entry: %s", hash, err))
+				continue
+			}
+
+			report.Blocks++
+			if end := location.Offset + int64(location.Length); end > extents[location.File] {
+				extents[location.File] = end
+			}
+
+			data, err := bc.blockFiles.read(location)
+			if err != nil {
+				report.Problems = append(report.Problems, fmt.Sprintf("block %x: %s", hash, err))
+				continue
+			}
+			block, err := decodeBlock(data)
+			if err != nil {
+				report.Problems = append(report.Problems, fmt.Sprintf("block %x: %s", hash, err))
+				continue
+			}
+			if !bytes.Equal(block.Hash, hash) || !bytes.Equal(block.PrevHash, location.PrevHash) {
+				report.Problems = append(report.Problems, fmt.Sprintf("block %x: file holds block %x", hash, block.Hash))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for number := 0; number <= bc.blockFiles.current; number++ {
+		info, err := os.Stat(blockFileName(bc.blockFiles.dir, number))
+		if os.IsNotExist(err) {
+			if extents[number] > 0 {
+				report.Problems = append(report.Problems, fmt.Sprintf("%s is missing", blockFileName(bc.blockFiles.dir, number)))
+			}
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		report.Files++
+		if info.Size() > extents[number] {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s has %d bytes after the last indexed block",
+				blockFileName(bc.blockFiles.dir, number), info.Size()-extents[number]))
+		}
+	}
+
+	return report, nil
+}
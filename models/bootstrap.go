@@ -0,0 +1,144 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Bootstrap file layout, all integers big endian:
+//
+//	magic "BUCKSBLK" | version (1 byte)
+//	records in height order: block length (u32) | serialized block
+const (
+	bootstrapVersion  = byte(1)
+	maxBootstrapBlock = 1 << 25
+)
+
+var bootstrapMagic = []byte("BUCKSBLK")
+
+// ImportStats counts what an import did with the blocks of a bootstrap file
+type ImportStats struct {
+	Connected int
+	Skipped   int
+
+	// Validation gets the result of validating the snapshot the chain was started from, which
+	// the import starts in the background once the history below the snapshot is stored. It is
+	// nil when no validation was started.
+	Validation <-chan error
+}
+
+// ExportChain writes the blocks from height `from` up to and including height `to` to the file
+// at path. A negative `to` exports up to the tip. It returns the number of blocks written.
+func (bc *BlockChain) ExportChain(path string, from, to int) (int, error) {
+	hashes := bc.BlockHashes()
+	if to < 0 || to >= len(hashes) {
+		to = len(hashes) - 1
+	}
+	if from < 0 || from > to {
+		return 0, fmt.Errorf("invalid height range %d-%d for a chain of %d blocks", from, to, len(hashes))
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if _, err := w.Write(append(bootstrapMagic, bootstrapVersion)); err != nil {
+		return 0, err
+	}
+
+	for _, hash := range hashes[from : to+1] {
+		block, err := bc.GetBlock(hash)
+		if err != nil {
+			return 0, err
+		}
+		data := block.Serialize()
+		if err := binary.Write(w, binary.BigEndian, uint32(len(data))); err != nil {
+			return 0, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return 0, err
+		}
+	}
+
+	return to - from + 1, w.Flush()
+}
+
+// ImportChain connects every block of a bootstrap file as if it came from the network. Blocks the
+// chain already has are skipped, so an interrupted import resumes by running it again. On a
+// chain started from a snapshot the blocks below the snapshot base are stored as history, and
+// once they are all there the snapshot is validated in the background while the import goes on
+// with the blocks above it, see ImportStats.Validation.
+func (bc *BlockChain) ImportChain(path string) (ImportStats, error) {
+	var stats ImportStats
+	if bc.SnapshotUnvalidated() {
+		stats.Validation = bc.ValidateSnapshotInBackground()
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return stats, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	header := make([]byte, len(bootstrapMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return stats, err
+	}
+	if !bytes.Equal(header[:len(bootstrapMagic)], bootstrapMagic) {
+		return stats, errors.New("not a bootstrap file")
+	}
+	if header[len(bootstrapMagic)] != bootstrapVersion {
+		return stats, fmt.Errorf("unsupported bootstrap version %d", header[len(bootstrapMagic)])
+	}
+
+	for {
+		var length uint32
+		err := binary.Read(r, binary.BigEndian, &length)
+		if err == io.EOF {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, err
+		}
+		if length > maxBootstrapBlock {
+			return stats, fmt.Errorf("block record of %d bytes exceeds limit", length)
+		}
+
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return stats, err
+		}
+		block, err := decodeBlock(data)
+		if err != nil {
+			return stats, err
+		}
+
+		history := bc.needsHistory()
+		if history {
+			err = bc.connectHistoryBlock(block)
+		} else {
+			err = bc.ConnectBlock(block)
+		}
+		if err == ErrBlockKnown {
+			stats.Skipped++
+			continue
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats.Connected++
+
+		if history && stats.Validation == nil && bc.SnapshotUnvalidated() {
+			stats.Validation = bc.ValidateSnapshotInBackground()
+		}
+	}
+}
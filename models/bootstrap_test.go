@@ -0,0 +1,96 @@
+package models
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestImportResumes(t *testing.T) {
+	t.Run("badger", func(t *testing.T) { testImportResumes(t, 0) })
+	t.Run("cache", func(t *testing.T) { testImportResumes(t, 64<<20) })
+}
+
+// testImportResumes imports a bootstrap file cut off in the middle of a block record, as when the
+// copy was still in progress, closes the chain without flushing the UTXO cache as a killed import
+// would, and imports the whole file after reopening it
+func testImportResumes(t *testing.T, cacheSize int) {
+	benchmarkBlocks(t)
+	dir := t.TempDir()
+	bootstrap := filepath.Join(dir, "bootstrap.dat")
+	truncated := filepath.Join(dir, "truncated.dat")
+
+	var tip []byte
+	var height int
+	var want map[string]TxOutputs
+	var wantInfo SnapshotInfo
+	quiet(t, func() {
+		chain := OpenBlockChain()
+		defer chain.Database.Close()
+		if _, err := chain.ExportChain(bootstrap, 0, -1); err != nil {
+			t.Fatal(err)
+		}
+		tip, height = chain.Tip(), chain.Height()
+		want = utxoEntries(chain)
+		wantInfo = UTXOSet{chain}.DumpSnapshot(filepath.Join(dir, "want.dat"))
+	})
+	data, err := os.ReadFile(bootstrap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(truncated, data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	chdirTemp(t)
+	var connected int
+	quiet(t, func() {
+		chain := OpenBlockChain()
+		defer chain.Database.Close()
+		if cacheSize > 0 {
+			chain.EnableUTXOCache(cacheSize)
+		}
+		stats, err := chain.ImportChain(truncated)
+		if err == nil {
+			t.Fatal("importing a truncated file did not fail")
+		}
+		if stats.Connected == 0 || stats.Connected >= height {
+			t.Fatalf("connected %d of %d blocks before the end of the truncated file", stats.Connected, height+1)
+		}
+		connected = stats.Connected
+	})
+
+	quiet(t, func() {
+		chain := OpenBlockChain()
+		defer chain.Database.Close()
+		if cacheSize > 0 {
+			chain.EnableUTXOCache(cacheSize)
+		}
+		if chain.Height() != connected-1 {
+			t.Errorf("reopened at height %d after connecting %d blocks", chain.Height(), connected)
+		}
+		stats, err := chain.ImportChain(bootstrap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Skipped != connected || stats.Connected != height+1-connected {
+			t.Errorf("resumed import connected %d and skipped %d, want %d and %d",
+				stats.Connected, stats.Skipped, height+1-connected, connected)
+		}
+		if !bytes.Equal(chain.Tip(), tip) {
+			t.Errorf("tip %x, want %x", chain.Tip(), tip)
+		}
+		if got := utxoEntries(chain); !reflect.DeepEqual(got, want) {
+			t.Errorf("UTXO set has %d entries, want %d", len(got), len(want))
+		}
+		info := UTXOSet{chain}.DumpSnapshot(filepath.Join(dir, "got.dat"))
+		if !bytes.Equal(info.ContentHash, wantInfo.ContentHash) {
+			t.Errorf("UTXO set hashes to %x, want %x", info.ContentHash, wantInfo.ContentHash)
+		}
+		if err := chain.FlushUTXOCache(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,204 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Coin is an unspent output a wallet can spend
+type Coin struct {
+	TxID  []byte
+	Index int
+	Value Amount
+}
+
+// CoinSelector picks the coins a transaction spends to pay target
+type CoinSelector interface {
+	Select(coins []Coin, target Amount) ([]Coin, error)
+}
+
+var (
+	ErrInsufficientFunds = errors.New("not enough funds")
+	ErrNoChangelessMatch = errors.New("no set of coins pays the amount without change")
+)
+
+// NewCoinSelector returns the selector for a strategy name: largest, smallest, random or bnb.
+// An empty name gives nil, which spends coins in UTXO set order.
+func NewCoinSelector(name string) (CoinSelector, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "largest":
+		return LargestFirst{}, nil
+	case "smallest":
+		return SmallestFirst{}, nil
+	case "random":
+		return RandomSelector{}, nil
+	case "bnb":
+		return BranchAndBound{}, nil
+	}
+	return nil, fmt.Errorf("unknown coin selection %q, use largest, smallest, random or bnb", name)
+}
+
+// takeUntil takes coins in order until they pay target
+func takeUntil(coins []Coin, target Amount) ([]Coin, error) {
+	var selected []Coin
+	var total Amount
+
+	for _, coin := range coins {
+		if total >= target && len(selected) > 0 {
+			break
+		}
+		selected = append(selected, coin)
+		total += coin.Value
+	}
+	if total < target || len(selected) == 0 {
+		return nil, ErrInsufficientFunds
+	}
+	return selected, nil
+}
+
+// sortedCoins copies coins sorted by value, ties broken by outpoint so the order is stable
+func sortedCoins(coins []Coin, descending bool) []Coin {
+	sorted := append([]Coin{}, coins...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Value != b.Value {
+			return (a.Value > b.Value) == descending
+		}
+		if string(a.TxID) != string(b.TxID) {
+			return string(a.TxID) < string(b.TxID)
+		}
+		return a.Index < b.Index
+	})
+	return sorted
+}
+
+// LargestFirst spends the largest coins first, which uses few inputs
+type LargestFirst struct{}
+
+func (LargestFirst) Select(coins []Coin, target Amount) ([]Coin, error) {
+	return takeUntil(sortedCoins(coins, true), target)
+}
+
+// SmallestFirst spends the smallest coins first, which consolidates dust
+type SmallestFirst struct{}
+
+func (SmallestFirst) Select(coins []Coin, target Amount) ([]Coin, error) {
+	return takeUntil(sortedCoins(coins, false), target)
+}
+
+// RandomSelector spends coins in random order, so the choice says less about the wallet. Rand
+// makes the order reproducible, a time seeded source is used when it is nil.
+type RandomSelector struct {
+	Rand *rand.Rand
+}
+
+func (s RandomSelector) Select(coins []Coin, target Amount) ([]Coin, error) {
+	r := s.Rand
+	if r == nil {
+		r = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	shuffled := sortedCoins(coins, true)
+	r.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return takeUntil(shuffled, target)
+}
+
+// BranchAndBound searches for coins paying target exactly, or with at most MaxExcess more, so
+// the transaction needs no change output. Of the matches found within MaxTries steps it returns
+// the one with the least excess. The excess goes to the fee, so MaxExcess is the cost of change
+// window: paying up to what a change output would cost is no worse than adding one. Transactions
+// built with SendOptions get CostOfChange at their fee rate when MaxExcess is zero.
+type BranchAndBound struct {
+	MaxExcess Amount
+	MaxTries  int // 100000 when zero
+}
+
+// CostOfChange is what a pay-to-pubkey-hash change output costs at rate: its own bytes, and
+// the bytes of the input spending it later
+func CostOfChange(rate FeeRate) Amount {
+	out := TxOutput{0, PayToPubKeyHashScript(make([]byte, 20))}
+	var e encoder
+	e.putOutput(out)
+	return rate.Fee(e.buf.Len() + inputSize(out, nil))
+}
+
+// inputSize is the size of a signed input spending prevOut
+func inputSize(prevOut TxOutput, redeemScript []byte) int {
+	tx := Transaction{nil, []TxInput{{make([]byte, 32), 0, dummyScriptSig(prevOut, redeemScript), SequenceFinal}}, nil, 0, TxVersion}
+	empty := Transaction{nil, nil, nil, 0, TxVersion}
+	return len(tx.Serialize()) - len(empty.Serialize())
+}
+
+func (s BranchAndBound) Select(coins []Coin, target Amount) ([]Coin, error) {
+	tries := s.MaxTries
+	if tries == 0 {
+		tries = 100000
+	}
+
+	sorted := sortedCoins(coins, true)
+	// remaining[i] is the value of the coins from i on, the most the rest of a branch can add
+	remaining := make([]Amount, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].Value
+	}
+	if remaining[0] < target {
+		return nil, ErrInsufficientFunds
+	}
+
+	var best []int
+	var bestExcess Amount
+	found := false
+	var picked []int
+
+	var search func(i int, total Amount)
+	search = func(i int, total Amount) {
+		if tries == 0 || found && bestExcess == 0 {
+			return
+		}
+		tries--
+
+		if total > target+s.MaxExcess || total+remaining[i] < target {
+			return
+		}
+		if total >= target {
+			if excess := total - target; !found || excess < bestExcess {
+				best = append([]int{}, picked...)
+				bestExcess = excess
+				found = true
+			}
+			return
+		}
+		if i == len(sorted) {
+			return
+		}
+
+		picked = append(picked, i)
+		search(i+1, total+sorted[i].Value)
+		picked = picked[:len(picked)-1]
+
+		// leaving out the coin also leaves out the ones of equal value after it, picking one of
+		// them instead gives the same sums as the branch above
+		next := i + 1
+		for next < len(sorted) && sorted[next].Value == sorted[i].Value {
+			next++
+		}
+		search(next, total)
+	}
+	search(0, 0)
+
+	if !found || len(best) == 0 {
+		return nil, ErrNoChangelessMatch
+	}
+	selected := make([]Coin, len(best))
+	for i, idx := range best {
+		selected[i] = sorted[idx]
+	}
+	return selected, nil
+}
@@ -0,0 +1,172 @@
+package models
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// testCoins has one coin per value, coin i has the transaction ID i
+func testCoins(values ...Amount) []Coin {
+	var coins []Coin
+	for i, value := range values {
+		coins = append(coins, Coin{[]byte{byte(i)}, 0, value})
+	}
+	return coins
+}
+
+func coinValues(coins []Coin) []Amount {
+	var values []Amount
+	for _, coin := range coins {
+		values = append(values, coin.Value)
+	}
+	return values
+}
+
+func TestCoinSelectors(t *testing.T) {
+	coins := testCoins(3, 8, 1, 5, 5, 2)
+	tests := []struct {
+		name     string
+		selector CoinSelector
+		target   Amount
+		want     []Amount
+		err      error
+	}{
+		{"largest", LargestFirst{}, 9, []Amount{8, 5}, nil},
+		{"largest exact", LargestFirst{}, 8, []Amount{8}, nil},
+		{"largest short", LargestFirst{}, 25, nil, ErrInsufficientFunds},
+		{"smallest", SmallestFirst{}, 4, []Amount{1, 2, 3}, nil},
+		{"smallest ties by outpoint", SmallestFirst{}, 12, []Amount{1, 2, 3, 5, 5}, nil},
+		{"smallest short", SmallestFirst{}, 25, nil, ErrInsufficientFunds},
+		{"bnb exact", BranchAndBound{}, 7, []Amount{5, 2}, nil},
+		{"bnb least excess", BranchAndBound{MaxExcess: 3}, 17, []Amount{8, 5, 3, 1}, nil},
+		{"bnb short", BranchAndBound{MaxExcess: 100}, 25, nil, ErrInsufficientFunds},
+	}
+	for _, test := range tests {
+		selected, err := test.selector.Select(coins, test.target)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: error %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if got := coinValues(selected); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: picked %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestBranchAndBoundWindow(t *testing.T) {
+	// the coins pay 4, 7, 10, 11, 14, 17 or 21 but never 12
+	coins := testCoins(10, 7, 4)
+	tests := []struct {
+		maxExcess Amount
+		want      []Amount
+		err       error
+	}{
+		{0, nil, ErrNoChangelessMatch},
+		{1, nil, ErrNoChangelessMatch},
+		{2, []Amount{10, 4}, nil},
+		{5, []Amount{10, 4}, nil},
+	}
+	for _, test := range tests {
+		selected, err := BranchAndBound{MaxExcess: test.maxExcess}.Select(coins, 12)
+		if !errors.Is(err, test.err) {
+			t.Errorf("window %d: error %v, want %v", test.maxExcess, err, test.err)
+			continue
+		}
+		if got := coinValues(selected); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("window %d: picked %v, want %v", test.maxExcess, got, test.want)
+		}
+	}
+}
+
+func TestRandomSelectorSeeded(t *testing.T) {
+	coins := testCoins(3, 8, 1, 5, 5, 2)
+	first, err := RandomSelector{rand.New(rand.NewSource(7))}.Select(coins, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := RandomSelector{rand.New(rand.NewSource(7))}.Select(coins, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("the same seed picked %v and %v", coinValues(first), coinValues(second))
+	}
+
+	var total Amount
+	for _, coin := range first {
+		total += coin.Value
+	}
+	if total < 10 || total-first[len(first)-1].Value >= 10 {
+		t.Errorf("picked %v for 10, more coins than needed or too few", coinValues(first))
+	}
+
+	if _, err := (RandomSelector{rand.New(rand.NewSource(7))}).Select(coins, 25); !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("error %v, want %v", err, ErrInsufficientFunds)
+	}
+}
+
+func TestBranchAndBoundMaxTries(t *testing.T) {
+	// no subset of even coins pays an odd target, only running out of tries ends the search
+	var values []Amount
+	for i := 0; i < 40; i++ {
+		values = append(values, Amount(2*i+2))
+	}
+	if _, err := (BranchAndBound{MaxTries: 1000}).Select(testCoins(values...), 101); !errors.Is(err, ErrNoChangelessMatch) {
+		t.Errorf("error %v, want %v", err, ErrNoChangelessMatch)
+	}
+}
+
+func TestCostOfChange(t *testing.T) {
+	// an output of 8 value, 4 length and 25 script bytes, an input of 4+32 ID, 4 index,
+	// 4+100 unlocking script and 4 sequence bytes
+	if got, want := CostOfChange(MinFeeRate), Amount(37+148); got != want {
+		t.Errorf("cost of change %d, want %d", got, want)
+	}
+	if got, want := CostOfChange(3), Amount(3*(37+148)); got != want {
+		t.Errorf("cost of change %d, want %d", got, want)
+	}
+}
+
+func TestFundTransactionChange(t *testing.T) {
+	from := string(MakeWallet(KeyP256).Address())
+	payee := string(MakeWallet(KeyP256).Address())
+	coins := testCoins(3*UnitsPerCoin, 8*UnitsPerCoin, 5*UnitsPerCoin+1100)
+	payment := []TxOutput{*NewTxOutput(8*UnitsPerCoin, payee)}
+	fee := Amount(1000)
+
+	// 8 coins fall 1000 short of the payment and fee, 5 and 3 pay 100 more, which is within
+	// the cost of change so it goes to the fee
+	bnb := fundTransaction(from, payment, fee, 0, coins, SendOptions{Coins: BranchAndBound{}})
+	if len(bnb.Tx.Outputs) != 1 {
+		t.Fatalf("bnb added change, outputs %v", bnb.Tx.Outputs)
+	}
+	if got, err := bnb.Fee(); err != nil || got != fee+100 {
+		t.Errorf("bnb fee %s, %v", got, err)
+	}
+
+	largest := fundTransaction(from, payment, fee, 0, coins, SendOptions{Coins: LargestFirst{}})
+	if len(largest.Tx.Outputs) != 2 {
+		t.Fatalf("largest first has no change, outputs %v", largest.Tx.Outputs)
+	}
+	if got, err := largest.Fee(); err != nil || got != fee {
+		t.Errorf("largest first fee %s, %v", got, err)
+	}
+}
+
+func TestFundTransactionInputFee(t *testing.T) {
+	from := string(MakeWallet(KeyP256).Address())
+	payee := string(MakeWallet(KeyP256).Address())
+	payment := []TxOutput{*NewTxOutput(8*UnitsPerCoin, payee)}
+
+	// less the fee of their inputs the coins pay the payment and a fee of 500 exactly
+	coins := testCoins(3*UnitsPerCoin+100, 5*UnitsPerCoin+600)
+	psbt := fundTransaction(from, payment, 500, 100, coins, SendOptions{Coins: BranchAndBound{}})
+	if len(psbt.Tx.Outputs) != 1 {
+		t.Fatalf("bnb added change, outputs %v", psbt.Tx.Outputs)
+	}
+	if got, err := psbt.Fee(); err != nil || got != 700 {
+		t.Errorf("fee %s, %v, want the fee and both inputs", got, err)
+	}
+}
@@ -0,0 +1,291 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Rule changes are deployed as soft forks signalled through block versions, as in BIP 9. A
+// block version with the top bits set to VersionBitsTopBits carries one bit per deployment. A
+// deployment is locked in once DeploymentThreshold blocks of a window of DeploymentWindow
+// signal it, and its rule applies from the window after that.
+const (
+	VersionBitsTopBits = uint32(0x20000000)
+	VersionBitsTopMask = uint32(0xe0000000)
+
+	DeploymentWindow    = 16
+	DeploymentThreshold = 12
+)
+
+// Deployment is a rule change miners turn on by signalling Bit
+type Deployment struct {
+	Name          string
+	Bit           uint
+	StartHeight   int // windows starting at or above this height count the signals
+	TimeoutHeight int // the deployment fails when it is not locked in by the window starting here
+}
+
+// Deployments lists the known rule changes. testdummy changes no rule, it exercises the
+// signalling.
+var Deployments = []Deployment{
+	{Name: "testdummy", Bit: 28, StartHeight: 0, TimeoutHeight: 1000},
+}
+
+type DeploymentState int
+
+const (
+	DeploymentDefined DeploymentState = iota
+	DeploymentStarted
+	DeploymentLockedIn
+	DeploymentActive
+	DeploymentFailed
+)
+
+func (state DeploymentState) String() string {
+	switch state {
+	case DeploymentDefined:
+		return "defined"
+	case DeploymentStarted:
+		return "started"
+	case DeploymentLockedIn:
+		return "locked in"
+	case DeploymentActive:
+		return "active"
+	case DeploymentFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("DeploymentState(%d)", int(state))
+}
+
+// DeploymentStatus is the state of a deployment for a block, and the height of the first block
+// of the window it began with
+type DeploymentStatus struct {
+	State DeploymentState
+	Since int
+}
+
+// SignallingStart returns the height of the first window that counts the signals for d
+func (d Deployment) SignallingStart() int {
+	windows := (d.StartHeight + DeploymentWindow - 1) / DeploymentWindow
+	if windows == 0 {
+		windows = 1
+	}
+	return windows * DeploymentWindow
+}
+
+// signalledBy reports whether a block version signals d
+func (d Deployment) signalledBy(version uint32) bool {
+	return version&VersionBitsTopMask == VersionBitsTopBits && version&(1<<d.Bit) != 0
+}
+
+// next returns the status for the window starting at height, from the status of the window
+// before it and the number of its blocks that signalled
+func (d Deployment) next(status DeploymentStatus, height, signals int) DeploymentStatus {
+	switch status.State {
+	case DeploymentDefined:
+		if height >= d.TimeoutHeight {
+			return DeploymentStatus{DeploymentFailed, height}
+		}
+		if height >= d.StartHeight {
+			return DeploymentStatus{DeploymentStarted, height}
+		}
+	case DeploymentStarted:
+		if signals >= DeploymentThreshold {
+			return DeploymentStatus{DeploymentLockedIn, height}
+		}
+		if height >= d.TimeoutHeight {
+			return DeploymentStatus{DeploymentFailed, height}
+		}
+	case DeploymentLockedIn:
+		return DeploymentStatus{DeploymentActive, height}
+	}
+	return status
+}
+
+// DeploymentStatus returns the status of d for the block after prevHash, an empty prevHash
+// gives the one of the genesis block. The status only changes at window boundaries and is
+// cached by the last block of each window. Below a snapshot base, whose blocks are not stored,
+// the status carried in the snapshot is used.
+func (bc *BlockChain) DeploymentStatus(d Deployment, prevHash []byte) DeploymentStatus {
+	type window struct {
+		last    []byte
+		start   int
+		signals int
+	}
+	// windows whose following status is not cached yet, newest first
+	var windows []window
+	status := DeploymentStatus{DeploymentDefined, 0}
+
+	hash := prevHash
+	for len(hash) != 0 {
+		block, err := bc.GetBlock(hash)
+		if err != nil {
+			carried, height, ok := bc.snapshotDeployment(d, hash)
+			if !ok {
+				break
+			}
+			if height%DeploymentWindow == DeploymentWindow-1 {
+				windows = append(windows, window{last: hash, start: height - DeploymentWindow + 1})
+			}
+			if len(windows) > 0 {
+				windows[len(windows)-1].signals += carried.Signals
+			}
+			status = carried.DeploymentStatus
+			break
+		}
+		if block.Height%DeploymentWindow == DeploymentWindow-1 {
+			if cached, ok := bc.cachedDeploymentStatus(d, hash); ok {
+				status = cached
+				break
+			}
+			windows = append(windows, window{last: hash, start: block.Height - DeploymentWindow + 1})
+		}
+		if len(windows) > 0 && d.signalledBy(block.Version) {
+			windows[len(windows)-1].signals++
+		}
+		hash = block.PrevHash
+	}
+
+	for i := len(windows) - 1; i >= 0; i-- {
+		status = d.next(status, windows[i].start+DeploymentWindow, windows[i].signals)
+		bc.cacheDeploymentStatus(d, windows[i].last, status)
+	}
+	return status
+}
+
+// snapshotDeployment returns what the snapshot the chain was loaded from carries for d, and the
+// height of its base, when hash is that base and the block is not stored
+func (bc *BlockChain) snapshotDeployment(d Deployment, hash []byte) (SnapshotDeployment, int, bool) {
+	info, err := bc.Snapshot()
+	if err != nil || !bytes.Equal(info.BaseHash, hash) {
+		return SnapshotDeployment{}, 0, false
+	}
+	for _, carried := range info.Deployments {
+		if carried.Name == d.Name {
+			return carried, info.BaseHeight, true
+		}
+	}
+	return SnapshotDeployment{}, 0, false
+}
+
+// snapshotDeployments returns the status of every deployment for the window holding the block
+// base at height, and the signals of that window up to it, for a snapshot taken at base
+func (bc *BlockChain) snapshotDeployments(base []byte, height int) ([]SnapshotDeployment, error) {
+	windowStart := height - height%DeploymentWindow
+
+	var deployments []SnapshotDeployment
+	for _, d := range Deployments {
+		carried := SnapshotDeployment{Name: d.Name}
+		for hash := base; ; {
+			block, err := bc.GetBlock(hash)
+			if err != nil {
+				// the base of the snapshot this chain was loaded from, in the same window
+				previous, _, ok := bc.snapshotDeployment(d, hash)
+				if !ok {
+					return nil, err
+				}
+				carried.DeploymentStatus = previous.DeploymentStatus
+				carried.Signals += previous.Signals
+				break
+			}
+			if d.signalledBy(block.Version) {
+				carried.Signals++
+			}
+			if block.Height == windowStart {
+				carried.DeploymentStatus = bc.DeploymentStatus(d, block.PrevHash)
+				break
+			}
+			hash = block.PrevHash
+		}
+		deployments = append(deployments, carried)
+	}
+	return deployments, nil
+}
+
+func (bc *BlockChain) cachedDeploymentStatus(d Deployment, last []byte) (DeploymentStatus, bool) {
+	bc.deploymentLock.Lock()
+	defer bc.deploymentLock.Unlock()
+
+	status, ok := bc.deploymentCache[d.Name+string(last)]
+	return status, ok
+}
+
+func (bc *BlockChain) cacheDeploymentStatus(d Deployment, last []byte, status DeploymentStatus) {
+	bc.deploymentLock.Lock()
+	defer bc.deploymentLock.Unlock()
+
+	if bc.deploymentCache == nil {
+		bc.deploymentCache = make(map[string]DeploymentStatus)
+	}
+	bc.deploymentCache[d.Name+string(last)] = status
+}
+
+// blockVersion returns the version of a block after prevHash, which signals every deployment
+// that is started or locked in
+func (bc *BlockChain) blockVersion(prevHash []byte) uint32 {
+	version := VersionBitsTopBits
+	for _, d := range Deployments {
+		if state := bc.DeploymentStatus(d, prevHash).State; state == DeploymentStarted || state == DeploymentLockedIn {
+			version |= 1 << d.Bit
+		}
+	}
+	return version
+}
+
+// DeploymentInfo describes a deployment for the block after the tip
+type DeploymentInfo struct {
+	Deployment
+	DeploymentStatus
+	Signals int // blocks of the current window so far that signalled
+	Elapsed int // blocks of the current window so far
+}
+
+// ActivationHeight returns the height the rule applies from, -1 when it is not locked in
+func (info DeploymentInfo) ActivationHeight() int {
+	switch info.State {
+	case DeploymentLockedIn:
+		return info.Since + DeploymentWindow
+	case DeploymentActive:
+		return info.Since
+	}
+	return -1
+}
+
+// DeploymentInfos returns the status of every deployment for the block after the tip, the
+// height of that block and the signals of its window so far
+func (bc *BlockChain) DeploymentInfos() ([]DeploymentInfo, int) {
+	tip := bc.Tip()
+	height := bc.Height() + 1
+	windowStart := height - height%DeploymentWindow
+
+	var versions []uint32
+	var missing []byte // a snapshot base in the window, the snapshot carries its signals
+	for hash := tip; len(hash) != 0; {
+		block, err := bc.GetBlock(hash)
+		if err != nil {
+			missing = hash
+			break
+		}
+		if block.Height < windowStart {
+			break
+		}
+		versions = append(versions, block.Version)
+		hash = block.PrevHash
+	}
+
+	var infos []DeploymentInfo
+	for _, d := range Deployments {
+		info := DeploymentInfo{Deployment: d, DeploymentStatus: bc.DeploymentStatus(d, tip), Elapsed: len(versions)}
+		for _, version := range versions {
+			if d.signalledBy(version) {
+				info.Signals++
+			}
+		}
+		if carried, base, ok := bc.snapshotDeployment(d, missing); ok && base >= windowStart {
+			info.Signals += carried.Signals
+			info.Elapsed += base - windowStart + 1
+		}
+		infos = append(infos, info)
+	}
+	return infos, height
+}
@@ -0,0 +1,245 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDeploymentTransitions(t *testing.T) {
+	d := Deployment{Name: "test", Bit: 28, StartHeight: 32, TimeoutHeight: 96}
+	defined := DeploymentStatus{DeploymentDefined, 0}
+	started := DeploymentStatus{DeploymentStarted, 32}
+	lockedIn := DeploymentStatus{DeploymentLockedIn, 64}
+	active := DeploymentStatus{DeploymentActive, 80}
+	failed := DeploymentStatus{DeploymentFailed, 96}
+
+	tests := []struct {
+		name    string
+		status  DeploymentStatus
+		height  int
+		signals int
+		want    DeploymentStatus
+	}{
+		{"defined before the start", defined, 16, DeploymentWindow, defined},
+		{"defined to started", defined, 32, 0, started},
+		{"defined past the timeout", defined, 96, 0, DeploymentStatus{DeploymentFailed, 96}},
+		{"started below the threshold", started, 48, DeploymentThreshold - 1, started},
+		{"started to locked in at the threshold", started, 64, DeploymentThreshold, lockedIn},
+		{"locked in with every block", started, 64, DeploymentWindow, lockedIn},
+		{"started to failed at the timeout", started, 96, DeploymentThreshold - 1, failed},
+		{"locking in beats the timeout", started, 96, DeploymentThreshold, DeploymentStatus{DeploymentLockedIn, 96}},
+		{"locked in to active", lockedIn, 80, 0, active},
+		{"active stays", active, 96, 0, active},
+		{"failed stays", failed, 112, DeploymentWindow, failed},
+	}
+	for _, test := range tests {
+		if got := d.next(test.status, test.height, test.signals); got != test.want {
+			t.Errorf("%s: %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDeploymentSignalling(t *testing.T) {
+	d := Deployment{Name: "test", Bit: 28, StartHeight: 17, TimeoutHeight: 1000}
+	if start := d.SignallingStart(); start != 32 {
+		t.Errorf("signalling starts at %d, want the window at 32", start)
+	}
+	if start := (Deployment{StartHeight: 0}).SignallingStart(); start != DeploymentWindow {
+		t.Errorf("signalling from height 0 starts at %d, want the second window", start)
+	}
+
+	for version, want := range map[uint32]bool{
+		VersionBitsTopBits | 1<<28:              true,
+		VersionBitsTopBits | 1<<27:              false,
+		VersionBitsTopBits:                      false,
+		1 << 28:                                 false,
+		0x40000000 | VersionBitsTopBits | 1<<28: false,
+	} {
+		if got := d.signalledBy(version); got != want {
+			t.Errorf("version %08x signals: %v, want %v", version, got, want)
+		}
+	}
+}
+
+// deploymentTestSignals reports whether the block at height signals bit 28: 11 blocks of the
+// window at 16 and 12 of the one at 32
+func deploymentTestSignals(height int) bool {
+	return height >= 16 && height < 27 || height >= 36 && height < 48
+}
+
+// mineVersion connects a block with only a coinbase, version and timestamp on top of the tip
+func mineVersion(t *testing.T, chain *BlockChain, address string, version uint32, timestamp int64) *Block {
+	height := chain.Height() + 1
+	coinbase := CoinbaseTx(address, fmt.Sprintf("version %d", height))
+	block := CreateBlock([]*Transaction{coinbase}, chain.Tip(), height, timestamp, version)
+	if err := chain.ConnectBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// deploymentTestChain mines blocks up to height signalling as deploymentTestSignals says and dumps
+// a snapshot at base. Every block is a second after the one before, so the median time past over
+// the few blocks a snapshot node has still rises. It returns the blocks, with the genesis block
+// first.
+func deploymentTestChain(t *testing.T, height, base int, snapshot string) []*Block {
+	chdirTemp(t)
+	address := string(MakeWallet(KeyP256).Address())
+
+	var blocks []*Block
+	quiet(t, func() {
+		chain := InitBlockChain(address, false)
+		defer chain.Database.Close()
+		UTXOSet{chain}.Reindex()
+
+		genesis, err := chain.GetBlock(chain.Tip())
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, genesis)
+		for h := 1; h <= height; h++ {
+			version := VersionBitsTopBits
+			if deploymentTestSignals(h) {
+				version |= 1 << 28
+			}
+			blocks = append(blocks, mineVersion(t, chain, address, version, genesis.Timestamp+int64(h)))
+			if h == base {
+				UTXOSet{chain}.DumpSnapshot(snapshot)
+			}
+		}
+	})
+	return blocks
+}
+
+var deploymentTests = []Deployment{
+	{Name: "lockin", Bit: 28, StartHeight: 0, TimeoutHeight: 1000},
+	{Name: "timeout", Bit: 28, StartHeight: 0, TimeoutHeight: 32},
+	{Name: "lockin-at-timeout", Bit: 28, StartHeight: 0, TimeoutHeight: 48},
+	{Name: "late", Bit: 28, StartHeight: 40, TimeoutHeight: 1000},
+}
+
+// deploymentStatuses returns the status of every deployment for each block after the blocks
+func deploymentStatuses(chain *BlockChain, deployments []Deployment, blocks []*Block) [][]DeploymentStatus {
+	var statuses [][]DeploymentStatus
+	for _, block := range blocks {
+		var row []DeploymentStatus
+		for _, d := range deployments {
+			row = append(row, chain.DeploymentStatus(d, block.Hash))
+		}
+		statuses = append(statuses, row)
+	}
+	return statuses
+}
+
+func TestDeploymentStatusChain(t *testing.T) {
+	blocks := deploymentTestChain(t, 70, -1, "")
+	chain := OpenBlockChain()
+	defer chain.Database.Close()
+
+	statuses := deploymentStatuses(chain, deploymentTests, blocks)
+	tests := []struct {
+		height int // of the block the status is for
+		want   []DeploymentStatus
+	}{
+		{1, []DeploymentStatus{{DeploymentDefined, 0}, {DeploymentDefined, 0}, {DeploymentDefined, 0}, {DeploymentDefined, 0}}},
+		{16, []DeploymentStatus{{DeploymentStarted, 16}, {DeploymentStarted, 16}, {DeploymentStarted, 16}, {DeploymentDefined, 0}}},
+		// 11 signals in the window at 16, one short of the threshold
+		{32, []DeploymentStatus{{DeploymentStarted, 16}, {DeploymentFailed, 32}, {DeploymentStarted, 16}, {DeploymentDefined, 0}}},
+		{47, []DeploymentStatus{{DeploymentStarted, 16}, {DeploymentFailed, 32}, {DeploymentStarted, 16}, {DeploymentDefined, 0}}},
+		// 12 signals in the window at 32
+		{48, []DeploymentStatus{{DeploymentLockedIn, 48}, {DeploymentFailed, 32}, {DeploymentLockedIn, 48}, {DeploymentStarted, 48}}},
+		{64, []DeploymentStatus{{DeploymentActive, 64}, {DeploymentFailed, 32}, {DeploymentActive, 64}, {DeploymentStarted, 48}}},
+		{71, []DeploymentStatus{{DeploymentActive, 64}, {DeploymentFailed, 32}, {DeploymentActive, 64}, {DeploymentStarted, 48}}},
+	}
+	for _, test := range tests {
+		if got := statuses[test.height-1]; !reflect.DeepEqual(got, test.want) {
+			t.Errorf("height %d: %v, want %v", test.height, got, test.want)
+		}
+	}
+
+	// the cached statuses give the same answers
+	if again := deploymentStatuses(chain, deploymentTests, blocks); !reflect.DeepEqual(again, statuses) {
+		t.Error("cached statuses differ")
+	}
+	if version := chain.blockVersion(blocks[40].Hash); version != VersionBitsTopBits|1<<28 {
+		t.Errorf("block version while started %08x", version)
+	}
+	if version := chain.blockVersion(blocks[70].Hash); version != VersionBitsTopBits {
+		t.Errorf("block version once active %08x", version)
+	}
+}
+
+func TestDeploymentStatusFromSnapshot(t *testing.T) {
+	// a base in the middle of the window at 32, and one at its last block. The snapshot carries
+	// the deployments the node knows, testdummy signals with the same bit as "lockin".
+	for _, base := range []int{40, 47} {
+		t.Run(fmt.Sprint(base), func(t *testing.T) {
+			snapshot := filepath.Join(t.TempDir(), "snapshot.dat")
+			bootstrap := filepath.Join(t.TempDir(), "bootstrap.dat")
+			blocks := deploymentTestChain(t, 70, base, snapshot)
+
+			var want [][]DeploymentStatus
+			var wantInfos [][]DeploymentInfo
+			quiet(t, func() {
+				chain := OpenBlockChain()
+				defer chain.Database.Close()
+				want = deploymentStatuses(chain, Deployments, blocks[base:])
+				for _, block := range blocks[base:] {
+					chain.setTip(block.Hash)
+					infos, _ := chain.DeploymentInfos()
+					wantInfos = append(wantInfos, infos)
+				}
+				if _, err := chain.ExportChain(bootstrap, 0, base); err != nil {
+					t.Fatal(err)
+				}
+			})
+
+			chdirTemp(t)
+			quiet(t, func() {
+				chain, info := LoadSnapshot(snapshot)
+				defer chain.Database.Close()
+				if len(info.Deployments) != len(Deployments) {
+					t.Fatalf("snapshot carries %d deployments", len(info.Deployments))
+				}
+
+				again := filepath.Join(t.TempDir(), "again.dat")
+				UTXOSet{chain}.DumpSnapshot(again)
+				first, _ := os.ReadFile(snapshot)
+				second, _ := os.ReadFile(again)
+				if !bytes.Equal(first, second) {
+					t.Error("dumping at the base without its window gave a different file")
+				}
+
+				infos, _ := chain.DeploymentInfos()
+				if !reflect.DeepEqual(infos, wantInfos[0]) {
+					t.Errorf("at the base: %+v, want %+v", infos, wantInfos[0])
+				}
+				for i, block := range blocks[base+1:] {
+					if err := chain.ConnectBlock(block); err != nil {
+						t.Fatal(err)
+					}
+					infos, _ := chain.DeploymentInfos()
+					if !reflect.DeepEqual(infos, wantInfos[i+1]) {
+						t.Errorf("height %d: %+v, want %+v", block.Height, infos, wantInfos[i+1])
+					}
+				}
+				if got := deploymentStatuses(chain, Deployments, blocks[base:]); !reflect.DeepEqual(got, want) {
+					t.Errorf("statuses above the base %v, want %v", got, want)
+				}
+
+				// the history computes the same deployment statuses the snapshot carries
+				stats, err := chain.ImportChain(bootstrap)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := <-stats.Validation; err != nil {
+					t.Fatal(err)
+				}
+			})
+		})
+	}
+}
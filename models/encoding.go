@@ -0,0 +1,268 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Consensus data (transactions, blocks and UTXO entries) is encoded field by field so that
+// transaction IDs and proof of work do not depend on Go internals and can be checked by other
+// tools. Every encoding starts with a version byte, integers are big endian and byte strings are
+// prefixed with their length as a u32:
+//
+//	transaction: version | tx version (u32) | id | input count (u32) | inputs |
+//	             output count (u32) | outputs | lock time (u32)
+//	input:       tx id | out (i32) | unlocking script | sequence (u32)
+//	output:      value (u64) | locking script
+//	block:       version | block version (u32) | hash | prev hash | height (i64) |
+//	             timestamp (i64) | nonce (i64) | tx count (u32) | serialized txs
+//	utxo entry:  version | height (i64) | time (i64) | output count (u32) |
+//	             (output index (u32) | output)s
+//
+// For example the UTXO entry holding output 0 of 100 units locked by the script 0xabcd, of a
+// transaction at height 2 after a median time past of 0x65000000, is
+//
+//	01 0000000000000002 0000000065000000 00000001 00000000 0000000000000064 00000002 abcd
+//
+// and a version 1 transaction without ID, with a coinbase input pushing data "a", no outputs and
+// no lock time is
+//
+//	01 00000001 00000000 00000001 00000000 ffffffff 00000002 0161 ffffffff 00000000 00000000
+//
+// encodingVersion is written first so a later change of layout can be told apart
+const encodingVersion = byte(1)
+
+var errTrailingData = errors.New("trailing data after encoded value")
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) putUint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) putInt64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) putUint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) putBytes(data []byte) {
+	e.putUint32(uint32(len(data)))
+	e.buf.Write(data)
+}
+
+func (e *encoder) putOutput(out TxOutput) {
+	e.putUint64(uint64(out.Value))
+	e.putBytes(out.Script)
+}
+
+// decoder reads the fields written by encoder. The first error sticks and makes every later
+// read return zero values, so callers only check err once at the end.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data) {
+		d.err = fmt.Errorf("unexpected end of data, need %d bytes, have %d", n, len(d.data))
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) version() {
+	b := d.take(1)
+	if d.err == nil && b[0] != encodingVersion {
+		d.err = fmt.Errorf("unsupported encoding version %d", b[0])
+	}
+}
+
+func (d *decoder) uint32() uint32 {
+	b := d.take(4)
+	if d.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *decoder) int64() int64 {
+	b := d.take(8)
+	if d.err != nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (d *decoder) uint64() uint64 {
+	b := d.take(8)
+	if d.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint32()
+	b := d.take(int(n))
+	if d.err != nil || n == 0 {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+// count reads an element count and rejects counts that cannot fit in the remaining data, which
+// keeps a corrupt count from allocating huge slices
+func (d *decoder) count(minSize int) int {
+	n := d.uint32()
+	if d.err == nil && uint64(n)*uint64(minSize) > uint64(len(d.data)) {
+		d.err = fmt.Errorf("count %d exceeds remaining data", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) output() TxOutput {
+	value := d.uint64()
+	return TxOutput{Amount(value), d.bytes()}
+}
+
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.data) != 0 {
+		d.err = errTrailingData
+	}
+	return d.err
+}
+
+func encodeTransaction(e *encoder, tx *Transaction) {
+	e.buf.WriteByte(encodingVersion)
+	e.putUint32(tx.Version)
+	e.putBytes(tx.ID)
+	e.putUint32(uint32(len(tx.Inputs)))
+	for _, in := range tx.Inputs {
+		e.putBytes(in.ID)
+		e.putUint32(uint32(int32(in.Out)))
+		e.putBytes(in.ScriptSig)
+		e.putUint32(in.Sequence)
+	}
+	e.putUint32(uint32(len(tx.Outputs)))
+	for _, out := range tx.Outputs {
+		e.putOutput(out)
+	}
+	e.putUint32(uint32(tx.LockTime))
+}
+
+func decodeTransaction(data []byte) (*Transaction, error) {
+	var tx Transaction
+	d := decoder{data: data}
+
+	d.version()
+	tx.Version = d.uint32()
+	tx.ID = d.bytes()
+	for i, n := 0, d.count(16); i < n; i++ {
+		var in TxInput
+		in.ID = d.bytes()
+		in.Out = int(int32(d.uint32()))
+		in.ScriptSig = d.bytes()
+		in.Sequence = d.uint32()
+		tx.Inputs = append(tx.Inputs, in)
+	}
+	for i, n := 0, d.count(12); i < n; i++ {
+		tx.Outputs = append(tx.Outputs, d.output())
+	}
+	tx.LockTime = int(d.uint32())
+
+	if err := d.finish(); err != nil {
+		return nil, fmt.Errorf("decode transaction: %w", err)
+	}
+	return &tx, nil
+}
+
+func encodeBlock(e *encoder, block *Block) {
+	e.buf.WriteByte(encodingVersion)
+	e.putUint32(block.Version)
+	e.putBytes(block.Hash)
+	e.putBytes(block.PrevHash)
+	e.putInt64(int64(block.Height))
+	e.putInt64(block.Timestamp)
+	e.putInt64(int64(block.Nonce))
+	e.putUint32(uint32(len(block.Transactions)))
+	for _, tx := range block.Transactions {
+		e.putBytes(tx.Serialize())
+	}
+}
+
+func decodeBlock(data []byte) (*Block, error) {
+	var block Block
+	d := decoder{data: data}
+
+	d.version()
+	block.Version = d.uint32()
+	block.Hash = d.bytes()
+	block.PrevHash = d.bytes()
+	block.Height = int(d.int64())
+	block.Timestamp = d.int64()
+	block.Nonce = int(d.int64())
+	for i, n := 0, d.count(4); i < n; i++ {
+		encoded := d.bytes()
+		if d.err != nil {
+			break
+		}
+		tx, err := decodeTransaction(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("decode block: %w", err)
+		}
+		block.Transactions = append(block.Transactions, tx)
+	}
+
+	if err := d.finish(); err != nil {
+		return nil, fmt.Errorf("decode block: %w", err)
+	}
+	return &block, nil
+}
+
+func encodeOutputs(e *encoder, outs *TxOutputs) {
+	e.buf.WriteByte(encodingVersion)
+	e.putInt64(int64(outs.Height))
+	e.putInt64(outs.Time)
+	e.putUint32(uint32(len(outs.Outputs)))
+	for i, out := range outs.Outputs {
+		e.putUint32(uint32(outs.Indexes[i]))
+		e.putOutput(out)
+	}
+}
+
+func decodeOutputs(data []byte) (TxOutputs, error) {
+	var outs TxOutputs
+	d := decoder{data: data}
+
+	d.version()
+	outs.Height = int(d.int64())
+	outs.Time = d.int64()
+	for i, n := 0, d.count(16); i < n; i++ {
+		index := int(d.uint32())
+		outs.add(index, d.output())
+	}
+
+	if err := d.finish(); err != nil {
+		return TxOutputs{}, fmt.Errorf("decode outputs: %w", err)
+	}
+	return outs, nil
+}
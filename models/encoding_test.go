@@ -0,0 +1,185 @@
+package models
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func encodingTestTx() *Transaction {
+	return &Transaction{
+		ID: bytes.Repeat([]byte{0xaa}, 32),
+		Inputs: []TxInput{
+			{bytes.Repeat([]byte{0x01}, 32), 0, []byte{0x51}, SequenceFinal},
+			{bytes.Repeat([]byte{0x02}, 32), 7, nil, 0},
+			{bytes.Repeat([]byte{0x03}, 32), 1<<31 - 1, bytes.Repeat([]byte{0x52}, 300), SequenceLockTimeTypeFlag | 5},
+		},
+		Outputs: []TxOutput{
+			{MaxMoney, PayToPubKeyHashScript(bytes.Repeat([]byte{0x04}, 20))},
+			{0, nil},
+			{1, []byte{OpReturn}},
+		},
+		LockTime: 1<<32 - 1,
+		Version:  2,
+	}
+}
+
+func encodingTestOutputs() TxOutputs {
+	var outs TxOutputs
+	outs.add(0, TxOutput{5 * UnitsPerCoin, []byte{0xab}})
+	outs.add(3, TxOutput{MaxMoney, nil})
+	outs.add(1<<31, TxOutput{1, PayToScriptHashScript(bytes.Repeat([]byte{0x05}, 20))})
+	outs.Height = 1<<40 + 1
+	outs.Time = -1
+	return outs
+}
+
+func encodingTestBlock() *Block {
+	coinbase := &Transaction{nil, []TxInput{{nil, -1, []byte{0x01, 0x61}, SequenceFinal}},
+		[]TxOutput{{BlockSubsidy, PayToPubKeyHashScript(bytes.Repeat([]byte{0x06}, 20))}}, 0, TxVersion}
+	coinbase.SetID()
+	return &Block{
+		Hash:         bytes.Repeat([]byte{0xbb}, 32),
+		Transactions: []*Transaction{coinbase, encodingTestTx()},
+		PrevHash:     bytes.Repeat([]byte{0xcc}, 32),
+		Nonce:        1<<62 + 3,
+		Height:       42,
+		Timestamp:    1700000000,
+		Version:      VersionBitsTopBits | 1,
+	}
+}
+
+func TestEncodingRoundTrip(t *testing.T) {
+	tx := encodingTestTx()
+	decodedTx, err := decodeTransaction(tx.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decodedTx, tx) {
+		t.Errorf("transaction came back as %+v", decodedTx)
+	}
+	for i, in := range tx.Inputs {
+		if !reflect.DeepEqual(decodedTx.Inputs[i], in) {
+			t.Errorf("input %d came back as %+v", i, decodedTx.Inputs[i])
+		}
+	}
+	for i, out := range tx.Outputs {
+		if !reflect.DeepEqual(decodedTx.Outputs[i], out) {
+			t.Errorf("output %d came back as %+v", i, decodedTx.Outputs[i])
+		}
+	}
+
+	block := encodingTestBlock()
+	decodedBlock, err := decodeBlock(block.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decodedBlock, block) {
+		t.Errorf("block came back as %+v", decodedBlock)
+	}
+
+	outs := encodingTestOutputs()
+	decodedOuts, err := decodeOutputs(outs.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decodedOuts, outs) {
+		t.Errorf("UTXO entry came back as %+v", decodedOuts)
+	}
+}
+
+// documentedVectors reads the example encodings from the comment on encodingVersion
+func documentedVectors(t *testing.T) [][]byte {
+	source, err := os.ReadFile("encoding.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors [][]byte
+	for _, line := range strings.Split(string(source), "\n") {
+		if !strings.HasPrefix(line, "//\t0") {
+			continue
+		}
+		vector, err := hex.DecodeString(strings.ReplaceAll(strings.TrimPrefix(line, "//\t"), " ", ""))
+		if err != nil {
+			t.Fatalf("documented vector %q: %v", line, err)
+		}
+		vectors = append(vectors, vector)
+	}
+	if len(vectors) != 2 {
+		t.Fatalf("found %d documented vectors, want 2", len(vectors))
+	}
+	return vectors
+}
+
+func TestEncodingDocumentedVectors(t *testing.T) {
+	vectors := documentedVectors(t)
+
+	var outs TxOutputs
+	outs.add(0, TxOutput{100, []byte{0xab, 0xcd}})
+	outs.Height = 2
+	outs.Time = 0x65000000
+	if got := outs.Serialize(); !bytes.Equal(got, vectors[0]) {
+		t.Errorf("UTXO entry encoded as %x, documented as %x", got, vectors[0])
+	}
+	if decoded, err := decodeOutputs(vectors[0]); err != nil || !reflect.DeepEqual(decoded, outs) {
+		t.Errorf("documented UTXO entry decoded as %+v, %v", decoded, err)
+	}
+
+	tx := &Transaction{nil, []TxInput{{nil, -1, []byte{0x01, 0x61}, SequenceFinal}}, nil, 0, 1}
+	if got := tx.Serialize(); !bytes.Equal(got, vectors[1]) {
+		t.Errorf("transaction encoded as %x, documented as %x", got, vectors[1])
+	}
+	if decoded, err := decodeTransaction(vectors[1]); err != nil || !reflect.DeepEqual(decoded, tx) {
+		t.Errorf("documented transaction decoded as %+v, %v", decoded, err)
+	}
+}
+
+func TestEncodingErrors(t *testing.T) {
+	outs := encodingTestOutputs()
+	encodings := []struct {
+		name   string
+		data   []byte
+		decode func([]byte) error
+	}{
+		{"transaction", encodingTestTx().Serialize(), func(data []byte) error {
+			_, err := decodeTransaction(data)
+			return err
+		}},
+		{"block", encodingTestBlock().Serialize(), func(data []byte) error {
+			_, err := decodeBlock(data)
+			return err
+		}},
+		{"UTXO entry", outs.Serialize(), func(data []byte) error {
+			_, err := decodeOutputs(data)
+			return err
+		}},
+	}
+
+	for _, enc := range encodings {
+		for n := 0; n < len(enc.data); n++ {
+			if enc.decode(enc.data[:n]) == nil {
+				t.Errorf("%s truncated to %d of %d bytes decoded", enc.name, n, len(enc.data))
+			}
+		}
+
+		trailing := append(append([]byte{}, enc.data...), 0x00)
+		if err := enc.decode(trailing); !errors.Is(err, errTrailingData) {
+			t.Errorf("%s with a trailing byte: error %v, want %v", enc.name, err, errTrailingData)
+		}
+
+		otherVersion := append([]byte{encodingVersion + 1}, enc.data[1:]...)
+		if enc.decode(otherVersion) == nil {
+			t.Errorf("%s of encoding version %d decoded", enc.name, encodingVersion+1)
+		}
+	}
+
+	// an input count the data cannot hold is rejected before anything is allocated
+	huge := []byte{encodingVersion, 0, 0, 0, 1, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff}
+	if _, err := decodeTransaction(huge); err == nil || !strings.Contains(err.Error(), "exceeds remaining data") {
+		t.Errorf("huge input count: error %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package models
+
+import (
+	"fmt"
+	"github.com/bucks-go-wallet/utils"
+	"github.com/dgraph-io/badger"
+	"sort"
+)
+
+// FeeRate is a fee in the smallest unit of Amount per byte of serialized transaction
+type FeeRate int
+
+const (
+	MinFeeRate = FeeRate(1) // estimates never go below this rate
+
+	feeStatsBlocks     = 100  // blocks whose transactions the estimator remembers
+	minFeeObservations = 3    // transactions needed before history is trusted
+	feeSuccessShare    = 0.85 // share of transactions that must have confirmed in time
+)
+
+var feeStatsKey = []byte("feestats")
+
+// Fee returns the fee of a transaction of size bytes at the rate
+func (rate FeeRate) Fee(size int) Amount {
+	return Amount(int64(rate) * int64(size))
+}
+
+// feeRateOf returns the rate a fee pays for a transaction of size bytes, rounded down
+func feeRateOf(fee Amount, size int) FeeRate {
+	if size == 0 {
+		return 0
+	}
+	return FeeRate(uint64(fee) / uint64(size))
+}
+
+// feeObservation is a transaction seen in the mempool and later mined
+type feeObservation struct {
+	Height int // height of the block that mined it
+	Rate   FeeRate
+	Delay  int // blocks between entering the mempool and being mined, 1 for the next block
+}
+
+// FeeEstimate is the result of EstimateFee
+type FeeEstimate struct {
+	Rate         FeeRate
+	Observations int  // mined transactions the estimate is based on
+	FromHistory  bool // false when there was too little history and MinFeeRate was used
+	MempoolBytes int  // size of the transactions waiting in the mempool
+}
+
+func (bc *BlockChain) feeObservations(txn *badger.Txn) ([]feeObservation, error) {
+	item, err := txn.Get(feeStatsKey)
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeFeeObservations(v)
+}
+
+// recordFees adds the mined transactions that went through the mempool to the fee history and
+// forgets what was mined more than feeStatsBlocks ago
+func (bc *BlockChain) recordFees(txn *badger.Txn, height int, mined []feeObservation) error {
+	observations, err := bc.feeObservations(txn)
+	if err != nil {
+		return err
+	}
+
+	kept := observations[:0]
+	for _, observation := range observations {
+		if observation.Height > height-feeStatsBlocks {
+			kept = append(kept, observation)
+		}
+	}
+	kept = append(kept, mined...)
+	return txn.Set(feeStatsKey, encodeFeeObservations(kept))
+}
+
+// encodeFeeObservations writes the fee history as a count followed by the height, rate and delay
+// of each observation
+func encodeFeeObservations(observations []feeObservation) []byte {
+	var e encoder
+	e.buf.WriteByte(encodingVersion)
+	e.putUint32(uint32(len(observations)))
+	for _, observation := range observations {
+		e.putInt64(int64(observation.Height))
+		e.putInt64(int64(observation.Rate))
+		e.putInt64(int64(observation.Delay))
+	}
+	return e.buf.Bytes()
+}
+
+func decodeFeeObservations(data []byte) ([]feeObservation, error) {
+	var observations []feeObservation
+	d := decoder{data: data}
+
+	d.version()
+	for i, n := 0, d.count(24); i < n; i++ {
+		observations = append(observations, feeObservation{int(d.int64()), FeeRate(d.int64()), int(d.int64())})
+	}
+
+	if err := d.finish(); err != nil {
+		return nil, fmt.Errorf("decode fee history: %w", err)
+	}
+	return observations, nil
+}
+
+// EstimateFee returns the fee rate that should get a transaction mined within blocks blocks.
+// From the recently mined transactions it takes the lowest rate at which, counting every
+// transaction paying that rate or more, at least 85% confirmed within blocks blocks. When the
+// mempool holds more than blocks blocks can take, the rate must also beat the transactions that
+// would fill them.
+func (bc *BlockChain) EstimateFee(blocks int) FeeEstimate {
+	if blocks < 1 {
+		blocks = 1
+	}
+	estimate := FeeEstimate{Rate: MinFeeRate}
+
+	var observations []feeObservation
+	err := bc.Database.View(func(txn *badger.Txn) error {
+		var err error
+		observations, err = bc.feeObservations(txn)
+		return err
+	})
+	utils.Handle(err)
+	estimate.Observations = len(observations)
+
+	if len(observations) >= minFeeObservations {
+		sort.Slice(observations, func(i, j int) bool { return observations[i].Rate > observations[j].Rate })
+
+		total, confirmed := 0, 0
+		best := FeeRate(-1)
+		for i, observation := range observations {
+			total++
+			if observation.Delay <= blocks {
+				confirmed++
+			}
+			lastOfRate := i == len(observations)-1 || observations[i+1].Rate != observation.Rate
+			if lastOfRate && total >= minFeeObservations && float64(confirmed) >= feeSuccessShare*float64(total) {
+				best = observation.Rate
+			}
+		}
+		if best >= 0 {
+			estimate.FromHistory = true
+			if best > estimate.Rate {
+				estimate.Rate = best
+			}
+		}
+	}
+
+	// Transactions ahead in the mempool take the space of the next blocks first
+	entries := bc.MempoolEntries()
+	space := blocks * MaxBlockSize
+	for _, entry := range entries {
+		estimate.MempoolBytes += entry.Size
+	}
+	for _, entry := range entries {
+		space -= entry.Size
+		if space < 0 {
+			if rate := entry.FeeRate() + 1; rate > estimate.Rate {
+				estimate.Rate = rate
+			}
+			break
+		}
+	}
+	return estimate
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"bytes"
+	"github.com/dgraph-io/badger"
+	"reflect"
+	"testing"
+)
+
+func TestFeeRatePerByte(t *testing.T) {
+	if got := FeeRate(3).Fee(250); got != 750 {
+		t.Errorf("fee %d, want 750", got)
+	}
+	if got := feeRateOf(751, 250); got != 3 {
+		t.Errorf("rate %d, want 3, rounded down", got)
+	}
+	if got := feeRateOf(100, 0); got != 0 {
+		t.Errorf("rate of an empty transaction %d", got)
+	}
+}
+
+// recordTestFees stores the observations as if a block at height mined them
+func recordTestFees(t *testing.T, chain *BlockChain, height int, observations ...feeObservation) {
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		return chain.recordFees(txn, height, observations)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// observations returns n observations of rate mined delay blocks after entering the mempool
+func observations(n int, rate FeeRate, delay int) []feeObservation {
+	var observations []feeObservation
+	for i := 0; i < n; i++ {
+		observations = append(observations, feeObservation{10, rate, delay})
+	}
+	return observations
+}
+
+func TestEstimateFeeHistory(t *testing.T) {
+	chdirTemp(t)
+	chain := OpenBlockChain()
+	defer chain.Database.Close()
+
+	if estimate := chain.EstimateFee(1); estimate.Rate != MinFeeRate || estimate.FromHistory || estimate.Observations != 0 {
+		t.Errorf("estimate without history %+v", estimate)
+	}
+
+	recordTestFees(t, chain, 10, observations(minFeeObservations-1, 50, 1)...)
+	if estimate := chain.EstimateFee(1); estimate.Rate != MinFeeRate || estimate.FromHistory {
+		t.Errorf("estimate from %d observations %+v, want the minimum", minFeeObservations-1, estimate)
+	}
+	recordTestFees(t, chain, 10, observations(1, 50, 1)...)
+	if estimate := chain.EstimateFee(1); estimate.Rate != 50 || !estimate.FromHistory {
+		t.Errorf("estimate from %d observations %+v, want 50", minFeeObservations, estimate)
+	}
+
+	// history older than feeStatsBlocks is forgotten
+	recordTestFees(t, chain, 10+feeStatsBlocks, feeObservation{10 + feeStatsBlocks, 40, 1})
+	if estimate := chain.EstimateFee(1); estimate.Observations != 1 || estimate.FromHistory {
+		t.Errorf("estimate after the history expired %+v", estimate)
+	}
+}
+
+func TestEstimateFeeSuccessShare(t *testing.T) {
+	chdirTemp(t)
+	chain := OpenBlockChain()
+	defer chain.Database.Close()
+
+	// 17 of 20 is exactly the success share, one more slow transaction is not
+	recordTestFees(t, chain, 10, append(observations(17, 20, 1), observations(3, 10, 3)...)...)
+	if estimate := chain.EstimateFee(1); estimate.Rate != 10 || !estimate.FromHistory || estimate.Observations != 20 {
+		t.Errorf("estimate at the success share %+v, want 10", estimate)
+	}
+	recordTestFees(t, chain, 10, observations(1, 10, 3)...)
+	if estimate := chain.EstimateFee(1); estimate.Rate != 20 || !estimate.FromHistory {
+		t.Errorf("estimate below the success share %+v, want 20", estimate)
+	}
+	if estimate := chain.EstimateFee(3); estimate.Rate != 10 {
+		t.Errorf("estimate for 3 blocks %+v, want 10", estimate)
+	}
+}
+
+// addTestMempoolEntry stores a transaction of about size bytes paying rate, without checking it
+func addTestMempoolEntry(t *testing.T, chain *BlockChain, id byte, size int, rate FeeRate) {
+	tx := &Transaction{bytes.Repeat([]byte{id}, 32), nil, []TxOutput{{0, make([]byte, size)}}, 0, TxVersion}
+	n := len(tx.Serialize())
+	entry := MempoolEntry{tx, rate.Fee(n), n, 0}
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(append(mempoolPrefix, tx.ID...), encodeMempoolEntry(entry))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEstimateFeeMempoolFloor(t *testing.T) {
+	chdirTemp(t)
+	chain := OpenBlockChain()
+	defer chain.Database.Close()
+
+	recordTestFees(t, chain, 10, observations(5, 5, 1)...)
+	for i, rate := range []FeeRate{50, 40, 30, 20, 10} {
+		addTestMempoolEntry(t, chain, byte(i), MaxBlockSize/4, rate)
+	}
+
+	// the 20 transaction does not fit in the next block, the 10 one not in the next two
+	estimate := chain.EstimateFee(1)
+	if estimate.Rate != 21 {
+		t.Errorf("estimate for 1 block %+v, want 21 to beat what fills it", estimate)
+	}
+	if estimate.MempoolBytes <= MaxBlockSize {
+		t.Errorf("mempool bytes %d", estimate.MempoolBytes)
+	}
+	if estimate := chain.EstimateFee(2); estimate.Rate != 5 {
+		t.Errorf("estimate for 2 blocks %+v, want the history's 5", estimate)
+	}
+}
+
+func TestFeeRecordEncoding(t *testing.T) {
+	history := []feeObservation{{7, 20, 1}, {1 << 40, MinFeeRate, 3}}
+	decoded, err := decodeFeeObservations(encodeFeeObservations(history))
+	if err != nil || !reflect.DeepEqual(decoded, history) {
+		t.Errorf("fee history came back as %+v, %v", decoded, err)
+	}
+
+	entry := MempoolEntry{encodingTestTx(), 1234, len(encodingTestTx().Serialize()), 9}
+	decodedEntry, err := decodeMempoolEntry(encodeMempoolEntry(entry))
+	if err != nil || !reflect.DeepEqual(decodedEntry, entry) {
+		t.Errorf("mempool entry came back as %+v, %v", decodedEntry, err)
+	}
+	if _, err := decodeMempoolEntry(append(encodeMempoolEntry(entry), 0)); err == nil {
+		t.Error("mempool entry with a trailing byte decoded")
+	}
+}
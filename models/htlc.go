@@ -0,0 +1,252 @@
+package models
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/bucks-go-wallet/utils"
+	"log"
+	"math"
+	"sort"
+)
+
+// HTLC holds the terms of a hash time locked contract. The recipient can spend its outputs by
+// revealing the secret hashing to Hash, the refunder can take them back in blocks above
+// LockHeight. The same secret locking outputs on two chains makes a swap between them atomic:
+// redeeming on one chain reveals the secret for the other.
+type HTLC struct {
+	Hash       []byte // sha256 of the secret
+	Recipient  []byte // pubkey hash of the recipient
+	Refund     []byte // pubkey hash of the refunder
+	LockHeight int
+}
+
+// NewHTLC builds the terms for a contract between two pay-to-pubkey-hash addresses
+func NewHTLC(hash []byte, recipient, refund string, lockHeight int) (HTLC, error) {
+	recipientHash, err := addressPubKeyHash(recipient)
+	if err != nil {
+		return HTLC{}, err
+	}
+	refundHash, err := addressPubKeyHash(refund)
+	if err != nil {
+		return HTLC{}, err
+	}
+
+	htlc := HTLC{hash, recipientHash, refundHash, lockHeight}
+	_, err = htlc.Script()
+	return htlc, err
+}
+
+func addressPubKeyHash(address string) ([]byte, error) {
+	script, err := AddressScript(address)
+	if err != nil {
+		return nil, err
+	}
+	class, hash := scriptHashData(script)
+	if class != PubKeyHashScript {
+		return nil, fmt.Errorf("%s is not a pay-to-pubkey-hash address", address)
+	}
+	return hash, nil
+}
+
+// Script returns the redeem script of the contract:
+//
+//	OP_IF
+//	    OP_SHA256 <hash> OP_EQUALVERIFY OP_DUP OP_HASH160 <recipient pubkey hash>
+//	OP_ELSE
+//	    <lock height> OP_CHECKLOCKTIMEVERIFY OP_DROP OP_DUP OP_HASH160 <refund pubkey hash>
+//	OP_ENDIF
+//	OP_EQUALVERIFY OP_CHECKSIG
+func (htlc HTLC) Script() ([]byte, error) {
+	if len(htlc.Hash) != sha256.Size {
+		return nil, fmt.Errorf("HTLC hash must be %d bytes, got %d", sha256.Size, len(htlc.Hash))
+	}
+	if len(htlc.Recipient) != 20 || len(htlc.Refund) != 20 {
+		return nil, errors.New("HTLC pubkey hashes must be 20 bytes")
+	}
+	if htlc.LockHeight <= 0 || htlc.LockHeight > math.MaxUint32 {
+		return nil, fmt.Errorf("HTLC lock height %d is out of range", htlc.LockHeight)
+	}
+
+	script := appendPush([]byte{OpIf, OpSha256}, htlc.Hash)
+	script = appendPush(append(script, OpEqualVerify, OpDup, OpHash160), htlc.Recipient)
+	script = appendNumber(append(script, OpElse), int64(htlc.LockHeight))
+	script = appendPush(append(script, OpCheckLockTimeVerify, OpDrop, OpDup, OpHash160), htlc.Refund)
+	return append(script, OpEndIf, OpEqualVerify, OpCheckSig), nil
+}
+
+// Address returns the pay-to-script-hash address that locks coins to the contract
+func (htlc HTLC) Address() (string, error) {
+	script, err := htlc.Script()
+	if err != nil {
+		return "", err
+	}
+	return ScriptAddress(script), nil
+}
+
+// ParseHTLC reads the terms back from a redeem script built by HTLC.Script
+func ParseHTLC(script []byte) (HTLC, error) {
+	errNotHTLC := errors.New("script is not an HTLC")
+
+	ops, err := parseScript(script)
+	if err != nil {
+		return HTLC{}, err
+	}
+	if len(ops) != 17 || !ops[8].isPush() {
+		return HTLC{}, errNotHTLC
+	}
+
+	lockHeight := int64(0)
+	if ops[8].opcode >= Op1 && ops[8].opcode <= Op16 {
+		lockHeight = int64(ops[8].opcode-Op1) + 1
+	} else if lockHeight, err = scriptNum(ops[8].data, maxLockTimeNumSize); err != nil {
+		return HTLC{}, errNotHTLC
+	}
+
+	htlc := HTLC{ops[2].data, ops[6].data, ops[13].data, int(lockHeight)}
+	rebuilt, err := htlc.Script()
+	if err != nil || !bytes.Equal(rebuilt, script) {
+		return HTLC{}, errNotHTLC
+	}
+	return htlc, nil
+}
+
+// SignHTLC fills the unlocking scripts of the inputs spending the outputs of an HTLC redeem
+// script. With a preimage privKey redeems them as the recipient, without one it takes them back
+// as the refunder, which needs LockTime set to at least the lock height and inputs with a
+// sequence below SequenceFinal.
+func (tx *Transaction) SignHTLC(privKey Signer, redeemScript, preimage []byte, prevOuts []TxOutput) {
+	if len(prevOuts) != len(tx.Inputs) {
+		log.Panic("ERROR: Previous outputs do not match the inputs")
+	}
+	lockingScript := PayToScriptHashScript(ScriptHash(redeemScript))
+	pubKey := privKey.Public().Bytes()
+
+	for inId, prevOut := range prevOuts {
+		if !bytes.Equal(prevOut.Script, lockingScript) {
+			continue
+		}
+
+		signature := tx.signInput(privKey, inId, redeemScript, prevOut, SigHashAll)
+		if preimage != nil {
+			tx.Inputs[inId].ScriptSig = PushScript(signature, pubKey, preimage, []byte{1}, redeemScript)
+		} else {
+			tx.Inputs[inId].ScriptSig = PushScript(signature, pubKey, nil, redeemScript)
+		}
+	}
+}
+
+// SignHTLCTransaction signs the inputs of tx spending the HTLC, see Transaction.SignHTLC
+func (bc *BlockChain) SignHTLCTransaction(tx *Transaction, privKey Signer, redeemScript, preimage []byte) {
+	prevOuts, err := bc.SpentOutputs(tx)
+	utils.Handle(err)
+	tx.SignHTLC(privKey, redeemScript, preimage, prevOuts)
+}
+
+// NewHTLCSpend builds a transaction moving every unspent output of the HTLC to address, less a
+// fee at feeRate, signed with the matching wallet from the wallet file. With a preimage the
+// recipient redeems the outputs, without one the refunder takes them back.
+func NewHTLCSpend(redeemScript, preimage []byte, address string, feeRate FeeRate, set *UTXOSet) (*Transaction, error) {
+	htlc, err := ParseHTLC(redeemScript)
+	if err != nil {
+		return nil, err
+	}
+
+	owner := htlc.Refund
+	if preimage != nil {
+		if hash := sha256.Sum256(preimage); !bytes.Equal(hash[:], htlc.Hash) {
+			return nil, errors.New("preimage does not match the HTLC hash")
+		}
+		owner = htlc.Recipient
+	} else if next := set.BlockChain.Height() + 1; next <= htlc.LockHeight {
+		return nil, fmt.Errorf("HTLC can be refunded in blocks above height %d, the next block is %d", htlc.LockHeight, next)
+	}
+
+	wallets, err := CreateWallets()
+	if err != nil {
+		return nil, err
+	}
+	w, ok := wallets.walletForPubKeyHash(owner)
+	if !ok {
+		return nil, fmt.Errorf("no wallet in the wallet file for pubkey hash %x", owner)
+	}
+	if address == "" {
+		address = string(w.Address())
+	}
+
+	// outputs a mempool transaction already spends are left alone, the rest are spent in
+	// outpoint order
+	spent := set.BlockChain.MempoolSpends()
+	var coins []Coin
+	for _, coin := range set.FindCoins(PayToScriptHashScript(ScriptHash(redeemScript))) {
+		if !spent[outpointKey(coin.TxID, coin.Index)] {
+			coins = append(coins, coin)
+		}
+	}
+	if len(coins) == 0 {
+		return nil, errors.New("HTLC has no unspent outputs")
+	}
+	sort.Slice(coins, func(i, j int) bool {
+		if c := bytes.Compare(coins[i].TxID, coins[j].TxID); c != 0 {
+			return c < 0
+		}
+		return coins[i].Index < coins[j].Index
+	})
+
+	var acc Amount
+	var inputs []TxInput
+	for _, coin := range coins {
+		if acc, err = acc.Add(coin.Value); err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, TxInput{coin.TxID, coin.Index, nil, SequenceFinal})
+	}
+
+	tx := Transaction{nil, inputs, []TxOutput{*NewTxOutput(acc, address)}, 0, TxVersion}
+	if preimage == nil {
+		tx.LockTime = htlc.LockHeight
+		for i := range tx.Inputs {
+			tx.Inputs[i].Sequence = SequenceFinal - 1
+		}
+	}
+	tx.SetID()
+	set.BlockChain.SignHTLCTransaction(&tx, w.PrivateKey, redeemScript, preimage)
+
+	// the output value has a fixed size, so the signed size does not change with the fee
+	fee := feeRate.Fee(len(tx.Serialize()))
+	if fee >= acc {
+		return nil, fmt.Errorf("HTLC holds %s, not enough for a fee of %s", acc, fee)
+	}
+	tx.Outputs[0].Value = acc - fee
+	tx.SetID()
+	set.BlockChain.SignHTLCTransaction(&tx, w.PrivateKey, redeemScript, preimage)
+
+	return &tx, nil
+}
+
+// FindHTLCPreimage looks through the chain for a transaction that redeemed the HTLC and returns
+// the secret it revealed
+func (bc *BlockChain) FindHTLCPreimage(redeemScript []byte) ([]byte, error) {
+	htlc, err := ParseHTLC(redeemScript)
+	if err != nil {
+		return nil, err
+	}
+
+	iter := bc.Iterator()
+	for len(iter.CurrentHash) != 0 {
+		block := iter.Next()
+		for _, tx := range block.Transactions {
+			for _, in := range tx.Inputs {
+				elements, err := scriptPushes(in.ScriptSig)
+				if err != nil || len(elements) != 5 || !bytes.Equal(elements[4], redeemScript) {
+					continue
+				}
+				if hash := sha256.Sum256(elements[2]); bytes.Equal(hash[:], htlc.Hash) {
+					return elements[2], nil
+				}
+			}
+		}
+	}
+	return nil, errors.New("HTLC has not been redeemed on this chain")
+}
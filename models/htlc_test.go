@@ -0,0 +1,130 @@
+package models
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strings"
+	"testing"
+)
+
+// htlcBalance adds up the unspent outputs locked by script
+func htlcBalance(set UTXOSet, script []byte) Amount {
+	var balance Amount
+	for _, out := range set.FindUnspentTransactions(script) {
+		balance += out.Value
+	}
+	return balance
+}
+
+func TestHTLCSpend(t *testing.T) {
+	chdirTemp(t)
+	wallets, _ := CreateWallets()
+	funder := wallets.AddWallet(KeyP256)
+	recipient := wallets.AddWallet(KeyP256)
+	wallets.SaveFile()
+	funderScript, _ := AddressScript(funder)
+	recipientScript, _ := AddressScript(recipient)
+
+	secret := bytes.Repeat([]byte{0x5e}, 32)
+	hash := sha256.Sum256(secret)
+	contract := func(lockHeight int) ([]byte, string) {
+		htlc, err := NewHTLC(hash[:], recipient, funder, lockHeight)
+		if err != nil {
+			t.Fatal(err)
+		}
+		script, _ := htlc.Script()
+		address, _ := htlc.Address()
+		return script, address
+	}
+
+	quiet(t, func() {
+		chain := InitBlockChain(funder, false)
+		defer chain.Database.Close()
+		set := UTXOSet{chain}
+		set.Reindex()
+
+		// the claimed contract gets two outputs, the refunded one times out in a few blocks
+		claimed, claimedAddress := contract(chain.Height() + 100)
+		refundHeight := chain.Height() + 4
+		refunded, refundedAddress := contract(refundHeight)
+		fund := NewSendManyTransaction(funder, []Payment{
+			{claimedAddress, 3 * UnitsPerCoin}, {claimedAddress, 2 * UnitsPerCoin}, {refundedAddress, 4 * UnitsPerCoin},
+		}, SendOptions{Fee: 1000}, &set)
+		if _, err := chain.AddToMempool(fund); err != nil {
+			t.Fatal(err)
+		}
+		chain.MineBlock(funder)
+
+		// refunding before the lock height is refused, and so is a refund signed anyway
+		if _, err := NewHTLCSpend(refunded, nil, "", MinFeeRate, &set); err == nil || !strings.Contains(err.Error(), "can be refunded") {
+			t.Errorf("early refund: error %v", err)
+		}
+		funderKey := wallets.GetWallet(funder).PrivateKey
+		for _, lockTime := range []int{refundHeight, chain.Height()} {
+			early := Transaction{nil, []TxInput{{fund.ID, 2, nil, SequenceFinal - 1}},
+				[]TxOutput{*NewTxOutput(4*UnitsPerCoin-1000, funder)}, lockTime, TxVersion}
+			early.SetID()
+			chain.SignHTLCTransaction(&early, funderKey, refunded, nil)
+			if _, err := chain.AddToMempool(&early); err == nil {
+				t.Errorf("refund with lock time %d at height %d accepted", lockTime, chain.Height())
+			}
+		}
+
+		// the recipient claims both outputs with the secret, in outpoint order
+		if _, err := NewHTLCSpend(claimed, bytes.Repeat([]byte{0x11}, 32), "", MinFeeRate, &set); err == nil {
+			t.Error("claim with the wrong preimage built")
+		}
+		claim, err := NewHTLCSpend(claimed, secret, "", MinFeeRate, &set)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(claim.Inputs) != 2 || claim.Inputs[0].Out != 0 || claim.Inputs[1].Out != 1 {
+			t.Errorf("claim spends %+v", claim.Inputs)
+		}
+		if again, err := NewHTLCSpend(claimed, secret, "", MinFeeRate, &set); err != nil || !bytes.Equal(again.ID, claim.ID) {
+			t.Errorf("building the claim again gave %x, %v, want %x", again.ID, err, claim.ID)
+		}
+		entry, err := chain.AddToMempool(claim)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claim.Outputs[0].Value+entry.Fee != 5*UnitsPerCoin || !bytes.Equal(claim.Outputs[0].Script, recipientScript) {
+			t.Errorf("claim pays %s to %x with a fee of %s", claim.Outputs[0].Value, claim.Outputs[0].Script, entry.Fee)
+		}
+		// the outputs the claim spends in the mempool are not spent again
+		if _, err := NewHTLCSpend(claimed, secret, "", MinFeeRate, &set); err == nil {
+			t.Error("second claim built while the first is in the mempool")
+		}
+		chain.MineBlock(funder)
+		if balance := htlcBalance(set, recipientScript); balance != claim.Outputs[0].Value {
+			t.Errorf("recipient holds %s, want %s", balance, claim.Outputs[0].Value)
+		}
+		if preimage, err := chain.FindHTLCPreimage(claimed); err != nil || !bytes.Equal(preimage, secret) {
+			t.Errorf("preimage %x, %v", preimage, err)
+		}
+
+		// once the next block is above the lock height the funder takes the other contract back
+		for chain.Height()+1 <= refundHeight {
+			chain.MineBlock(recipient)
+		}
+		before := htlcBalance(set, funderScript)
+		refund, err := NewHTLCSpend(refunded, nil, "", MinFeeRate, &set)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if refund.LockTime != refundHeight {
+			t.Errorf("refund lock time %d, want %d", refund.LockTime, refundHeight)
+		}
+		entry, err = chain.AddToMempool(refund)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chain.MineBlock(recipient)
+		if got := htlcBalance(set, funderScript) - before; got != 4*UnitsPerCoin-entry.Fee {
+			t.Errorf("refund returned %s, want %s", got, 4*UnitsPerCoin-entry.Fee)
+		}
+		if balance := htlcBalance(set, PayToScriptHashScript(ScriptHash(refunded))); balance != 0 {
+			t.Errorf("refunded contract still holds %s", balance)
+		}
+	})
+}
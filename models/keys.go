@@ -0,0 +1,221 @@
+package models
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/bucks-go-wallet/utils"
+	"math/big"
+	"strings"
+)
+
+// KeyType is the signature scheme of a key. Public keys start with a byte telling their type,
+// so the key type is part of every script holding or hashing a key, and keys of both types can
+// sign inputs of the same transaction.
+//
+//	0x02, 0x03  P-256 ECDSA, the compressed point with an even or odd Y
+//	0xed        Ed25519, followed by the 32 byte key
+type KeyType byte
+
+const (
+	KeyP256 KeyType = iota
+	KeyEd25519
+)
+
+const ed25519KeyPrefix = 0xed
+
+// ed25519Order is the order of the Ed25519 group, little endian
+var ed25519Order = [32]byte{
+	0xed, 0xd3, 0xf5, 0x5c, 0x1a, 0x63, 0x12, 0x58, 0xd6, 0x9c, 0xf7, 0xa2, 0xde, 0xf9, 0xde, 0x14,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10,
+}
+
+// ParseKeyType reads a key type written as p256 or ed25519
+func ParseKeyType(s string) (KeyType, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "p256", "p-256", "ecdsa":
+		return KeyP256, nil
+	case "ed25519":
+		return KeyEd25519, nil
+	}
+	return 0, fmt.Errorf("unknown key type %q, use p256 or ed25519", s)
+}
+
+func (keyType KeyType) String() string {
+	switch keyType {
+	case KeyP256:
+		return "p256"
+	case KeyEd25519:
+		return "ed25519"
+	}
+	return fmt.Sprintf("KeyType(%d)", byte(keyType))
+}
+
+// PublicKey checks signatures of one key type
+type PublicKey interface {
+	Type() KeyType
+	// Bytes is the encoding used in scripts, starting with the key type
+	Bytes() []byte
+	// Verify reports whether signature, without hash type, signs hash
+	Verify(hash, signature []byte) bool
+	// checkSignature reports why a signature is not canonical for the key type
+	checkSignature(signature []byte) error
+}
+
+// Signer is a private key of one key type
+type Signer interface {
+	Type() KeyType
+	Public() PublicKey
+	Sign(hash []byte) []byte
+}
+
+// NewSigner generates a private key of the given type
+func NewSigner(keyType KeyType) Signer {
+	switch keyType {
+	case KeyP256:
+		private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		utils.Handle(err)
+		return p256Signer{*private}
+	case KeyEd25519:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		utils.Handle(err)
+		return ed25519Signer(private)
+	}
+	panic(fmt.Sprintf("unknown key type %d", keyType))
+}
+
+// signerFromBytes rebuilds a private key stored by signerBytes. P-256 scalars shorter than 32
+// bytes are what wallets stored before scalars were padded, their leading zeros left out.
+func signerFromBytes(keyType KeyType, data []byte) (Signer, error) {
+	switch keyType {
+	case KeyP256:
+		if len(data) > scalarSize {
+			return nil, fmt.Errorf("p256 scalar of %d bytes, it must have at most %d", len(data), scalarSize)
+		}
+		scalar := make([]byte, scalarSize)
+		copy(scalar[scalarSize-len(data):], data)
+		// ecdh computes the public key in constant time and rejects zero and the group order or more
+		key, err := ecdh.P256().NewPrivateKey(scalar)
+		if err != nil {
+			return nil, fmt.Errorf("p256 scalar: %w", err)
+		}
+		point := key.PublicKey().Bytes() // 0x04, X, Y
+		private := ecdsa.PrivateKey{D: new(big.Int).SetBytes(scalar)}
+		private.Curve = elliptic.P256()
+		private.X = new(big.Int).SetBytes(point[1 : 1+scalarSize])
+		private.Y = new(big.Int).SetBytes(point[1+scalarSize:])
+		return p256Signer{private}, nil
+	case KeyEd25519:
+		if len(data) != ed25519.SeedSize {
+			return nil, fmt.Errorf("ed25519 seed of %d bytes, it must have %d", len(data), ed25519.SeedSize)
+		}
+		return ed25519Signer(ed25519.NewKeyFromSeed(data)), nil
+	}
+	return nil, fmt.Errorf("unknown key type %d", keyType)
+}
+
+// signerBytes is the P-256 scalar, padded to 32 bytes, or the Ed25519 seed
+func signerBytes(signer Signer) []byte {
+	switch key := signer.(type) {
+	case p256Signer:
+		return key.D.FillBytes(make([]byte, scalarSize))
+	case ed25519Signer:
+		return ed25519.PrivateKey(key).Seed()
+	}
+	panic(fmt.Sprintf("unknown signer %T", signer))
+}
+
+// parsePublicKey decodes a public key of either type. P-256 keys must be compressed points on
+// the curve.
+func parsePublicKey(pubKey []byte) (PublicKey, error) {
+	if len(pubKey) != pubKeySize {
+		return nil, fmt.Errorf("public key %x is not a %d byte key", pubKey, pubKeySize)
+	}
+	switch pubKey[0] {
+	case 0x02, 0x03:
+		x, y := elliptic.UnmarshalCompressed(elliptic.P256(), pubKey)
+		if x == nil {
+			return nil, fmt.Errorf("public key %x is not on the curve", pubKey)
+		}
+		return p256PublicKey{ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}}, nil
+	case ed25519KeyPrefix:
+		return ed25519PublicKey(pubKey[1:]), nil
+	}
+	return nil, fmt.Errorf("public key %x has unknown type %#02x", pubKey, pubKey[0])
+}
+
+type p256Signer struct {
+	ecdsa.PrivateKey
+}
+
+func (key p256Signer) Type() KeyType { return KeyP256 }
+
+func (key p256Signer) Public() PublicKey { return p256PublicKey{key.PublicKey} }
+
+func (key p256Signer) Sign(hash []byte) []byte { return signHash(key.PrivateKey, hash) }
+
+type p256PublicKey struct {
+	ecdsa.PublicKey
+}
+
+func (key p256PublicKey) Type() KeyType { return KeyP256 }
+
+func (key p256PublicKey) Bytes() []byte {
+	return elliptic.MarshalCompressed(elliptic.P256(), key.X, key.Y)
+}
+
+func (key p256PublicKey) Verify(hash, signature []byte) bool {
+	r, s, err := parseSignature(signature)
+	if err != nil {
+		return false
+	}
+	return ecdsa.Verify(&key.PublicKey, hash, r, s)
+}
+
+func (key p256PublicKey) checkSignature(signature []byte) error {
+	_, _, err := parseSignature(signature)
+	return err
+}
+
+type ed25519Signer ed25519.PrivateKey
+
+func (key ed25519Signer) Type() KeyType { return KeyEd25519 }
+
+func (key ed25519Signer) Public() PublicKey {
+	return ed25519PublicKey(ed25519.PrivateKey(key).Public().(ed25519.PublicKey))
+}
+
+func (key ed25519Signer) Sign(hash []byte) []byte { return ed25519.Sign(ed25519.PrivateKey(key), hash) }
+
+type ed25519PublicKey ed25519.PublicKey
+
+func (key ed25519PublicKey) Type() KeyType { return KeyEd25519 }
+
+func (key ed25519PublicKey) Bytes() []byte { return append([]byte{ed25519KeyPrefix}, key...) }
+
+// Verify rejects s of the group order or more and an R that is not canonically encoded, so a
+// signature cannot be encoded another way
+func (key ed25519PublicKey) Verify(hash, signature []byte) bool {
+	return len(signature) == ed25519.SignatureSize && ed25519.Verify(ed25519.PublicKey(key), hash, signature)
+}
+
+func (key ed25519PublicKey) checkSignature(signature []byte) error {
+	if len(signature) != ed25519.SignatureSize {
+		return fmt.Errorf("signature of %d bytes, it must have %d", len(signature), ed25519.SignatureSize)
+	}
+	// s, little endian in the second half, must be below the group order
+	s := signature[32:]
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] != ed25519Order[i] {
+			if s[i] < ed25519Order[i] {
+				return nil
+			}
+			break
+		}
+	}
+	return errors.New("signature s is out of range")
+}
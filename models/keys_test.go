@@ -0,0 +1,234 @@
+package models
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"math/big"
+	"testing"
+)
+
+// p256Key rebuilds the P-256 key with scalar d
+func p256Key(t *testing.T, d *big.Int) Signer {
+	t.Helper()
+	signer, err := signerFromBytes(KeyP256, d.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestShortScalarRoundTrip(t *testing.T) {
+	// scalars of 31 bytes, 1 byte, and 32 bytes with zero in the second byte
+	scalars := []*big.Int{
+		new(big.Int).Lsh(big.NewInt(0xabcdef), 224),
+		big.NewInt(7),
+		new(big.Int).Add(new(big.Int).Lsh(big.NewInt(0x80), 248), big.NewInt(0x1234)),
+	}
+	for _, d := range scalars {
+		signer := p256Key(t, d)
+		stored := signerBytes(signer)
+		if len(stored) != scalarSize {
+			t.Fatalf("scalar %x stored in %d bytes", d, len(stored))
+		}
+
+		restored, err := signerFromBytes(KeyP256, stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(restored.Public().Bytes(), signer.Public().Bytes()) {
+			t.Errorf("scalar %x came back as another key", d)
+		}
+
+		w := Wallet{signer, signer.Public().Bytes()}
+		data, err := w.GobEncode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Wallet
+		if err := decoded.GobDecode(data); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded.Address(), w.Address()) {
+			t.Errorf("wallet of scalar %x came back with another address", d)
+		}
+		hash := sha256.Sum256([]byte("short scalar"))
+		if !decoded.PrivateKey.Public().Verify(hash[:], signer.Sign(hash[:])) {
+			t.Errorf("wallet of scalar %x does not verify its own signatures", d)
+		}
+	}
+}
+
+func TestScalarOutOfRange(t *testing.T) {
+	n := elliptic.P256().Params().N
+	for _, data := range [][]byte{nil, make([]byte, scalarSize), n.Bytes(), make([]byte, scalarSize+1)} {
+		if _, err := signerFromBytes(KeyP256, data); err == nil {
+			t.Errorf("scalar %x accepted", data)
+		}
+	}
+}
+
+func TestEd25519SeedLeadingZeros(t *testing.T) {
+	seed := make([]byte, 32)
+	seed[31] = 1
+	signer, err := signerFromBytes(KeyEd25519, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored := signerBytes(signer); !bytes.Equal(stored, seed) {
+		t.Errorf("seed %x stored as %x", seed, stored)
+	}
+}
+
+func TestPublicKeyLeadingZeroX(t *testing.T) {
+	// about one key in 256 has an X starting with a zero byte
+	found := 0
+	for d := int64(1); found < 3; d++ {
+		signer := p256Key(t, big.NewInt(d))
+		x := signer.(p256Signer).X
+		if x.BitLen() > 8*(scalarSize-1) {
+			continue
+		}
+		found++
+
+		pubKey := signer.Public().Bytes()
+		if len(pubKey) != pubKeySize {
+			t.Fatalf("key %d with X %x encoded in %d bytes", d, x, len(pubKey))
+		}
+		if pubKey[1] != 0 {
+			t.Errorf("key %d with X %x encoded as %x", d, x, pubKey)
+		}
+		parsed, err := parsePublicKey(pubKey)
+		if err != nil {
+			t.Fatalf("key %d: %v", d, err)
+		}
+		if !bytes.Equal(parsed.Bytes(), pubKey) {
+			t.Errorf("key %d came back as %x", d, parsed.Bytes())
+		}
+
+		hash := sha256.Sum256(pubKey)
+		if !verifySignature(pubKey, signer.Sign(hash[:]), hash[:]) {
+			t.Errorf("key %d does not verify its own signature", d)
+		}
+	}
+}
+
+func TestSignatureLeadingZeros(t *testing.T) {
+	signer := p256Key(t, big.NewInt(0x5eed))
+	pubKey := signer.Public().Bytes()
+
+	// about one signature in 128 has r or s starting with a zero byte
+	found := 0
+	for i := 0; found < 3; i++ {
+		hash := sha256.Sum256([]byte{byte(i), byte(i >> 8)})
+		signature := signer.Sign(hash[:])
+		if len(signature) != signatureSize {
+			t.Fatalf("signature of %d bytes", len(signature))
+		}
+		if signature[0] != 0 && signature[scalarSize] != 0 {
+			continue
+		}
+		found++
+
+		if _, _, err := parseSignature(signature); err != nil {
+			t.Errorf("signature %x: %v", signature, err)
+		}
+		if !verifySignature(pubKey, signature, hash[:]) {
+			t.Errorf("signature %x does not verify", signature)
+		}
+	}
+}
+
+func TestEd25519Signatures(t *testing.T) {
+	if keyType, err := ParseKeyType(" Ed25519 "); err != nil || keyType != KeyEd25519 {
+		t.Errorf("parsed ed25519 as %v, %v", keyType, err)
+	}
+	if _, err := ParseKeyType("rsa"); err == nil {
+		t.Error("parsed an unknown key type")
+	}
+
+	signer, p256 := NewSigner(KeyEd25519), NewSigner(KeyP256)
+	pubKey := signer.Public().Bytes()
+	if len(pubKey) != pubKeySize || pubKey[0] != ed25519KeyPrefix {
+		t.Fatalf("Ed25519 public key %x", pubKey)
+	}
+	parsed, err := parsePublicKey(pubKey)
+	if err != nil || parsed.Type() != KeyEd25519 || !bytes.Equal(parsed.Bytes(), pubKey) {
+		t.Fatalf("public key %x parsed as %v, %v", pubKey, parsed, err)
+	}
+
+	hash := sha256.Sum256([]byte("ed25519"))
+	signature := signer.Sign(hash[:])
+	if !signer.Public().Verify(hash[:], signature) || !verifySignature(pubKey, signature, hash[:]) {
+		t.Fatal("Ed25519 signature does not verify")
+	}
+	if err := parsed.checkSignature(signature); err != nil {
+		t.Errorf("Ed25519 signature: %v", err)
+	}
+	other := sha256.Sum256([]byte("other"))
+	if verifySignature(pubKey, signature, other[:]) {
+		t.Error("signature verifies another hash")
+	}
+	if verifySignature(NewSigner(KeyEd25519).Public().Bytes(), signature, hash[:]) {
+		t.Error("signature verifies with another key")
+	}
+
+	// signatures of one key type never verify with a key of the other
+	if verifySignature(pubKey, p256.Sign(hash[:]), hash[:]) {
+		t.Error("P-256 signature verifies with an Ed25519 key")
+	}
+	if verifySignature(p256.Public().Bytes(), signature, hash[:]) {
+		t.Error("Ed25519 signature verifies with a P-256 key")
+	}
+
+	// s plus the group order, which still fits in 32 bytes, is another encoding of the signature
+	malleated := append([]byte(nil), signature...)
+	carry := 0
+	for i := range ed25519Order {
+		sum := int(malleated[32+i]) + int(ed25519Order[i]) + carry
+		malleated[32+i], carry = byte(sum), sum>>8
+	}
+	if err := parsed.checkSignature(malleated); err == nil {
+		t.Error("s plus the group order accepted")
+	}
+	if parsed.Verify(hash[:], malleated) {
+		t.Error("s plus the group order verifies")
+	}
+}
+
+func TestMixedKeyTransaction(t *testing.T) {
+	p256, ed := MakeWallet(KeyP256), MakeWallet(KeyEd25519)
+	redeemScript, err := MultiSigRedeemScript(2, [][]byte{p256.PublicKey, ed.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevOuts := []TxOutput{
+		*NewTxOutput(UnitsPerCoin, string(p256.Address())),
+		*NewTxOutput(UnitsPerCoin, string(ed.Address())),
+		{UnitsPerCoin, PayToScriptHashScript(ScriptHash(redeemScript))},
+	}
+	tx := Transaction{nil, []TxInput{
+		{bytes.Repeat([]byte{0x11}, 32), 0, nil, SequenceFinal},
+		{bytes.Repeat([]byte{0x22}, 32), 1, nil, SequenceFinal},
+		{bytes.Repeat([]byte{0x33}, 32), 2, nil, SequenceFinal},
+	}, []TxOutput{*NewTxOutput(3*UnitsPerCoin-1000, string(ed.Address()))}, 0, TxVersion}
+	tx.SetID()
+
+	tx.Sign(p256.PrivateKey, prevOuts, SigHashAll)
+	tx.Sign(ed.PrivateKey, prevOuts, SigHashAll)
+	tx.SignMultiSig(ed.PrivateKey, redeemScript, prevOuts, SigHashAll)
+	if tx.Verify(prevOuts) {
+		t.Error("multisig input verifies with one of two signatures")
+	}
+	tx.SignMultiSig(p256.PrivateKey, redeemScript, prevOuts, SigHashAll)
+	if !tx.Verify(prevOuts) {
+		t.Fatal("transaction mixing P-256 and Ed25519 keys does not verify")
+	}
+
+	swapped := tx
+	swapped.Inputs = append([]TxInput(nil), tx.Inputs...)
+	swapped.Inputs[0].ScriptSig, swapped.Inputs[1].ScriptSig = tx.Inputs[1].ScriptSig, tx.Inputs[0].ScriptSig
+	if swapped.Verify(prevOuts) {
+		t.Error("unlocking scripts of the two key types verify when swapped")
+	}
+}
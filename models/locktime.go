@@ -0,0 +1,134 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Lock times below LockTimeThreshold are block heights, the others unix timestamps. Times are
+// compared against the median time past of the chain rather than a single block timestamp, so a
+// miner cannot move them forward by lying about the time.
+const (
+	LockTimeThreshold = 500000000
+	SequenceFinal     = uint32(0xffffffff) // the input does not use lock times
+
+	// An input sequence without the disable flag is a relative lock time: the output it spends
+	// must be SequenceLockTimeMask blocks, or units of 512 seconds with the type flag, old.
+	SequenceLockTimeDisabled    = uint32(1 << 31)
+	SequenceLockTimeTypeFlag    = uint32(1 << 22)
+	SequenceLockTimeMask        = uint32(0x0000ffff)
+	SequenceLockTimeGranularity = 9
+
+	medianTimeSpan     = 11 // blocks in the median time past
+	maxFutureBlockTime = 2 * time.Hour
+)
+
+// IsFinal reports whether the transaction may be included in a block at height, on top of a
+// chain with median time past mtp. A lock time is ignored when every input has SequenceFinal.
+func (tx *Transaction) IsFinal(height int, mtp int64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+	cutoff := int64(height)
+	if tx.LockTime >= LockTimeThreshold {
+		cutoff = mtp
+	}
+	if int64(tx.LockTime) < cutoff {
+		return true
+	}
+
+	for _, in := range tx.Inputs {
+		if in.Sequence != SequenceFinal {
+			return false
+		}
+	}
+	return true
+}
+
+// sequenceLock is the last height and median time past at which a transaction is still locked
+// by the relative lock times of its inputs, -1 when not locked
+type sequenceLock struct {
+	height int
+	time   int64
+}
+
+// sequenceLock works out the relative lock of tx. spent holds the outputs its inputs spend, in
+// input order.
+func (tx *Transaction) sequenceLock(spent []spentOutput) sequenceLock {
+	lock := sequenceLock{-1, -1}
+	if tx.IsCoinbase() {
+		return lock
+	}
+
+	for inId, in := range tx.Inputs {
+		if in.Sequence&SequenceLockTimeDisabled != 0 {
+			continue
+		}
+		value := in.Sequence & SequenceLockTimeMask
+
+		if in.Sequence&SequenceLockTimeTypeFlag != 0 {
+			// counted from the median time past of the block before the output's block
+			locked := spent[inId].time + int64(value)<<SequenceLockTimeGranularity - 1
+			if locked > lock.time {
+				lock.time = locked
+			}
+		} else if locked := spent[inId].height + int(value) - 1; locked > lock.height {
+			lock.height = locked
+		}
+	}
+	return lock
+}
+
+// satisfied reports whether a block at height on top of a chain with median time past mtp is
+// past the lock
+func (lock sequenceLock) satisfied(height int, mtp int64) bool {
+	return lock.height < height && lock.time < mtp
+}
+
+// MedianTimePast returns the median timestamp of the block with the given hash and the ones
+// before it, up to medianTimeSpan blocks. Blocks that are not stored, such as those below a
+// snapshot base, are left out, and a chain without any gives 0.
+func (bc *BlockChain) MedianTimePast(hash []byte) int64 {
+	var times []int64
+
+	for len(hash) != 0 && len(times) < medianTimeSpan {
+		block, err := bc.GetBlock(hash)
+		if err != nil {
+			break
+		}
+		times = append(times, block.Timestamp)
+		hash = block.PrevHash
+	}
+	if len(times) == 0 {
+		return 0
+	}
+
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2]
+}
+
+// checkLocks checks the absolute and relative lock times of tx for a block at height on top of
+// a chain with median time past mtp
+func (bc *BlockChain) checkLocks(tx *Transaction, spent []spentOutput, height int, mtp int64) error {
+	if !tx.IsFinal(height, mtp) {
+		if tx.LockTime < LockTimeThreshold {
+			return fmt.Errorf("transaction %x is locked until height %d", tx.ID, tx.LockTime)
+		}
+		return fmt.Errorf("transaction %x is locked until %s", tx.ID, time.Unix(int64(tx.LockTime), 0).UTC().Format(time.RFC3339))
+	}
+	if !tx.sequenceLock(spent).satisfied(height, mtp) {
+		return fmt.Errorf("transaction %x spends outputs that are not old enough", tx.ID)
+	}
+	return nil
+}
+
+// blockTime picks the timestamp of a new block, the current time unless the median time past
+// of the chain below is already later
+func blockTime(mtp int64) int64 {
+	now := time.Now().Unix()
+	if now <= mtp {
+		return mtp + 1
+	}
+	return now
+}
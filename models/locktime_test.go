@@ -0,0 +1,152 @@
+package models
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestIsFinal(t *testing.T) {
+	const mtp = LockTimeThreshold + 1000
+	tests := []struct {
+		name     string
+		lockTime int
+		sequence uint32
+		height   int
+		want     bool
+	}{
+		{"no lock time", 0, 0, 1, true},
+		{"height below", 9, 0, 10, true},
+		{"height reached", 10, 0, 10, false},
+		{"height above", 11, 0, 10, false},
+		{"height turned off", 11, SequenceFinal, 10, true},
+		{"last height", LockTimeThreshold - 1, 0, LockTimeThreshold, true},
+		{"time below", mtp - 1, 0, 1, true},
+		{"time reached", mtp, 0, 1, false},
+		{"time turned off", mtp + 1, SequenceFinal, 1, true},
+		// a time lock is compared to the median time past, never to the height
+		{"first time", LockTimeThreshold, 0, LockTimeThreshold + 1, true},
+	}
+	for _, test := range tests {
+		tx := Transaction{Inputs: []TxInput{{Sequence: SequenceFinal}, {Sequence: test.sequence}}, LockTime: test.lockTime}
+		if got := tx.IsFinal(test.height, mtp); got != test.want {
+			t.Errorf("%s: final %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSequenceLock(t *testing.T) {
+	spent := []spentOutput{{height: 100, time: 1000000}, {height: 90, time: 900000}}
+	tests := []struct {
+		name      string
+		sequences []uint32
+		want      sequenceLock
+	}{
+		{"disabled", []uint32{SequenceFinal, SequenceLockTimeDisabled | 5}, sequenceLock{-1, -1}},
+		{"one block", []uint32{1, SequenceFinal}, sequenceLock{100, -1}},
+		{"blocks, the later lock wins", []uint32{3, 20}, sequenceLock{109, -1}},
+		{"only the low bits count", []uint32{1<<16 | 3, SequenceFinal}, sequenceLock{102, -1}},
+		{"512 seconds", []uint32{SequenceLockTimeTypeFlag | 1, SequenceFinal}, sequenceLock{-1, 1000511}},
+		{"blocks and time", []uint32{2, SequenceLockTimeTypeFlag | 4}, sequenceLock{101, 902047}},
+	}
+	for _, test := range tests {
+		tx := Transaction{Inputs: []TxInput{{ID: []byte{1}, Sequence: test.sequences[0]}, {ID: []byte{2}, Sequence: test.sequences[1]}}}
+		lock := tx.sequenceLock(spent)
+		if lock != test.want {
+			t.Errorf("%s: locked to %+v, want %+v", test.name, lock, test.want)
+		}
+		if lock.satisfied(lock.height, lock.time+1) || lock.satisfied(lock.height+1, lock.time) {
+			t.Errorf("%s: satisfied at the lock", test.name)
+		}
+		if !lock.satisfied(lock.height+1, lock.time+1) {
+			t.Errorf("%s: not satisfied past the lock", test.name)
+		}
+	}
+
+	coinbase := Transaction{Inputs: []TxInput{{Out: -1, Sequence: 5}}}
+	if lock := coinbase.sequenceLock(nil); lock != (sequenceLock{-1, -1}) {
+		t.Errorf("coinbase locked to %+v", lock)
+	}
+}
+
+func TestLockTimeChecks(t *testing.T) {
+	tx := &Transaction{Inputs: []TxInput{{Sequence: 0}}}
+	lockTimes := []struct {
+		txLockTime int
+		sequence   uint32
+		lockTime   int64
+		want       bool
+	}{
+		{100, 0, 100, true},
+		{100, 0, 101, false},
+		{100, SequenceFinal, 50, false},
+		{100, 0, -1, false},
+		{LockTimeThreshold + 5, 0, LockTimeThreshold, true},
+		{LockTimeThreshold + 5, 0, 100, false},
+		{100, 0, LockTimeThreshold, false},
+	}
+	for _, test := range lockTimes {
+		tx.LockTime, tx.Inputs[0].Sequence = test.txLockTime, test.sequence
+		if got := (inputChecker{tx: tx}).checkLockTime(test.lockTime); got != test.want {
+			t.Errorf("lock time %d, sequence %x, CHECKLOCKTIMEVERIFY %d: %v", test.txLockTime, test.sequence, test.lockTime, got)
+		}
+	}
+
+	sequences := []struct {
+		sequence uint32
+		wanted   int64
+		want     bool
+	}{
+		{10, 10, true},
+		{10, 11, false},
+		{SequenceLockTimeDisabled | 20, 1, false},
+		{10, int64(SequenceLockTimeDisabled), true},
+		{SequenceLockTimeTypeFlag | 4, int64(SequenceLockTimeTypeFlag | 4), true},
+		{SequenceLockTimeTypeFlag | 4, 4, false},
+		{4, int64(SequenceLockTimeTypeFlag | 1), false},
+		{10, -1, false},
+	}
+	for _, test := range sequences {
+		tx.Inputs[0].Sequence = test.sequence
+		if got := (inputChecker{tx: tx}).checkSequence(test.wanted); got != test.want {
+			t.Errorf("sequence %x, CHECKSEQUENCEVERIFY %x: %v", test.sequence, test.wanted, got)
+		}
+	}
+}
+
+func TestMedianTimePast(t *testing.T) {
+	chdirTemp(t)
+	address := string(MakeWallet(KeyP256).Address())
+	quiet(t, func() {
+		chain := InitBlockChain(address, false)
+		defer chain.Database.Close()
+		UTXOSet{chain}.Reindex()
+		genesis, err := chain.GetBlock(chain.Tip())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// timestamps out of order, each after the median of the 11 blocks before it
+		offsets := []int64{5, 9, 6, 12, 7, 10, 8, 14, 9, 11, 13, 10, 15}
+		var blocks []*Block
+		for _, offset := range offsets {
+			blocks = append(blocks, mineVersion(t, chain, address, VersionBitsTopBits, genesis.Timestamp+100+offset))
+		}
+		tests := []struct {
+			block *Block
+			want  int64
+		}{
+			{genesis, genesis.Timestamp},
+			{blocks[1], genesis.Timestamp + 100 + 5},
+			{blocks[9], genesis.Timestamp + 100 + 9},
+			{blocks[12], genesis.Timestamp + 100 + 10},
+		}
+		for _, test := range tests {
+			if got := chain.MedianTimePast(test.block.Hash); got != test.want {
+				t.Errorf("height %d: median time past %d, want %d", test.block.Height, got, test.want)
+			}
+		}
+		if got := chain.MedianTimePast(bytes.Repeat([]byte{0x42}, 32)); got != 0 {
+			t.Errorf("unknown block: median time past %d", got)
+		}
+	})
+}
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"bytes"
+	"github.com/dgraph-io/badger"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// gcDiscardRatio is the share of a value log file that must be garbage before badger rewrites it
+const gcDiscardRatio = 0.5
+
+// metadataKeys are the single keys the chain keeps next to blocks and UTXO entries
+var metadataKeys = [][]byte{[]byte("lh"), snapshotKey, reindexKey, utxoTipKey, storageKey, feeStatsKey}
+
+// PrefixStats is the number and size of the keys in one group of the database
+type PrefixStats struct {
+	Name  string
+	Keys  int
+	Bytes int64 // keys plus values
+}
+
+// DBStats describes what the database holds and how large its files are on disk
+type DBStats struct {
+	Prefixes       []PrefixStats
+	LSMSize        int64
+	ValueLogSize   int64
+	BlockFilesSize int64
+}
+
+// CompactResult reports the on-disk size before and after CompactDB
+type CompactResult struct {
+	Before, After  DBStats
+	ValueLogCycles int
+}
+
+// runValueLogGC rewrites value log files until badger finds none worth rewriting and returns how
+// many were rewritten
+func (bc *BlockChain) runValueLogGC() int {
+	cycles := 0
+	for bc.Database.RunValueLogGC(gcDiscardRatio) == nil {
+		cycles++
+	}
+	return cycles
+}
+
+// StartValueLogGC runs the value log GC every interval on its own goroutine, for processes that
+// keep the chain open for a long time. The returned function stops it and waits for a running
+// GC to finish.
+func (bc *BlockChain) StartValueLogGC(interval time.Duration) func() {
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				bc.runValueLogGC()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			wg.Wait()
+		})
+	}
+}
+
+// Stats walks every key and groups the sizes by what the keys store
+func (bc *BlockChain) Stats() (DBStats, error) {
+	groups := []struct {
+		stats  PrefixStats
+		prefix []byte
+	}{
+		{PrefixStats{Name: "utxo"}, utxoPrefix},
+		{PrefixStats{Name: "block index"}, blockIdxPrefix},
+		{PrefixStats{Name: "mempool"}, mempoolPrefix},
+	}
+	blocks := PrefixStats{Name: "blocks"}
+	metadata := PrefixStats{Name: "metadata"}
+	other := PrefixStats{Name: "other"}
+
+	err := bc.Database.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+	Keys:
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			key := item.Key()
+			size := item.KeySize() + item.ValueSize()
+
+			for i := range groups {
+				if bytes.HasPrefix(key, groups[i].prefix) {
+					groups[i].stats.Keys++
+					groups[i].stats.Bytes += size
+					continue Keys
+				}
+			}
+			for _, metaKey := range metadataKeys {
+				if bytes.Equal(key, metaKey) {
+					metadata.Keys++
+					metadata.Bytes += size
+					continue Keys
+				}
+			}
+			// Blocks stored as badger values are keyed by their sha256 hash
+			if len(key) == 32 {
+				blocks.Keys++
+				blocks.Bytes += size
+				continue
+			}
+			other.Keys++
+			other.Bytes += size
+		}
+		return nil
+	})
+	if err != nil {
+		return DBStats{}, err
+	}
+
+	var stats DBStats
+	stats.Prefixes = append(stats.Prefixes, blocks)
+	for _, group := range groups {
+		stats.Prefixes = append(stats.Prefixes, group.stats)
+	}
+	stats.Prefixes = append(stats.Prefixes, metadata, other)
+
+	// badger's own Size is only refreshed once a minute, so look at the files directly
+	err = filepath.Walk(dbPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch filepath.Ext(path) {
+		case ".sst":
+			stats.LSMSize += info.Size()
+		case ".vlog":
+			stats.ValueLogSize += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	if bc.blockFiles != nil {
+		names, err := filepath.Glob(filepath.Join(bc.blockFiles.dir, "blk*.dat"))
+		if err != nil {
+			return stats, err
+		}
+		for _, name := range names {
+			if info, err := os.Stat(name); err == nil {
+				stats.BlockFilesSize += info.Size()
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// CompactDB flattens the LSM tree into one level and then runs the value log GC until nothing is
+// left to reclaim. It is meant for manual maintenance while no blocks are being connected.
+func (bc *BlockChain) CompactDB() (CompactResult, error) {
+	var result CompactResult
+	var err error
+
+	if result.Before, err = bc.Stats(); err != nil {
+		return result, err
+	}
+	if err = bc.Database.Flatten(runtime.NumCPU()); err != nil {
+		return result, err
+	}
+	result.ValueLogCycles = bc.runValueLogGC()
+	result.After, err = bc.Stats()
+
+	return result, err
+}
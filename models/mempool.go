@@ -0,0 +1,242 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/bucks-go-wallet/utils"
+	"github.com/dgraph-io/badger"
+	"sort"
+)
+
+// MaxBlockSize bounds the serialized transactions MineBlock puts in a block
+const MaxBlockSize = 4000
+
+var mempoolPrefix = []byte("mempool-")
+
+// MempoolEntry is a transaction waiting to be mined
+type MempoolEntry struct {
+	Tx     *Transaction
+	Fee    Amount
+	Size   int // serialized bytes
+	Height int // chain height when it entered the mempool
+}
+
+// FeeRate returns the fee the entry pays per byte
+func (entry MempoolEntry) FeeRate() FeeRate {
+	return feeRateOf(entry.Fee, entry.Size)
+}
+
+func outpointKey(txID []byte, out int) string {
+	return fmt.Sprintf("%x:%d", txID, out)
+}
+
+// AddToMempool checks tx against the UTXO set and the next block and stores it until it is
+// mined. Transactions spending outputs of other mempool transactions are not accepted.
+func (bc *BlockChain) AddToMempool(tx *Transaction) (MempoolEntry, error) {
+	bc.writeLock.Lock()
+	defer bc.writeLock.Unlock()
+
+	if tx.IsCoinbase() {
+		return MempoolEntry{}, errors.New("coinbase transactions are only valid in blocks")
+	}
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return MempoolEntry{}, fmt.Errorf("transaction ID %x does not match its contents", tx.ID)
+	}
+	if tx.Version < 1 || tx.Version > TxVersion {
+		return MempoolEntry{}, fmt.Errorf("transaction %x has version %d, the mempool takes 1 to %d", tx.ID, tx.Version, TxVersion)
+	}
+
+	entries := bc.MempoolEntries()
+	spent := make(map[string]bool)
+	for _, entry := range entries {
+		if bytes.Equal(entry.Tx.ID, tx.ID) {
+			return MempoolEntry{}, fmt.Errorf("transaction %x is already in the mempool", tx.ID)
+		}
+		for _, in := range entry.Tx.Inputs {
+			spent[outpointKey(in.ID, in.Out)] = true
+		}
+	}
+
+	set := UTXOSet{bc}
+	for _, in := range tx.Inputs {
+		if spent[outpointKey(in.ID, in.Out)] {
+			return MempoolEntry{}, fmt.Errorf("output %x:%d is spent by a mempool transaction", in.ID, in.Out)
+		}
+		if _, ok := set.FindOutput(in.ID, in.Out); !ok {
+			return MempoolEntry{}, fmt.Errorf("output %x:%d is not in the UTXO set", in.ID, in.Out)
+		}
+	}
+
+	tip := bc.Tip()
+	height, err := bc.blockHeight(tip)
+	if err != nil {
+		return MempoolEntry{}, err
+	}
+	fee, err := bc.verifyTransaction(tx, height+1, bc.MedianTimePast(tip), newUTXOView(set))
+	if err != nil {
+		return MempoolEntry{}, err
+	}
+
+	entry := MempoolEntry{tx, fee, len(tx.Serialize()), height}
+	err = bc.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(append(mempoolPrefix, tx.ID...), encodeMempoolEntry(entry))
+	})
+	if err != nil {
+		return MempoolEntry{}, err
+	}
+	return entry, nil
+}
+
+// encodeMempoolEntry writes the fee, the height and the serialized transaction of an entry, the
+// size follows from the transaction
+func encodeMempoolEntry(entry MempoolEntry) []byte {
+	var e encoder
+	e.buf.WriteByte(encodingVersion)
+	e.putUint64(uint64(entry.Fee))
+	e.putInt64(int64(entry.Height))
+	e.putBytes(entry.Tx.Serialize())
+	return e.buf.Bytes()
+}
+
+func decodeMempoolEntry(data []byte) (MempoolEntry, error) {
+	d := decoder{data: data}
+
+	d.version()
+	fee := Amount(d.uint64())
+	height := int(d.int64())
+	encoded := d.bytes()
+	if err := d.finish(); err != nil {
+		return MempoolEntry{}, fmt.Errorf("decode mempool entry: %w", err)
+	}
+
+	tx, err := decodeTransaction(encoded)
+	if err != nil {
+		return MempoolEntry{}, err
+	}
+	return MempoolEntry{tx, fee, len(encoded), height}, nil
+}
+
+// MempoolEntries returns the waiting transactions, highest fee rate first
+func (bc *BlockChain) MempoolEntries() []MempoolEntry {
+	var entries []MempoolEntry
+
+	err := bc.Database.View(func(txn *badger.Txn) error {
+		var err error
+		entries, err = mempoolEntries(txn)
+		return err
+	})
+	utils.Handle(err)
+	return entries
+}
+
+func mempoolEntries(txn *badger.Txn) ([]MempoolEntry, error) {
+	var entries []MempoolEntry
+
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	for it.Seek(mempoolPrefix); it.ValidForPrefix(mempoolPrefix); it.Next() {
+		v, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		entry, err := decodeMempoolEntry(v)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].FeeRate() > entries[j].FeeRate() })
+	return entries, nil
+}
+
+// MempoolSpends reports the outputs spent by mempool transactions, keyed by txid:index in hex
+func (bc *BlockChain) MempoolSpends() map[string]bool {
+	spent := make(map[string]bool)
+	for _, entry := range bc.MempoolEntries() {
+		for _, in := range entry.Tx.Inputs {
+			spent[outpointKey(in.ID, in.Out)] = true
+		}
+	}
+	return spent
+}
+
+// MineBlock adds a block with the mempool transactions paying the highest fee rates, up to
+// MaxBlockSize bytes, after a coinbase paying the subsidy and their fees to miner. Transactions
+// that cannot go into the next block yet, because of their lock times, stay in the mempool.
+func (bc *BlockChain) MineBlock(miner string) *Block {
+	tip := bc.Tip()
+	height, err := bc.blockHeight(tip)
+	utils.Handle(err)
+	mtp := bc.MedianTimePast(tip)
+
+	var txs []*Transaction
+	var fees Amount
+	size := 0
+	view := newUTXOView(UTXOSet{bc})
+	for _, entry := range bc.MempoolEntries() {
+		if size+entry.Size > MaxBlockSize {
+			continue
+		}
+		fee, err := bc.verifyTransaction(entry.Tx, height+1, mtp, view)
+		if err != nil {
+			continue
+		}
+		total, err := fees.Add(fee)
+		if err != nil {
+			continue
+		}
+		if err := view.apply(entry.Tx, height+1, mtp); err != nil {
+			continue
+		}
+		txs = append(txs, entry.Tx)
+		fees = total
+		size += entry.Size
+	}
+	coinbase := NewCoinbaseTx(miner, height+1, fees)
+	return bc.AddBlock(append([]*Transaction{coinbase}, txs...))
+}
+
+// removeMined drops the block's transactions from the mempool, together with the ones spending
+// the same outputs, and records the fees of those that waited there
+func (bc *BlockChain) removeMined(txn *badger.Txn, block *Block) error {
+	entries, err := mempoolEntries(txn)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+
+	mined := make(map[string]bool)
+	spent := make(map[string]bool)
+	for _, tx := range block.Transactions {
+		mined[string(tx.ID)] = true
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			spent[outpointKey(in.ID, in.Out)] = true
+		}
+	}
+
+	var observations []feeObservation
+	for _, entry := range entries {
+		remove := mined[string(entry.Tx.ID)]
+		if remove {
+			observations = append(observations, feeObservation{block.Height, entry.FeeRate(), block.Height - entry.Height})
+		}
+		for _, in := range entry.Tx.Inputs {
+			remove = remove || spent[outpointKey(in.ID, in.Out)]
+		}
+		if !remove {
+			continue
+		}
+		if err := txn.Delete(append(mempoolPrefix, entry.Tx.ID...)); err != nil {
+			return err
+		}
+	}
+
+	if len(observations) == 0 {
+		return nil
+	}
+	return bc.recordFees(txn, block.Height, observations)
+}
@@ -39,9 +39,10 @@ func NewProof(b *Block) *ProofOfWork {
 
 func (pow *ProofOfWork) InitData(nonce int) []byte {
 	return bytes.Join([][]byte{
-		pow.Block.PrevHash,
-		pow.Block.HashTransactions(), ToHex(int64(nonce)),
-		ToHex(int64(Difficulty))},
+		ToHex(int64(pow.Block.Version)), pow.Block.PrevHash,
+		pow.Block.HashTransactions(), pow.Block.HashWitnesses(), ToHex(int64(nonce)),
+		ToHex(int64(Difficulty)), ToHex(int64(pow.Block.Height)),
+		ToHex(pow.Block.Timestamp)},
 		[]byte{})
 }
 
@@ -0,0 +1,227 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// psbtMagic starts every serialized PSBT
+var psbtMagic = []byte("psbt")
+
+// PSBT is a partially signed transaction: the transaction together with the output each input
+// spends and the redeem scripts of pay-to-script-hash inputs. That is all a signer needs, so
+// keys can sign on a machine without the chain, and signatures made on different machines can
+// be combined. Signatures cover the value and script of the output each input spends, so a
+// signer given the wrong PrevOutputs makes signatures that do not verify rather than paying a
+// fee it did not see.
+type PSBT struct {
+	Tx            Transaction
+	PrevOutputs   []TxOutput // the output each input spends
+	RedeemScripts [][]byte   // the redeem script of each pay-to-script-hash input, nil for others
+}
+
+// Serialize encodes the PSBT as the magic, the transaction and per input the output it spends
+// and its redeem script
+func (p *PSBT) Serialize() []byte {
+	var e encoder
+
+	e.buf.Write(psbtMagic)
+	e.putBytes(p.Tx.Serialize())
+	e.putUint32(uint32(len(p.PrevOutputs)))
+	for i, prevOut := range p.PrevOutputs {
+		e.putOutput(prevOut)
+		e.putBytes(p.RedeemScripts[i])
+	}
+	return e.buf.Bytes()
+}
+
+// DeserializePSBT reads a PSBT written by Serialize
+func DeserializePSBT(data []byte) (*PSBT, error) {
+	if !bytes.HasPrefix(data, psbtMagic) {
+		return nil, errors.New("decode psbt: not a PSBT")
+	}
+	d := decoder{data: data[len(psbtMagic):]}
+
+	encodedTx := d.bytes()
+	var prevOuts []TxOutput
+	var redeemScripts [][]byte
+	for i, n := 0, d.count(16); i < n; i++ {
+		prevOuts = append(prevOuts, d.output())
+		redeemScripts = append(redeemScripts, d.bytes())
+	}
+	if err := d.finish(); err != nil {
+		return nil, fmt.Errorf("decode psbt: %w", err)
+	}
+
+	tx, err := decodeTransaction(encodedTx)
+	if err != nil {
+		return nil, fmt.Errorf("decode psbt: %w", err)
+	}
+	if len(prevOuts) != len(tx.Inputs) {
+		return nil, fmt.Errorf("decode psbt: %d previous outputs for %d inputs", len(prevOuts), len(tx.Inputs))
+	}
+	return &PSBT{*tx, prevOuts, redeemScripts}, nil
+}
+
+// Fee returns what the previous outputs hold beyond what the transaction pays
+func (p *PSBT) Fee() (Amount, error) {
+	var inputs, outputs Amount
+	var err error
+	for _, prevOut := range p.PrevOutputs {
+		if inputs, err = inputs.Add(prevOut.Value); err != nil {
+			return 0, err
+		}
+	}
+	for _, out := range p.Tx.Outputs {
+		if outputs, err = outputs.Add(out.Value); err != nil {
+			return 0, err
+		}
+	}
+	fee, err := inputs.Sub(outputs)
+	if err != nil {
+		return 0, fmt.Errorf("outputs pay %s, the inputs hold only %s", outputs, inputs)
+	}
+	return fee, nil
+}
+
+// SignedSize returns the size the transaction will have once signed
+func (p *PSBT) SignedSize() int {
+	tx := p.Tx.TrimmedCopy()
+	for inId, prevOut := range p.PrevOutputs {
+		tx.Inputs[inId].ScriptSig = dummyScriptSig(prevOut, p.RedeemScripts[inId])
+	}
+	return len(tx.Serialize())
+}
+
+// dummyScriptSig is an unlocking script of the size a signed one for prevOut has
+func dummyScriptSig(prevOut TxOutput, redeemScript []byte) []byte {
+	signature := make([]byte, signatureSize+1) // with the hash type
+
+	switch ClassifyScript(prevOut.Script) {
+	case PubKeyHashScript:
+		return PushScript(signature, make([]byte, pubKeySize))
+	case ScriptHashScript:
+		if m, _, ok := multiSigParams(redeemScript); ok {
+			var elements [][]byte
+			for i := 0; i < m; i++ {
+				elements = append(elements, signature)
+			}
+			return PushScript(append(elements, redeemScript)...)
+		}
+	}
+	return nil
+}
+
+// Sign adds the signatures of the keys in the wallet file to the inputs they can sign, covering
+// what hashType says. Redeem scripts missing from the PSBT are taken from the wallet file. It
+// returns the number of inputs signed.
+func (p *PSBT) Sign(wallets *Wallets, hashType SigHashType) int {
+	before := make([][]byte, len(p.Tx.Inputs))
+	for inId, in := range p.Tx.Inputs {
+		before[inId] = in.ScriptSig
+	}
+
+	keys := make(map[string]Wallet)
+	redeemScripts := make(map[string][]byte)
+	for inId, prevOut := range p.PrevOutputs {
+		class, hash := scriptHashData(prevOut.Script)
+		switch class {
+		case PubKeyHashScript:
+			if w, ok := wallets.walletForPubKeyHash(hash); ok {
+				keys[string(w.Address())] = w
+			}
+		case ScriptHashScript:
+			if p.RedeemScripts[inId] == nil {
+				p.RedeemScripts[inId] = wallets.RedeemScripts[string(encodeAddress(scriptHashVersion, hash))]
+			}
+			redeemScript := p.RedeemScripts[inId]
+			if _, _, ok := multiSigParams(redeemScript); ok && bytes.Equal(ScriptHash(redeemScript), hash) {
+				redeemScripts[string(redeemScript)] = redeemScript
+			}
+		}
+	}
+
+	for _, w := range keys {
+		p.Tx.Sign(w.PrivateKey, p.PrevOutputs, hashType)
+	}
+	for _, redeemScript := range redeemScripts {
+		for _, w := range wallets.MultiSigSigners(redeemScript) {
+			p.Tx.SignMultiSig(w.PrivateKey, redeemScript, p.PrevOutputs, hashType)
+		}
+	}
+
+	signed := 0
+	for inId, in := range p.Tx.Inputs {
+		if !bytes.Equal(in.ScriptSig, before[inId]) {
+			signed++
+		}
+	}
+	return signed
+}
+
+// Finalize returns the signed transaction once every input satisfies the output it spends
+func (p *PSBT) Finalize() (*Transaction, error) {
+	for inId, prevOut := range p.PrevOutputs {
+		if err := p.Tx.VerifyInput(inId, prevOut); err != nil {
+			return nil, fmt.Errorf("input %d is not fully signed: %w", inId, err)
+		}
+	}
+
+	tx := p.Tx
+	return &tx, nil
+}
+
+// CombinePSBTs merges the signatures of PSBTs of the same transaction signed by different key
+// holders. Multisig inputs get the signatures of all of them, other inputs the first unlocking
+// script that satisfies the output they spend.
+func CombinePSBTs(psbts []*PSBT) (*PSBT, error) {
+	if len(psbts) == 0 {
+		return nil, errors.New("no PSBTs to combine")
+	}
+	first := psbts[0]
+	unsigned := first.Tx.TrimmedCopy()
+	for _, p := range psbts[1:] {
+		trimmed := p.Tx.TrimmedCopy()
+		if !bytes.Equal(trimmed.Serialize(), unsigned.Serialize()) {
+			return nil, fmt.Errorf("PSBTs are for different transactions, %x and %x", unsigned.Hash(), trimmed.Hash())
+		}
+		for inId, prevOut := range p.PrevOutputs {
+			if prevOut.Value != first.PrevOutputs[inId].Value || !bytes.Equal(prevOut.Script, first.PrevOutputs[inId].Script) {
+				return nil, fmt.Errorf("PSBTs disagree on the output input %d spends", inId)
+			}
+		}
+	}
+
+	combined := &PSBT{unsigned, first.PrevOutputs, make([][]byte, len(first.PrevOutputs))}
+	for inId, prevOut := range combined.PrevOutputs {
+		var scriptSigs [][]byte
+		for _, p := range psbts {
+			if combined.RedeemScripts[inId] == nil {
+				combined.RedeemScripts[inId] = p.RedeemScripts[inId]
+			}
+			if scriptSig := p.Tx.Inputs[inId].ScriptSig; len(scriptSig) > 0 {
+				scriptSigs = append(scriptSigs, scriptSig)
+			}
+		}
+		if len(scriptSigs) == 0 {
+			continue
+		}
+
+		redeemScript := combined.RedeemScripts[inId]
+		if _, _, ok := multiSigParams(redeemScript); ok {
+			combined.Tx.Inputs[inId].ScriptSig = combined.Tx.multiSigScriptSig(inId, redeemScript, prevOut, scriptSigs...)
+			continue
+		}
+		chosen := scriptSigs[0]
+		for _, scriptSig := range scriptSigs {
+			combined.Tx.Inputs[inId].ScriptSig = scriptSig
+			if combined.Tx.VerifyInput(inId, prevOut) == nil {
+				chosen = scriptSig
+				break
+			}
+		}
+		combined.Tx.Inputs[inId].ScriptSig = chosen
+	}
+	return combined, nil
+}
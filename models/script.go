@@ -0,0 +1,798 @@
+package models
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Outputs are locked by a script and inputs unlock them with a script of their own. To spend an
+// output the unlocking script is run first, it may only push data, then the locking script runs
+// on the resulting stack and must leave exactly one true element. A pay-to-script-hash output
+// only commits to the hash of a redeem script: the last element pushed by the unlocking script
+// is the redeem script, which then runs on the rest of the stack.
+//
+// The language is a small subset of Bitcoin script with the same opcode values. Elements are
+// byte strings, an element is true unless it is empty, all zero bytes or negative zero, zero
+// bytes ending in 0x80.
+const (
+	Op0                   = byte(0x00)
+	OpPushData1           = byte(0x4c)
+	OpPushData2           = byte(0x4d)
+	OpPushData4           = byte(0x4e)
+	Op1                   = byte(0x51)
+	Op16                  = byte(0x60)
+	OpNop                 = byte(0x61)
+	OpIf                  = byte(0x63)
+	OpNotIf               = byte(0x64)
+	OpElse                = byte(0x67)
+	OpEndIf               = byte(0x68)
+	OpVerify              = byte(0x69)
+	OpReturn              = byte(0x6a)
+	OpDrop                = byte(0x75)
+	OpDup                 = byte(0x76)
+	OpEqual               = byte(0x87)
+	OpEqualVerify         = byte(0x88)
+	OpSha256              = byte(0xa8)
+	OpHash160             = byte(0xa9)
+	OpCheckSig            = byte(0xac)
+	OpCheckSigVerify      = byte(0xad)
+	OpCheckMultiSig       = byte(0xae)
+	OpCheckMultiSigVerify = byte(0xaf)
+	OpCheckLockTimeVerify = byte(0xb1)
+	OpCheckSequenceVerify = byte(0xb2)
+)
+
+// Limits on scripts and their evaluation
+const (
+	MaxScriptSize         = 10000
+	MaxScriptElementSize  = 520
+	MaxStackSize          = 1000
+	MaxOpsPerScript       = 201 // opcodes other than pushes
+	MaxDataCarrierSize    = 80  // data in a data output
+	MaxPubKeysPerMultiSig = 16
+	maxScriptNumSize      = 4
+	maxLockTimeNumSize    = 5 // lock times and sequences may use the full u32 range
+)
+
+// ScriptClass is the standard template a locking script follows
+type ScriptClass int
+
+const (
+	NonStandardScript ScriptClass = iota
+	PubKeyHashScript
+	ScriptHashScript
+	DataCarrierScript
+	MultiSigScript
+	HTLCScript
+)
+
+var opcodeNames = map[byte]string{
+	Op0:                   "OP_0",
+	OpPushData1:           "OP_PUSHDATA1",
+	OpPushData2:           "OP_PUSHDATA2",
+	OpPushData4:           "OP_PUSHDATA4",
+	OpNop:                 "OP_NOP",
+	OpIf:                  "OP_IF",
+	OpNotIf:               "OP_NOTIF",
+	OpElse:                "OP_ELSE",
+	OpEndIf:               "OP_ENDIF",
+	OpVerify:              "OP_VERIFY",
+	OpReturn:              "OP_RETURN",
+	OpDrop:                "OP_DROP",
+	OpDup:                 "OP_DUP",
+	OpEqual:               "OP_EQUAL",
+	OpEqualVerify:         "OP_EQUALVERIFY",
+	OpSha256:              "OP_SHA256",
+	OpHash160:             "OP_HASH160",
+	OpCheckSig:            "OP_CHECKSIG",
+	OpCheckSigVerify:      "OP_CHECKSIGVERIFY",
+	OpCheckMultiSig:       "OP_CHECKMULTISIG",
+	OpCheckMultiSigVerify: "OP_CHECKMULTISIGVERIFY",
+	OpCheckLockTimeVerify: "OP_CHECKLOCKTIMEVERIFY",
+	OpCheckSequenceVerify: "OP_CHECKSEQUENCEVERIFY",
+}
+
+func init() {
+	for op := Op1; op <= Op16; op++ {
+		opcodeNames[op] = fmt.Sprintf("OP_%d", op-Op1+1)
+	}
+}
+
+var (
+	ErrScriptFailed      = errors.New("script evaluated to false")
+	ErrScriptNotPushOnly = errors.New("unlocking script must only push data")
+)
+
+// scriptOp is one parsed instruction, data is set for pushes
+type scriptOp struct {
+	opcode byte
+	data   []byte
+}
+
+func (op scriptOp) isPush() bool {
+	return op.opcode <= OpPushData4 || (op.opcode >= Op1 && op.opcode <= Op16)
+}
+
+func (op scriptOp) name() string {
+	if name, known := opcodeNames[op.opcode]; known {
+		return name
+	}
+	if op.isPush() {
+		return fmt.Sprintf("push of %d bytes", op.opcode)
+	}
+	return fmt.Sprintf("OP_UNKNOWN_%#x", op.opcode)
+}
+
+// parseScript splits a script into instructions, failing on truncated pushes
+func parseScript(script []byte) ([]scriptOp, error) {
+	var ops []scriptOp
+	for i := 0; i < len(script); {
+		opcode := script[i]
+		i++
+
+		var size int
+		switch {
+		case opcode > Op0 && opcode < OpPushData1:
+			size = int(opcode)
+		case opcode == OpPushData1 && i+1 <= len(script):
+			size = int(script[i])
+			i++
+		case opcode == OpPushData2 && i+2 <= len(script):
+			size = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		case opcode == OpPushData4 && i+4 <= len(script):
+			size = int(binary.LittleEndian.Uint32(script[i:]))
+			i += 4
+		case opcode >= OpPushData1 && opcode <= OpPushData4:
+			return nil, fmt.Errorf("truncated push length at offset %d", i-1)
+		}
+
+		if size < 0 || size > len(script)-i {
+			return nil, fmt.Errorf("push of %d bytes at offset %d runs past the script", size, i-1)
+		}
+		op := scriptOp{opcode: opcode}
+		if size > 0 {
+			op.data = script[i : i+size]
+			i += size
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// appendPush appends the shortest push of data to script
+func appendPush(script []byte, data []byte) []byte {
+	switch n := len(data); {
+	case n == 0:
+		return append(script, Op0)
+	case n < int(OpPushData1):
+		script = append(script, byte(n))
+	case n <= 0xff:
+		script = append(script, OpPushData1, byte(n))
+	case n <= 0xffff:
+		var size [2]byte
+		binary.LittleEndian.PutUint16(size[:], uint16(n))
+		script = append(append(script, OpPushData2), size[:]...)
+	default:
+		var size [4]byte
+		binary.LittleEndian.PutUint32(size[:], uint32(n))
+		script = append(append(script, OpPushData4), size[:]...)
+	}
+	return append(script, data...)
+}
+
+// PushScript returns a script pushing each element in turn, the form of every unlocking script
+func PushScript(elements ...[]byte) []byte {
+	var script []byte
+	for _, element := range elements {
+		script = appendPush(script, element)
+	}
+	return script
+}
+
+// scriptPushes returns the elements pushed by a push-only script
+func scriptPushes(script []byte) ([][]byte, error) {
+	ops, err := parseScript(script)
+	if err != nil {
+		return nil, err
+	}
+	var elements [][]byte
+	for _, op := range ops {
+		switch {
+		case op.opcode >= Op1 && op.opcode <= Op16:
+			elements = append(elements, []byte{op.opcode - Op1 + 1})
+		case op.isPush():
+			elements = append(elements, op.data)
+		default:
+			return nil, ErrScriptNotPushOnly
+		}
+	}
+	return elements, nil
+}
+
+// ScriptHash is the hash a pay-to-script-hash output commits to, the same hash160 that
+// addresses use for public keys
+func ScriptHash(script []byte) []byte {
+	return PublicKeyHash(script)
+}
+
+// PayToPubKeyHashScript locks an output to the key hashing to pubKeyHash:
+// OP_DUP OP_HASH160 <pubkey hash> OP_EQUALVERIFY OP_CHECKSIG
+func PayToPubKeyHashScript(pubKeyHash []byte) []byte {
+	script := appendPush([]byte{OpDup, OpHash160}, pubKeyHash)
+	return append(script, OpEqualVerify, OpCheckSig)
+}
+
+// PayToScriptHashScript locks an output to the redeem script hashing to scriptHash:
+// OP_HASH160 <script hash> OP_EQUAL
+func PayToScriptHashScript(scriptHash []byte) []byte {
+	script := appendPush([]byte{OpHash160}, scriptHash)
+	return append(script, OpEqual)
+}
+
+// DataOutputScript builds an unspendable output carrying data: OP_RETURN <data>
+func DataOutputScript(data []byte) ([]byte, error) {
+	if len(data) > MaxDataCarrierSize {
+		return nil, fmt.Errorf("data output of %d bytes exceeds the limit of %d", len(data), MaxDataCarrierSize)
+	}
+	return appendPush([]byte{OpReturn}, data), nil
+}
+
+// MultiSigRedeemScript builds the script requiring m signatures from the given keys:
+// OP_m <pubkey>... OP_n OP_CHECKMULTISIG. The keys are sorted, so any order of the same keys gives
+// the same script and address.
+func MultiSigRedeemScript(m int, pubKeys [][]byte) ([]byte, error) {
+	n := len(pubKeys)
+	if n == 0 || n > MaxPubKeysPerMultiSig {
+		return nil, fmt.Errorf("multisig needs 1 to %d keys, got %d", MaxPubKeysPerMultiSig, n)
+	}
+	if m < 1 || m > n {
+		return nil, fmt.Errorf("multisig threshold %d is not between 1 and %d", m, n)
+	}
+
+	for _, pubKey := range pubKeys {
+		if _, err := parsePublicKey(pubKey); err != nil {
+			return nil, err
+		}
+	}
+	sorted := append([][]byte{}, pubKeys...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+
+	script := []byte{Op1 + byte(m-1)}
+	for i, pubKey := range sorted {
+		if i > 0 && bytes.Equal(pubKey, sorted[i-1]) {
+			return nil, fmt.Errorf("multisig key %x appears twice", pubKey)
+		}
+		script = appendPush(script, pubKey)
+	}
+	script = append(script, Op1+byte(n-1), OpCheckMultiSig)
+
+	if len(script) > MaxScriptElementSize {
+		return nil, fmt.Errorf("redeem script of %d bytes is too large to spend, the limit is %d", len(script), MaxScriptElementSize)
+	}
+	return script, nil
+}
+
+// multiSigParams returns the threshold and keys of a script built by MultiSigRedeemScript
+func multiSigParams(script []byte) (int, [][]byte, bool) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) < 4 {
+		return 0, nil, false
+	}
+
+	first, last := ops[0].opcode, ops[len(ops)-2].opcode
+	if first < Op1 || first > Op16 || last < Op1 || last > Op16 || ops[len(ops)-1].opcode != OpCheckMultiSig {
+		return 0, nil, false
+	}
+	m, n := int(first-Op1)+1, int(last-Op1)+1
+
+	var pubKeys [][]byte
+	for _, op := range ops[1 : len(ops)-2] {
+		if !op.isPush() || len(op.data) == 0 {
+			return 0, nil, false
+		}
+		pubKeys = append(pubKeys, op.data)
+	}
+	if len(pubKeys) != n || m > n {
+		return 0, nil, false
+	}
+	return m, pubKeys, true
+}
+
+// isUnspendable reports whether no unlocking script can satisfy the locking script, such
+// outputs are left out of the UTXO set
+func isUnspendable(script []byte) bool {
+	return (len(script) > 0 && script[0] == OpReturn) || len(script) > MaxScriptSize
+}
+
+// ClassifyScript tells which standard template a locking script follows
+func ClassifyScript(script []byte) ScriptClass {
+	if _, _, ok := multiSigParams(script); ok {
+		return MultiSigScript
+	}
+	if _, err := ParseHTLC(script); err == nil {
+		return HTLCScript
+	}
+
+	switch {
+	case len(script) == 25 && script[0] == OpDup && script[1] == OpHash160 && script[2] == 20 &&
+		script[23] == OpEqualVerify && script[24] == OpCheckSig:
+		return PubKeyHashScript
+	case len(script) == 23 && script[0] == OpHash160 && script[1] == 20 && script[22] == OpEqual:
+		return ScriptHashScript
+	case len(script) > 0 && script[0] == OpReturn:
+		elements, err := scriptPushes(script[1:])
+		if err == nil && len(elements) <= 1 && len(script) <= MaxDataCarrierSize+3 {
+			return DataCarrierScript
+		}
+	}
+	return NonStandardScript
+}
+
+func (class ScriptClass) String() string {
+	switch class {
+	case PubKeyHashScript:
+		return "pubkeyhash"
+	case ScriptHashScript:
+		return "scripthash"
+	case DataCarrierScript:
+		return "data"
+	case MultiSigScript:
+		return "multisig"
+	case HTLCScript:
+		return "htlc"
+	}
+	return "nonstandard"
+}
+
+// ScriptAddress returns the pay-to-script-hash address of a redeem script
+func ScriptAddress(redeemScript []byte) string {
+	return string(encodeAddress(scriptHashVersion, ScriptHash(redeemScript)))
+}
+
+// scriptHashData returns the hash inside a pay-to-pubkey-hash or pay-to-script-hash script
+func scriptHashData(script []byte) (ScriptClass, []byte) {
+	switch class := ClassifyScript(script); class {
+	case PubKeyHashScript:
+		return class, script[3:23]
+	case ScriptHashScript:
+		return class, script[2:22]
+	default:
+		return class, nil
+	}
+}
+
+// DisassembleScript renders a script as opcode names and hex data
+func DisassembleScript(script []byte) string {
+	ops, err := parseScript(script)
+	var words []string
+	for _, op := range ops {
+		if op.isPush() && len(op.data) > 0 {
+			words = append(words, hex.EncodeToString(op.data))
+		} else {
+			words = append(words, op.name())
+		}
+	}
+	if err != nil {
+		words = append(words, "[error: "+err.Error()+"]")
+	}
+	return strings.Join(words, " ")
+}
+
+// txChecker answers the questions scripts ask about the transaction spending an output.
+// checkSig gets the script being run when the check happens as subscript, which is what the
+// signer put into the signed copy of the transaction.
+type txChecker interface {
+	checkSig(sig, pubKey, subscript []byte) bool
+	checkLockTime(lockTime int64) bool
+	checkSequence(sequence int64) bool
+}
+
+// engine is the state of one script evaluation
+type engine struct {
+	stack   [][]byte
+	conds   []bool // one entry per open OP_IF, whether its branch is taken
+	ops     int
+	checker txChecker
+}
+
+func asBool(element []byte) bool {
+	for i, b := range element {
+		if b != 0 {
+			// the sign bit alone in the last byte is negative zero
+			return i != len(element)-1 || b != 0x80
+		}
+	}
+	return false
+}
+
+func fromBool(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return nil
+}
+
+func (e *engine) push(element []byte) error {
+	if len(element) > MaxScriptElementSize {
+		return fmt.Errorf("element of %d bytes exceeds the limit of %d", len(element), MaxScriptElementSize)
+	}
+	if len(e.stack) >= MaxStackSize {
+		return fmt.Errorf("stack exceeds %d elements", MaxStackSize)
+	}
+	e.stack = append(e.stack, element)
+	return nil
+}
+
+func (e *engine) pop() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, errors.New("pop from an empty stack")
+	}
+	element := e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+	return element, nil
+}
+
+func (e *engine) executing() bool {
+	for _, cond := range e.conds {
+		if !cond {
+			return false
+		}
+	}
+	return true
+}
+
+// execute runs one script on the current stack
+func (e *engine) execute(script []byte) error {
+	if len(script) > MaxScriptSize {
+		return fmt.Errorf("script of %d bytes exceeds the limit of %d", len(script), MaxScriptSize)
+	}
+	ops, err := parseScript(script)
+	if err != nil {
+		return err
+	}
+
+	e.conds = nil
+	e.ops = 0
+	for _, op := range ops {
+		if _, known := opcodeNames[op.opcode]; !known && !op.isPush() {
+			return fmt.Errorf("unknown opcode %#x", op.opcode)
+		}
+		if len(op.data) > MaxScriptElementSize {
+			return fmt.Errorf("push of %d bytes exceeds the limit of %d", len(op.data), MaxScriptElementSize)
+		}
+		if !op.isPush() {
+			if e.ops++; e.ops > MaxOpsPerScript {
+				return fmt.Errorf("script exceeds %d operations", MaxOpsPerScript)
+			}
+		}
+
+		if !e.executing() && (op.opcode < OpIf || op.opcode > OpEndIf) {
+			continue
+		}
+		if err := e.step(op, script); err != nil {
+			return fmt.Errorf("%s: %w", op.name(), err)
+		}
+	}
+
+	if len(e.conds) != 0 {
+		return errors.New("OP_IF without OP_ENDIF")
+	}
+	return nil
+}
+
+func (e *engine) step(op scriptOp, script []byte) error {
+	switch {
+	case op.opcode >= Op1 && op.opcode <= Op16:
+		return e.push([]byte{op.opcode - Op1 + 1})
+	case op.isPush():
+		return e.push(op.data)
+	}
+
+	switch op.opcode {
+	case OpNop:
+
+	case OpIf, OpNotIf:
+		cond := false
+		if e.executing() {
+			top, err := e.pop()
+			if err != nil {
+				return err
+			}
+			cond = asBool(top) == (op.opcode == OpIf)
+		}
+		e.conds = append(e.conds, cond)
+
+	case OpElse:
+		if len(e.conds) == 0 {
+			return errors.New("no matching OP_IF")
+		}
+		e.conds[len(e.conds)-1] = !e.conds[len(e.conds)-1]
+
+	case OpEndIf:
+		if len(e.conds) == 0 {
+			return errors.New("no matching OP_IF")
+		}
+		e.conds = e.conds[:len(e.conds)-1]
+
+	case OpVerify:
+		top, err := e.pop()
+		if err != nil {
+			return err
+		}
+		if !asBool(top) {
+			return ErrScriptFailed
+		}
+
+	case OpReturn:
+		return errors.New("output is unspendable")
+
+	case OpDrop:
+		_, err := e.pop()
+		return err
+
+	case OpDup:
+		if len(e.stack) == 0 {
+			return errors.New("nothing to duplicate")
+		}
+		return e.push(e.stack[len(e.stack)-1])
+
+	case OpEqual, OpEqualVerify:
+		a, err := e.pop()
+		if err != nil {
+			return err
+		}
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		equal := bytes.Equal(a, b)
+		if op.opcode == OpEqualVerify {
+			if !equal {
+				return ErrScriptFailed
+			}
+			return nil
+		}
+		return e.push(fromBool(equal))
+
+	case OpSha256:
+		top, err := e.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(top)
+		return e.push(hash[:])
+
+	case OpHash160:
+		top, err := e.pop()
+		if err != nil {
+			return err
+		}
+		return e.push(PublicKeyHash(top))
+
+	case OpCheckSig, OpCheckSigVerify:
+		pubKey, err := e.pop()
+		if err != nil {
+			return err
+		}
+		sig, err := e.pop()
+		if err != nil {
+			return err
+		}
+		// Badly encoded signatures and keys fail the script, the empty signature only the check
+		key, err := parsePublicKey(pubKey)
+		if err != nil {
+			return err
+		}
+		if err := checkSignatureEncoding(sig, key); err != nil {
+			return err
+		}
+		valid := len(sig) > 0 && e.checker.checkSig(sig, pubKey, script)
+		if op.opcode == OpCheckSigVerify {
+			if !valid {
+				return ErrScriptFailed
+			}
+			return nil
+		}
+		return e.push(fromBool(valid))
+
+	case OpCheckLockTimeVerify:
+		// The lock time is left on the stack, scripts drop it themselves
+		if len(e.stack) == 0 {
+			return errors.New("no lock time on the stack")
+		}
+		lockTime, err := scriptNum(e.stack[len(e.stack)-1], maxLockTimeNumSize)
+		if err != nil {
+			return err
+		}
+		if !e.checker.checkLockTime(lockTime) {
+			return fmt.Errorf("lock time %d not reached by the transaction", lockTime)
+		}
+
+	case OpCheckSequenceVerify:
+		// Like the lock time, the sequence is left on the stack
+		if len(e.stack) == 0 {
+			return errors.New("no sequence on the stack")
+		}
+		sequence, err := scriptNum(e.stack[len(e.stack)-1], maxLockTimeNumSize)
+		if err != nil {
+			return err
+		}
+		if !e.checker.checkSequence(sequence) {
+			return fmt.Errorf("relative lock time %d not reached by the input", sequence)
+		}
+
+	case OpCheckMultiSig, OpCheckMultiSigVerify:
+		valid, err := e.checkMultiSig(script)
+		if err != nil {
+			return err
+		}
+		if op.opcode == OpCheckMultiSigVerify {
+			if !valid {
+				return ErrScriptFailed
+			}
+			return nil
+		}
+		return e.push(fromBool(valid))
+	}
+	return nil
+}
+
+// checkMultiSig pops <sig>... m <pubkey>... n and checks that the m signatures belong to m of
+// the keys, in the same order as the keys. Unlike Bitcoin no extra dummy element is popped.
+// Keys and signatures that are not canonically encoded fail the script.
+func (e *engine) checkMultiSig(script []byte) (bool, error) {
+	popCount := func(max int64) (int, error) {
+		element, err := e.pop()
+		if err != nil {
+			return 0, err
+		}
+		count, err := scriptNum(element, maxScriptNumSize)
+		if err != nil {
+			return 0, err
+		}
+		if count < 0 || count > max {
+			return 0, fmt.Errorf("count %d is not between 0 and %d", count, max)
+		}
+		return int(count), nil
+	}
+
+	n, err := popCount(MaxPubKeysPerMultiSig)
+	if err != nil {
+		return false, err
+	}
+	if e.ops += n; e.ops > MaxOpsPerScript {
+		return false, fmt.Errorf("script exceeds %d operations", MaxOpsPerScript)
+	}
+	pubKeys := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		if pubKeys[i], err = e.pop(); err != nil {
+			return false, err
+		}
+		if _, err := parsePublicKey(pubKeys[i]); err != nil {
+			return false, err
+		}
+	}
+
+	m, err := popCount(int64(n))
+	if err != nil {
+		return false, err
+	}
+	sigs := make([][]byte, m)
+	for i := m - 1; i >= 0; i-- {
+		if sigs[i], err = e.pop(); err != nil {
+			return false, err
+		}
+		if err := checkSignatureEncoding(sigs[i], nil); err != nil {
+			return false, err
+		}
+	}
+
+	// Each signature is matched against the keys after the one the previous signature used
+	key := 0
+	for _, sig := range sigs {
+		for key < n && (len(sig) == 0 || !e.checker.checkSig(sig, pubKeys[key], script)) {
+			key++
+		}
+		if key == n {
+			return false, nil
+		}
+		key++
+	}
+	return true, nil
+}
+
+// appendNumber appends the shortest push of n, decoded again by scriptNum
+func appendNumber(script []byte, n int64) []byte {
+	switch {
+	case n == 0:
+		return append(script, Op0)
+	case n >= 1 && n <= 16:
+		return append(script, Op1+byte(n-1))
+	}
+
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+	var element []byte
+	for ; n > 0; n >>= 8 {
+		element = append(element, byte(n))
+	}
+	if element[len(element)-1]&0x80 != 0 {
+		element = append(element, 0)
+	}
+	if negative {
+		element[len(element)-1] |= 0x80
+	}
+	return appendPush(script, element)
+}
+
+// scriptNum decodes a number as scripts encode it: little endian with the sign in the top bit of
+// the last byte, in at most maxSize bytes and without extra zero bytes
+func scriptNum(element []byte, maxSize int) (int64, error) {
+	if len(element) > maxSize {
+		return 0, fmt.Errorf("number of %d bytes exceeds %d bytes", len(element), maxSize)
+	}
+	if len(element) == 0 {
+		return 0, nil
+	}
+	last := element[len(element)-1]
+	if last&0x7f == 0 && (len(element) == 1 || element[len(element)-2]&0x80 == 0) {
+		return 0, errors.New("number is not minimally encoded")
+	}
+
+	var v int64
+	for i, b := range element {
+		v |= int64(b) << (8 * uint(i))
+	}
+	if last&0x80 != 0 {
+		return -(v &^ (int64(0x80) << (8 * uint(len(element)-1)))), nil
+	}
+	return v, nil
+}
+
+// verifyScript checks that scriptSig unlocks scriptPubKey
+func verifyScript(scriptSig, scriptPubKey []byte, checker txChecker) error {
+	e := engine{checker: checker}
+
+	if _, err := scriptPushes(scriptSig); err != nil {
+		return err
+	}
+	if err := e.execute(scriptSig); err != nil {
+		return fmt.Errorf("unlocking script: %w", err)
+	}
+	unlocked := append([][]byte{}, e.stack...)
+
+	if err := e.execute(scriptPubKey); err != nil {
+		return fmt.Errorf("locking script: %w", err)
+	}
+	if len(e.stack) == 0 || !asBool(e.stack[len(e.stack)-1]) {
+		return ErrScriptFailed
+	}
+
+	if ClassifyScript(scriptPubKey) == ScriptHashScript {
+		redeemScript := unlocked[len(unlocked)-1]
+		e.stack = unlocked[:len(unlocked)-1]
+		if err := e.execute(redeemScript); err != nil {
+			return fmt.Errorf("redeem script: %w", err)
+		}
+		if len(e.stack) == 0 || !asBool(e.stack[len(e.stack)-1]) {
+			return ErrScriptFailed
+		}
+	}
+
+	// Extra elements would let anyone change the transaction without invalidating it
+	if len(e.stack) != 1 {
+		return fmt.Errorf("%d elements left on the stack", len(e.stack))
+	}
+	return nil
+}
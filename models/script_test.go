@@ -0,0 +1,353 @@
+package models
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strings"
+	"testing"
+)
+
+// testChecker accepts the signatures in valid and lock times and sequences up to its own
+type testChecker struct {
+	valid    map[string]bool
+	lockTime int64
+	sequence int64
+}
+
+func (c testChecker) checkSig(sig, pubKey, subscript []byte) bool {
+	return c.valid[string(sig)]
+}
+
+func (c testChecker) checkLockTime(lockTime int64) bool {
+	return lockTime <= c.lockTime
+}
+
+func (c testChecker) checkSequence(sequence int64) bool {
+	return sequence <= c.sequence
+}
+
+func script(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func ops(opcodes ...byte) []byte {
+	return opcodes
+}
+
+func TestAsBool(t *testing.T) {
+	tests := []struct {
+		element []byte
+		want    bool
+	}{
+		{nil, false},
+		{[]byte{0}, false},
+		{[]byte{0, 0, 0}, false},
+		{[]byte{0x80}, false},
+		{[]byte{0, 0, 0x80}, false},
+		{[]byte{1}, true},
+		{[]byte{0x81}, true},
+		{[]byte{0x80, 0}, true},
+		{[]byte{0, 0x80, 0}, true},
+		{[]byte{0x80, 0x80}, true},
+		{[]byte{0, 1}, true},
+	}
+	for _, test := range tests {
+		if got := asBool(test.element); got != test.want {
+			t.Errorf("asBool(%x) = %v, want %v", test.element, got, test.want)
+		}
+	}
+}
+
+func TestScriptEvaluation(t *testing.T) {
+	preimage := []byte("preimage")
+	hash := sha256.Sum256(preimage)
+	w := MakeWallet(KeyP256)
+	sig := append(w.PrivateKey.Sign(hash[:]), byte(SigHashAll))
+	otherSig := append(MakeWallet(KeyP256).PrivateKey.Sign(hash[:]), byte(SigHashAll))
+	checker := testChecker{map[string]bool{string(sig): true}, 100, 10}
+
+	tests := []struct {
+		name         string
+		scriptSig    []byte
+		scriptPubKey []byte
+		err          string // empty when the script succeeds
+	}{
+		{"true", nil, ops(Op1), ""},
+		{"false", nil, ops(Op0), "evaluated to false"},
+		{"empty", nil, nil, "evaluated to false"},
+		{"negative zero", PushScript([]byte{0x80}), nil, "evaluated to false"},
+		{"long negative zero", PushScript([]byte{0, 0, 0x80}), nil, "evaluated to false"},
+		{"128", PushScript([]byte{0x80, 0}), nil, ""},
+		{"small numbers", ops(Op1 + 4), script(PushScript([]byte{5}), ops(OpEqual)), ""},
+
+		{"if taken", ops(Op1), ops(OpIf, Op1, OpElse, Op0, OpEndIf), ""},
+		{"else taken", ops(Op0), ops(OpIf, Op0, OpElse, Op1, OpEndIf), ""},
+		{"if on negative zero", PushScript([]byte{0x80}), ops(OpIf, Op0, OpElse, Op1, OpEndIf), ""},
+		{"notif", ops(Op0), ops(OpNotIf, Op1, OpEndIf), ""},
+		{"nested", ops(Op0, Op1), ops(OpIf, OpIf, Op0, OpElse, Op1, OpEndIf, OpElse, Op0, OpEndIf), ""},
+		{"untaken branch skips failures", ops(Op0), ops(OpIf, OpReturn, OpEndIf, Op1), ""},
+		{"if without endif", ops(Op1), ops(OpIf, Op1), "OP_IF without OP_ENDIF"},
+		{"endif without if", nil, ops(Op1, OpEndIf), "no matching OP_IF"},
+		{"else without if", nil, ops(Op1, OpElse), "no matching OP_IF"},
+		{"if on an empty stack", nil, ops(OpIf, OpEndIf), "empty stack"},
+
+		{"verify", ops(Op1), ops(OpVerify, Op1), ""},
+		{"verify false", ops(Op0), ops(OpVerify, Op1), "evaluated to false"},
+		{"return", nil, ops(OpReturn, Op1), "unspendable"},
+		{"drop", ops(Op0), ops(OpDrop, Op1), ""},
+		{"drop from empty", nil, ops(OpDrop, Op1), "empty stack"},
+		{"dup equal", ops(Op1 + 2), ops(OpDup, OpEqual), ""},
+		{"dup empty", nil, ops(OpDup), "nothing to duplicate"},
+		{"equal verify", PushScript(preimage), script(PushScript(preimage), ops(OpEqualVerify, Op1)), ""},
+		{"equal verify differs", PushScript(preimage), script(PushScript(hash[:]), ops(OpEqualVerify, Op1)), "evaluated to false"},
+		{"sha256", PushScript(preimage), script(ops(OpSha256), PushScript(hash[:]), ops(OpEqual)), ""},
+		// OP_HASH160 <hash> OP_EQUAL alone would be pay-to-script-hash
+		{"hash160", PushScript(preimage), script(ops(OpHash160), PushScript(PublicKeyHash(preimage)), ops(OpEqualVerify, Op1)), ""},
+
+		{"checksig", PushScript(sig, w.PublicKey), ops(OpCheckSig), ""},
+		{"checksig wrong signature", PushScript(otherSig, w.PublicKey), ops(OpCheckSig), "evaluated to false"},
+		{"checksig empty signature", PushScript(nil, w.PublicKey), ops(OpCheckSig, Op0, OpEqual), ""},
+		{"checksigverify", PushScript(otherSig, w.PublicKey), ops(OpCheckSigVerify, Op1), "evaluated to false"},
+		{"checksig bad key", PushScript(sig, []byte{2, 3}), ops(OpCheckSig), "OP_CHECKSIG"},
+		{"checksig bad hash type", PushScript(append(sig[:len(sig)-1:len(sig)-1], 0x42), w.PublicKey), ops(OpCheckSig), "sighash type"},
+		{"checksig short signature", PushScript(sig[1:], w.PublicKey), ops(OpCheckSig), "signature of"},
+
+		{"multisig", PushScript(sig), script(ops(Op1), PushScript(MakeWallet(KeyP256).PublicKey, w.PublicKey), ops(Op1+1, OpCheckMultiSig)), ""},
+		{"multisig missing", PushScript(otherSig), script(ops(Op1), PushScript(w.PublicKey), ops(Op1, OpCheckMultiSig)), "evaluated to false"},
+		{"multisig count", PushScript(sig), script(ops(Op1+1), PushScript(w.PublicKey), ops(Op1, OpCheckMultiSig)), "count 2"},
+
+		{"lock time reached", nil, script(appendNumber(nil, 100), ops(OpCheckLockTimeVerify)), ""},
+		{"lock time not reached", nil, script(appendNumber(nil, 101), ops(OpCheckLockTimeVerify)), "lock time 101"},
+		{"lock time not minimal", nil, script(PushScript([]byte{100, 0}), ops(OpCheckLockTimeVerify)), "minimally"},
+		{"lock time empty stack", nil, ops(OpCheckLockTimeVerify), "no lock time"},
+		{"sequence reached", nil, script(appendNumber(nil, 10), ops(OpCheckSequenceVerify)), ""},
+		{"sequence not reached", nil, script(appendNumber(nil, 11), ops(OpCheckSequenceVerify)), "relative lock time 11"},
+
+		{"unlocking not push only", ops(Op1, OpDup), ops(OpEqual), "push"},
+		{"elements left", ops(Op1, Op1), ops(Op1), "3 elements left"},
+		{"truncated push", []byte{5, 1, 2}, ops(Op1), "runs past the script"},
+		{"truncated push length", nil, []byte{OpPushData2, 1}, "truncated push length"},
+	}
+	for _, test := range tests {
+		err := verifyScript(test.scriptSig, test.scriptPubKey, checker)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestScriptDisabledOpcodes(t *testing.T) {
+	// Opcodes outside the subset, such as Bitcoin's disabled OP_CAT and OP_MUL, fail the script
+	// even in a branch that is not taken
+	for _, opcode := range []byte{0x7e, 0x95, 0xb0, 0xff} {
+		if err := verifyScript(nil, ops(Op1, opcode), testChecker{}); err == nil || !strings.Contains(err.Error(), "unknown opcode") {
+			t.Errorf("opcode %#x: error %v", opcode, err)
+		}
+		if err := verifyScript(nil, ops(Op0, OpIf, opcode, OpEndIf, Op1), testChecker{}); err == nil {
+			t.Errorf("opcode %#x in an untaken branch passed", opcode)
+		}
+	}
+}
+
+func TestScriptLimits(t *testing.T) {
+	checker := testChecker{}
+	check := func(name string, scriptSig, scriptPubKey []byte, wantErr string) {
+		t.Helper()
+		err := verifyScript(scriptSig, scriptPubKey, checker)
+		if wantErr == "" && err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if wantErr != "" && (err == nil || !strings.Contains(err.Error(), wantErr)) {
+			t.Errorf("%s: error %v, want %q", name, err, wantErr)
+		}
+	}
+
+	element := bytes.Repeat([]byte{1}, MaxScriptElementSize)
+	check("largest element", PushScript(element), ops(OpDrop, Op1), "")
+	check("element too large", PushScript(append(element, 1)), ops(OpDrop, Op1), "exceeds the limit of 520")
+	check("largest element duplicated", nil, script(PushScript(element), ops(OpDup, OpEqual)), "")
+
+	nops := bytes.Repeat([]byte{OpNop}, MaxOpsPerScript)
+	check("most operations", nil, append(nops, Op1), "")
+	check("too many operations", nil, append(append(nops, OpNop), Op1), "exceeds 201 operations")
+	check("pushes are not operations", nil, append(bytes.Repeat([]byte{Op1}, 2*MaxOpsPerScript), nops...), "elements left")
+	// every key of a multisig counts as an operation
+	keys := [][]byte{}
+	for i := 0; i < 16; i++ {
+		keys = append(keys, MakeWallet(KeyP256).PublicKey)
+	}
+	multisig := script(ops(Op0), PushScript(keys...), ops(Op16, OpCheckMultiSig))
+	check("multisig operations", nil, script(bytes.Repeat([]byte{OpNop}, MaxOpsPerScript-17), multisig), "")
+	check("multisig too many operations", nil, script(bytes.Repeat([]byte{OpNop}, MaxOpsPerScript-16), multisig), "exceeds 201 operations")
+
+	fill := bytes.Repeat([]byte{Op1}, MaxStackSize-1)
+	check("full stack", fill, ops(Op1, OpDrop), "elements left")
+	check("stack overflow", fill, ops(Op1, Op1), "stack exceeds 1000 elements")
+	check("stack overflow on dup", fill, ops(Op1, OpDup), "stack exceeds 1000 elements")
+
+	big := append(PushScript(element), bytes.Repeat([]byte{OpDup, OpDrop}, (MaxScriptSize-len(element))/2)...)
+	check("script too large", nil, big, "exceeds the limit of 10000")
+	if !isUnspendable(big) {
+		t.Error("a script above the size limit is not unspendable")
+	}
+}
+
+func TestScriptNum(t *testing.T) {
+	tests := []struct {
+		element []byte
+		want    int64
+		err     bool
+	}{
+		{nil, 0, false},
+		{[]byte{1}, 1, false},
+		{[]byte{0x81}, -1, false},
+		{[]byte{0x7f}, 127, false},
+		{[]byte{0x80, 0}, 128, false},
+		{[]byte{0x80, 0x80}, -128, false},
+		{[]byte{0xff, 0xff, 0xff, 0x7f}, 1<<31 - 1, false},
+		{[]byte{0}, 0, true},
+		{[]byte{0x80}, 0, true},
+		{[]byte{1, 0}, 0, true},
+		{[]byte{1, 0x80}, 0, true},
+		{[]byte{1, 2, 3, 4, 5}, 0, true},
+	}
+	for _, test := range tests {
+		got, err := scriptNum(test.element, maxScriptNumSize)
+		if test.err != (err != nil) || got != test.want {
+			t.Errorf("scriptNum(%x) = %d, %v", test.element, got, err)
+		}
+	}
+
+	for _, n := range []int64{0, 1, 16, 17, -1, -16, 127, 128, -128, 255, 256, 1<<31 - 1, -(1<<31 - 1), 500000000} {
+		elements, err := scriptPushes(appendNumber(nil, n))
+		if err != nil || len(elements) != 1 {
+			t.Fatalf("appendNumber(%d): %v", n, err)
+		}
+		element := elements[0]
+		if got, err := scriptNum(element, maxLockTimeNumSize); err != nil || got != n {
+			t.Errorf("%d encodes as %x and decodes as %d, %v", n, element, got, err)
+		}
+	}
+}
+
+func TestMultiSigRedeemScript(t *testing.T) {
+	a, b, c := MakeWallet(KeyP256), MakeWallet(KeyP256), MakeWallet(KeyP256)
+	keys := [][]byte{a.PublicKey, b.PublicKey, c.PublicKey}
+
+	redeemScript, err := MultiSigRedeemScript(2, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if class := ClassifyScript(redeemScript); class != MultiSigScript {
+		t.Errorf("classified %s", class)
+	}
+	m, pubKeys, ok := multiSigParams(redeemScript)
+	if !ok || m != 2 || len(pubKeys) != 3 {
+		t.Fatalf("read back %d of %d keys, %v", m, len(pubKeys), ok)
+	}
+	for i := 1; i < len(pubKeys); i++ {
+		if bytes.Compare(pubKeys[i-1], pubKeys[i]) >= 0 {
+			t.Error("keys are not sorted")
+		}
+	}
+	// any order of the same keys gives the same script and address
+	reordered, _ := MultiSigRedeemScript(2, [][]byte{c.PublicKey, a.PublicKey, b.PublicKey})
+	if !bytes.Equal(reordered, redeemScript) {
+		t.Error("key order changed the redeem script")
+	}
+	if address := ScriptAddress(redeemScript); !ValidateAddress(address) || address[0] != '3' {
+		t.Errorf("address %s", address)
+	}
+
+	var tooMany [][]byte
+	for i := 0; i <= MaxPubKeysPerMultiSig; i++ {
+		tooMany = append(tooMany, MakeWallet(KeyP256).PublicKey)
+	}
+	for name, test := range map[string]struct {
+		m    int
+		keys [][]byte
+	}{
+		"no keys":       {1, nil},
+		"no threshold":  {0, keys},
+		"m above n":     {4, keys},
+		"duplicate key": {2, [][]byte{a.PublicKey, b.PublicKey, a.PublicKey}},
+		"unknown key":   {1, [][]byte{a.PublicKey, append([]byte{0x05}, b.PublicKey[1:]...)}},
+		"short key":     {1, [][]byte{a.PublicKey, b.PublicKey[:32]}},
+		"too many keys": {1, tooMany},
+	} {
+		if _, err := MultiSigRedeemScript(test.m, test.keys); err == nil {
+			t.Errorf("%s: built", name)
+		}
+	}
+}
+
+func TestMultiSigSpend(t *testing.T) {
+	a, b, c, outsider := MakeWallet(KeyP256), MakeWallet(KeyP256), MakeWallet(KeyP256), MakeWallet(KeyP256)
+	redeemScript, err := MultiSigRedeemScript(2, [][]byte{a.PublicKey, b.PublicKey, c.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevOut := TxOutput{10 * UnitsPerCoin, PayToScriptHashScript(ScriptHash(redeemScript))}
+	unsigned := Transaction{nil, []TxInput{{bytes.Repeat([]byte{0x11}, 32), 0, nil, SequenceFinal}},
+		[]TxOutput{*NewTxOutput(9*UnitsPerCoin, string(outsider.Address()))}, 0, TxVersion}
+	unsigned.SetID()
+
+	tests := []struct {
+		name    string
+		signers []*Wallet
+		want    bool
+	}{
+		{"unsigned", nil, false},
+		{"one of three", []*Wallet{a}, false},
+		{"a and b", []*Wallet{a, b}, true},
+		{"c and a, out of key order", []*Wallet{c, a}, true},
+		{"b and c", []*Wallet{b, c}, true},
+		{"all three", []*Wallet{a, b, c}, true},
+		{"the same key twice", []*Wallet{b, b}, false},
+	}
+	for _, test := range tests {
+		tx := unsigned
+		tx.Inputs = []TxInput{unsigned.Inputs[0]}
+		for _, w := range test.signers {
+			tx.SignMultiSig(w.PrivateKey, redeemScript, []TxOutput{prevOut}, SigHashAll)
+		}
+		if got := tx.Verify([]TxOutput{prevOut}); got != test.want {
+			t.Errorf("%s: verifies %v, want %v", test.name, got, test.want)
+		}
+		if !bytes.Equal(tx.Hash(), unsigned.ID) {
+			t.Errorf("%s: signing changed the ID", test.name)
+		}
+		if elements, _ := scriptPushes(tx.Inputs[0].ScriptSig); test.want && len(elements) != 3 {
+			t.Errorf("%s: unlocking script has %d elements, want two signatures and the redeem script", test.name, len(elements))
+		}
+	}
+
+	// a key outside the script can not sign, and its signature does not count when added
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("outsider signed")
+			}
+		}()
+		tx := unsigned
+		tx.SignMultiSig(outsider.PrivateKey, redeemScript, []TxOutput{prevOut}, SigHashAll)
+	}()
+	tx := unsigned
+	tx.Inputs = []TxInput{unsigned.Inputs[0]}
+	tx.SignMultiSig(a.PrivateKey, redeemScript, []TxOutput{prevOut}, SigHashAll)
+	elements, _ := scriptPushes(tx.Inputs[0].ScriptSig)
+	outsiderSig := tx.signInput(outsider.PrivateKey, 0, redeemScript, prevOut, SigHashAll)
+	tx.Inputs[0].ScriptSig = PushScript(elements[0], outsiderSig, redeemScript)
+	if tx.Verify([]TxOutput{prevOut}) {
+		t.Error("outsider signature counted")
+	}
+	// nor does another redeem script hashing to a different address
+	other, _ := MultiSigRedeemScript(1, [][]byte{a.PublicKey})
+	tx.Inputs[0].ScriptSig = PushScript(elements[0], other)
+	if tx.Verify([]TxOutput{prevOut}) {
+		t.Error("another redeem script unlocked the output")
+	}
+}
@@ -0,0 +1,141 @@
+package models
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/bucks-go-wallet/utils"
+	"strings"
+)
+
+// SigHashType says which parts of the transaction a signature covers. It is the last byte of
+// every signature and is part of what is signed.
+//
+//	SigHashAll     every input and output
+//	SigHashNone    every input but no output, anyone may decide where the coins go
+//	SigHashSingle  every input and only the output at the signed input's position
+//
+// With SigHashAnyoneCanPay added only the signed input is covered, so others can add inputs,
+// as when many people fund one payment. With None and Single the sequences of the other inputs
+// are not covered either.
+type SigHashType byte
+
+const (
+	SigHashAll          SigHashType = 0x01
+	SigHashNone         SigHashType = 0x02
+	SigHashSingle       SigHashType = 0x03
+	SigHashAnyoneCanPay SigHashType = 0x80
+
+	sigHashMask = 0x1f
+)
+
+// ParseSigHashType reads a hash type written as ALL, NONE or SINGLE, optionally followed by
+// |ANYONECANPAY
+func ParseSigHashType(s string) (SigHashType, error) {
+	parts := strings.Split(strings.ToUpper(strings.TrimSpace(s)), "|")
+	var hashType SigHashType
+	switch parts[0] {
+	case "ALL":
+		hashType = SigHashAll
+	case "NONE":
+		hashType = SigHashNone
+	case "SINGLE":
+		hashType = SigHashSingle
+	default:
+		return 0, fmt.Errorf("unknown sighash type %q, use ALL, NONE or SINGLE", s)
+	}
+	if len(parts) == 2 && parts[1] == "ANYONECANPAY" {
+		return hashType | SigHashAnyoneCanPay, nil
+	}
+	if len(parts) != 1 {
+		return 0, fmt.Errorf("unknown sighash type %q, only |ANYONECANPAY may follow the type", s)
+	}
+	return hashType, nil
+}
+
+func (hashType SigHashType) valid() bool {
+	base := hashType & sigHashMask
+	return hashType&^(SigHashAnyoneCanPay|sigHashMask) == 0 && base >= SigHashAll && base <= SigHashSingle
+}
+
+func (hashType SigHashType) String() string {
+	var name string
+	switch hashType & sigHashMask {
+	case SigHashAll:
+		name = "ALL"
+	case SigHashNone:
+		name = "NONE"
+	case SigHashSingle:
+		name = "SINGLE"
+	default:
+		return fmt.Sprintf("%#02x", byte(hashType))
+	}
+	if hashType&SigHashAnyoneCanPay != 0 {
+		name += "|ANYONECANPAY"
+	}
+	return name
+}
+
+// signatureHash is what the signatures of input inId sign: the parts of the transaction the hash
+// type covers, without unlocking scripts, with subscript, the script whose conditions are being
+// met, in place of the input's, followed by prevOut, the output the input spends, as in BIP143,
+// and the hash type. SigHashSingle needs an output at the input's position.
+func (tx *Transaction) signatureHash(inId int, subscript []byte, prevOut TxOutput, hashType SigHashType) ([]byte, error) {
+	if !hashType.valid() {
+		return nil, fmt.Errorf("invalid sighash type %#02x", byte(hashType))
+	}
+
+	txCopy := tx.TrimmedCopy()
+	txCopy.ID = nil
+	txCopy.Inputs[inId].ScriptSig = subscript
+
+	switch hashType & sigHashMask {
+	case SigHashNone:
+		txCopy.Outputs = nil
+	case SigHashSingle:
+		if inId >= len(txCopy.Outputs) {
+			return nil, fmt.Errorf("SIGHASH_SINGLE input %d has no output at its position", inId)
+		}
+		txCopy.Outputs = txCopy.Outputs[:inId+1]
+		for i := 0; i < inId; i++ {
+			txCopy.Outputs[i] = TxOutput{^Amount(0), nil}
+		}
+	}
+	if hashType&sigHashMask != SigHashAll {
+		for i := range txCopy.Inputs {
+			if i != inId {
+				txCopy.Inputs[i].Sequence = 0
+			}
+		}
+	}
+	if hashType&SigHashAnyoneCanPay != 0 {
+		txCopy.Inputs = txCopy.Inputs[inId : inId+1]
+	}
+
+	var e encoder
+	e.buf.Write(txCopy.Serialize())
+	e.putOutput(prevOut)
+	e.buf.WriteByte(byte(hashType))
+	hash := sha256.Sum256(e.buf.Bytes())
+	return hash[:], nil
+}
+
+// signInput signs input inId, spending prevOut, for subscript and returns the signature with the
+// hash type appended
+func (tx *Transaction) signInput(privKey Signer, inId int, subscript []byte, prevOut TxOutput, hashType SigHashType) []byte {
+	hash, err := tx.signatureHash(inId, subscript, prevOut, hashType)
+	utils.Handle(err)
+	return append(privKey.Sign(hash), byte(hashType))
+}
+
+// checkSignature reports whether sig, ending with its hash type, is a signature of pubKey over
+// input inId, spending prevOut, for subscript
+func (tx *Transaction) checkSignature(inId int, sig, pubKey, subscript []byte, prevOut TxOutput) bool {
+	if len(sig) < 2 {
+		return false
+	}
+	hash, err := tx.signatureHash(inId, subscript, prevOut, SigHashType(sig[len(sig)-1]))
+	if err != nil {
+		return false
+	}
+	return verifySignature(pubKey, sig[:len(sig)-1], hash)
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// sighashTx spends two outputs into two outputs, the signed input is the second
+func sighashTx() (*Transaction, TxOutput) {
+	tx := &Transaction{nil, []TxInput{
+		{bytes.Repeat([]byte{0x11}, 32), 0, nil, 1},
+		{bytes.Repeat([]byte{0x22}, 32), 1, nil, SequenceFinal},
+	}, []TxOutput{
+		{5 * UnitsPerCoin, PayToPubKeyHashScript(bytes.Repeat([]byte{0x33}, 20))},
+		{3 * UnitsPerCoin, PayToPubKeyHashScript(bytes.Repeat([]byte{0x44}, 20))},
+	}, 0, TxVersion}
+	prevOut := TxOutput{9 * UnitsPerCoin, PayToPubKeyHashScript(bytes.Repeat([]byte{0x55}, 20))}
+	return tx, prevOut
+}
+
+func TestSignatureHashVectors(t *testing.T) {
+	vectors := []struct {
+		hashType SigHashType
+		hash     string
+	}{
+		{SigHashAll, "45f76cc92eb626f190679b24f6db7b24eae75ffa5ebcec0e0f82467374ceb9be"},
+		{SigHashNone, "e7c993e280a2d075efa9c61b7ceab85c1c84ef005b3bce2818dc51f23f25305d"},
+		{SigHashSingle, "2fe3de34a2a4428978471dd0442eb00557df30b3f16da15bce358e38a5251dfd"},
+		{SigHashAll | SigHashAnyoneCanPay, "b8c0731a53ce8f0103db755c1177898e1a5baf68387ed771dbff5691e5be38e5"},
+		{SigHashNone | SigHashAnyoneCanPay, "fd3aa12c0aa9dca93594ee6d5dc67cfde428f337860bb600ba0242b6912aa358"},
+		{SigHashSingle | SigHashAnyoneCanPay, "057e7ce5746cac25cc21d8f8196ba664f6e6e35e8f11a44d25ae24dc3c468ef3"},
+	}
+
+	tx, prevOut := sighashTx()
+	for _, v := range vectors {
+		hash, err := tx.signatureHash(1, prevOut.Script, prevOut, v.hashType)
+		if err != nil {
+			t.Fatalf("%s: %v", v.hashType, err)
+		}
+		if got := hex.EncodeToString(hash); got != v.hash {
+			t.Errorf("%s: got %s, want %s", v.hashType, got, v.hash)
+		}
+	}
+}
+
+func TestSignatureHashCoversPrevOut(t *testing.T) {
+	tx, prevOut := sighashTx()
+	w := MakeWallet(KeyP256)
+	prevOut.Script = PayToPubKeyHashScript(PublicKeyHash(w.PublicKey))
+	prevOuts := []TxOutput{{1, PayToPubKeyHashScript(bytes.Repeat([]byte{0x66}, 20))}, prevOut}
+
+	for _, hashType := range []SigHashType{SigHashAll, SigHashNone | SigHashAnyoneCanPay} {
+		signed := *tx
+		signed.Inputs = append([]TxInput(nil), tx.Inputs...)
+		signed.Sign(w.PrivateKey, prevOuts, hashType)
+		if err := signed.VerifyInput(1, prevOut); err != nil {
+			t.Fatalf("%s: %v", hashType, err)
+		}
+
+		otherValue := TxOutput{prevOut.Value + 1, prevOut.Script}
+		if signed.VerifyInput(1, otherValue) == nil {
+			t.Errorf("%s: signature verifies for a spent output of another value", hashType)
+		}
+		hash, _ := signed.signatureHash(1, prevOut.Script, prevOut, hashType)
+		otherScript := TxOutput{prevOut.Value, PayToScriptHashScript(bytes.Repeat([]byte{0x77}, 20))}
+		otherHash, _ := signed.signatureHash(1, prevOut.Script, otherScript, hashType)
+		if bytes.Equal(hash, otherHash) {
+			t.Errorf("%s: hash does not cover the spent output's script", hashType)
+		}
+	}
+}
+
+func TestSignatureHashErrors(t *testing.T) {
+	tx, prevOut := sighashTx()
+	tx.Outputs = tx.Outputs[:1]
+	if _, err := tx.signatureHash(1, prevOut.Script, prevOut, SigHashSingle); err == nil {
+		t.Error("SIGHASH_SINGLE without an output at the input's position hashed")
+	}
+	for _, hashType := range []SigHashType{0, 0x04, SigHashAnyoneCanPay, SigHashAll | 0x40} {
+		if _, err := tx.signatureHash(0, prevOut.Script, prevOut, hashType); err == nil {
+			t.Errorf("invalid hash type %#02x hashed", byte(hashType))
+		}
+	}
+}
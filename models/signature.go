@@ -0,0 +1,102 @@
+package models
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"github.com/bucks-go-wallet/utils"
+	"math/big"
+)
+
+// P-256 signatures are r and s, each padded to 32 bytes, Ed25519 signatures are 64 bytes too. In
+// scripts the hash type byte follows. Public keys of both types have 33 bytes, see KeyType. Each
+// signature and key has exactly one valid encoding, so a transaction cannot be changed without
+// its signers, which would change its hash.
+const (
+	scalarSize    = 32
+	signatureSize = 2 * scalarSize
+	pubKeySize    = 1 + scalarSize
+)
+
+// halfOrder is the largest s a signature may have. For every valid s, N-s is valid too, so only
+// the lower one is accepted.
+var halfOrder = new(big.Int).Rsh(elliptic.P256().Params().N, 1)
+
+// parseSignature decodes r and s of a P-256 signature without its hash type, rejecting anything but
+// the canonical encoding with a low s
+func parseSignature(signature []byte) (*big.Int, *big.Int, error) {
+	if len(signature) != signatureSize {
+		return nil, nil, fmt.Errorf("signature of %d bytes, it must have %d", len(signature), signatureSize)
+	}
+	r := new(big.Int).SetBytes(signature[:scalarSize])
+	s := new(big.Int).SetBytes(signature[scalarSize:])
+	n := elliptic.P256().Params().N
+	if r.Sign() == 0 || r.Cmp(n) >= 0 || s.Sign() == 0 || s.Cmp(n) >= 0 {
+		return nil, nil, errors.New("signature r or s is out of range")
+	}
+	if s.Cmp(halfOrder) > 0 {
+		return nil, nil, errors.New("signature s is not low")
+	}
+	return r, s, nil
+}
+
+// checkSignatureEncoding reports why a signature with its hash type, as found in a script, is not
+// canonical. The rules of the key type are checked when pubKey is given, OP_CHECKMULTISIG does
+// not know which key a signature is for and leaves them to verification. The empty signature is
+// allowed, it fails verification without failing the script.
+func checkSignatureEncoding(sig []byte, pubKey PublicKey) error {
+	if len(sig) == 0 {
+		return nil
+	}
+	if hashType := SigHashType(sig[len(sig)-1]); !hashType.valid() {
+		return fmt.Errorf("invalid sighash type %#02x", byte(hashType))
+	}
+	if len(sig)-1 != signatureSize {
+		return fmt.Errorf("signature of %d bytes, it must have %d", len(sig)-1, signatureSize)
+	}
+	if pubKey == nil {
+		return nil
+	}
+	return pubKey.checkSignature(sig[:len(sig)-1])
+}
+
+// signHash signs hash with the nonce RFC 6979 derives from the key and the hash, so the same key
+// and hash always give the same signature, with s made low
+func signHash(privKey ecdsa.PrivateKey, hash []byte) []byte {
+	r, s, err := signRFC6979(&privKey, hash)
+	utils.Handle(err)
+	if s.Cmp(halfOrder) > 0 {
+		s.Sub(elliptic.P256().Params().N, s)
+	}
+
+	signature := make([]byte, signatureSize)
+	r.FillBytes(signature[:scalarSize])
+	s.FillBytes(signature[scalarSize:])
+	return signature
+}
+
+// signRFC6979 is ECDSA over P-256 with the nonce k of RFC 6979 section 3.2, which the standard
+// library uses in constant time when it is given no random source. It returns r and s as the
+// RFC's test vectors have them, s is not made low.
+func signRFC6979(privKey *ecdsa.PrivateKey, hash []byte) (*big.Int, *big.Int, error) {
+	der, err := privKey.Sign(nil, hash, crypto.SHA256)
+	if err != nil {
+		return nil, nil, err
+	}
+	var sig struct{ R, S *big.Int }
+	if rest, err := asn1.Unmarshal(der, &sig); err != nil || len(rest) > 0 {
+		return nil, nil, fmt.Errorf("signature %x is not ASN.1 r and s", der)
+	}
+	return sig.R, sig.S, nil
+}
+
+func verifySignature(pubKey, signature, hash []byte) bool {
+	key, err := parsePublicKey(pubKey)
+	if err != nil {
+		return false
+	}
+	return key.Verify(hash, signature)
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+// TestRFC6979Vectors checks signing against the P-256 SHA-256 vectors of RFC 6979 appendix A.2.5
+func TestRFC6979Vectors(t *testing.T) {
+	private, _ := hex.DecodeString("c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721")
+	signer, err := signerFromBytes(KeyP256, private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := signer.(p256Signer)
+	if x, y := fmt.Sprintf("%064x", key.X), fmt.Sprintf("%064x", key.Y); x != "60fed4ba255a9d31c961eb74c6356d68c049b8923b61fa6ce669622e60f29fb6" ||
+		y != "7903fe1008b8bc99a41ae9e95628bc64f2f1b20c2d7e9f5177a3c294d4462299" {
+		t.Fatalf("public key %s %s", x, y)
+	}
+
+	vectors := []struct {
+		message, r, s string
+	}{
+		{"sample", "efd48b2aacb6a8fd1140dd9cd45e81d69d2c877b56aaf991c34d0ea84eaf3716", "f7cb1c942d657c41d436c7a1b6e29f65f3e900dbb9aff4064dc4ab2f843acda8"},
+		{"test", "f1abb023518351cd71d881567b1ea663ed3efcf6c5132b354f28d3b0b7d38367", "019f4113742a2b14bd25926b49c649155f267e60d3814b4c0cc84250e46f0083"},
+	}
+	for _, v := range vectors {
+		hash := sha256.Sum256([]byte(v.message))
+		r, s, err := signRFC6979(&key.PrivateKey, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprintf("%064x", r); got != v.r {
+			t.Errorf("%q: r %s, want %s", v.message, got, v.r)
+		}
+		if got := fmt.Sprintf("%064x", s); got != v.s {
+			t.Errorf("%q: s %s, want %s", v.message, got, v.s)
+		}
+
+		// the signature in scripts has the low s, which for "sample" is N-s
+		low := s
+		if s.Cmp(halfOrder) > 0 {
+			low = new(big.Int).Sub(elliptic.P256().Params().N, s)
+		}
+		signature := key.Sign(hash[:])
+		if want := v.r + fmt.Sprintf("%064x", low); hex.EncodeToString(signature) != want {
+			t.Errorf("%q: signature %x, want %s", v.message, signature, want)
+		}
+		if _, _, err := parseSignature(signature); err != nil {
+			t.Errorf("%q: %v", v.message, err)
+		}
+		if !key.Public().Verify(hash[:], signature) {
+			t.Errorf("%q: signature %x does not verify", v.message, signature)
+		}
+	}
+}
@@ -0,0 +1,654 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/bucks-go-wallet/utils"
+	"github.com/dgraph-io/badger"
+	"hash"
+	"io"
+	"log"
+	"os"
+)
+
+// Snapshot file layout, all integers big endian:
+//
+//	magic "UTXOSNAP" | version (1 byte) | base hash (u32 length + bytes) | base height (u64) |
+//	entry count (u64) | deployment count (u32)
+//	deployments: name (u32 length + bytes) | state (u32) | since (u64) | signals (u32)
+//	entries: tx id (u32 length + bytes) | height (u64) | time (i64) | output count (u32)
+//	outputs: output index (u32) | value (u64) | locking script (u32 length + bytes)
+//	sha256 of everything above (32 bytes)
+//
+// Entries are written in key order and outputs are encoded field by field, so the same UTXO set
+// always produces the same file.
+const (
+	snapshotVersion  = byte(1) // changes with the layout above
+	maxSnapshotField = 1 << 24
+)
+
+var (
+	snapshotMagic       = []byte("UTXOSNAP")
+	snapshotKey         = []byte("snapshot")
+	snapshotLoadingKey  = []byte("snapshot-loading")
+	snapshotCheckPrefix = []byte("snapcheck-") // UTXO set ValidateSnapshot replays the history into
+
+	ErrNoSnapshot      = errors.New("chain was not loaded from a snapshot")
+	ErrSnapshotHistory = errors.New("blocks below the snapshot base are not available")
+	ErrSnapshotLoad    = errors.New("a snapshot load was interrupted and left the UTXO set incomplete, run loadtxoutset again")
+)
+
+// SnapshotInfo describes a UTXO set snapshot
+type SnapshotInfo struct {
+	BaseHash    []byte // block the snapshot was taken at
+	BaseHeight  int
+	Count       uint64 // number of transactions with unspent outputs
+	ContentHash []byte // sha256 over the serialized snapshot
+	Validated   bool   // set once the history has been replayed and matched ContentHash
+	Deployments []SnapshotDeployment
+}
+
+// SnapshotDeployment carries what DeploymentStatus needs from the blocks below a snapshot base,
+// which a node started from the snapshot does not have: the status of a deployment for the window
+// holding the base, and how many blocks of that window up to the base signalled it
+type SnapshotDeployment struct {
+	Name string
+	DeploymentStatus
+	Signals int
+}
+
+type snapshotWriter struct {
+	buf    *bufio.Writer
+	hasher hash.Hash
+	out    io.Writer
+}
+
+func newSnapshotWriter(w io.Writer, info *SnapshotInfo) (*snapshotWriter, error) {
+	sw := &snapshotWriter{buf: bufio.NewWriter(w), hasher: sha256.New()}
+	sw.out = io.MultiWriter(sw.buf, sw.hasher)
+
+	if _, err := sw.out.Write(snapshotMagic); err != nil {
+		return nil, err
+	}
+	if _, err := sw.out.Write([]byte{snapshotVersion}); err != nil {
+		return nil, err
+	}
+	if err := sw.writeField(info.BaseHash); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(sw.out, binary.BigEndian, uint64(info.BaseHeight)); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(sw.out, binary.BigEndian, info.Count); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(sw.out, binary.BigEndian, uint32(len(info.Deployments))); err != nil {
+		return nil, err
+	}
+	for _, d := range info.Deployments {
+		if err := sw.writeField([]byte(d.Name)); err != nil {
+			return nil, err
+		}
+		fields := []interface{}{uint32(d.State), uint64(d.Since), uint32(d.Signals)}
+		for _, field := range fields {
+			if err := binary.Write(sw.out, binary.BigEndian, field); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return sw, nil
+}
+
+func (sw *snapshotWriter) writeField(data []byte) error {
+	if err := binary.Write(sw.out, binary.BigEndian, uint32(len(data))); err != nil {
+		return err
+	}
+	_, err := sw.out.Write(data)
+	return err
+}
+
+func (sw *snapshotWriter) writeEntry(txID []byte, outs TxOutputs) error {
+	if err := sw.writeField(txID); err != nil {
+		return err
+	}
+	if err := binary.Write(sw.out, binary.BigEndian, uint64(outs.Height)); err != nil {
+		return err
+	}
+	if err := binary.Write(sw.out, binary.BigEndian, outs.Time); err != nil {
+		return err
+	}
+	if err := binary.Write(sw.out, binary.BigEndian, uint32(len(outs.Outputs))); err != nil {
+		return err
+	}
+	for i, out := range outs.Outputs {
+		if err := binary.Write(sw.out, binary.BigEndian, uint32(outs.Indexes[i])); err != nil {
+			return err
+		}
+		if err := binary.Write(sw.out, binary.BigEndian, uint64(out.Value)); err != nil {
+			return err
+		}
+		if err := sw.writeField(out.Script); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finish appends the content hash and flushes the underlying writer
+func (sw *snapshotWriter) finish() ([]byte, error) {
+	sum := sw.hasher.Sum(nil)
+	if _, err := sw.buf.Write(sum); err != nil {
+		return nil, err
+	}
+	return sum, sw.buf.Flush()
+}
+
+type snapshotReader struct {
+	hasher hash.Hash
+	buf    *bufio.Reader
+	in     io.Reader
+	info   SnapshotInfo
+}
+
+func newSnapshotReader(r io.Reader) (*snapshotReader, error) {
+	sr := &snapshotReader{buf: bufio.NewReader(r), hasher: sha256.New()}
+	sr.in = io.TeeReader(sr.buf, sr.hasher)
+
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(sr.in, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(snapshotMagic)], snapshotMagic) {
+		return nil, errors.New("not a UTXO snapshot file")
+	}
+	if header[len(snapshotMagic)] != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", header[len(snapshotMagic)])
+	}
+
+	base, err := sr.readField()
+	if err != nil {
+		return nil, err
+	}
+	sr.info.BaseHash = base
+	var height uint64
+	if err := binary.Read(sr.in, binary.BigEndian, &height); err != nil {
+		return nil, err
+	}
+	sr.info.BaseHeight = int(height)
+	if err := binary.Read(sr.in, binary.BigEndian, &sr.info.Count); err != nil {
+		return nil, err
+	}
+
+	var deployments uint32
+	if err := binary.Read(sr.in, binary.BigEndian, &deployments); err != nil {
+		return nil, err
+	}
+	if deployments > maxSnapshotField {
+		return nil, fmt.Errorf("snapshot with %d deployments exceeds limit", deployments)
+	}
+	for i := uint32(0); i < deployments; i++ {
+		name, err := sr.readField()
+		if err != nil {
+			return nil, err
+		}
+		var fields struct {
+			State   uint32
+			Since   uint64
+			Signals uint32
+		}
+		if err := binary.Read(sr.in, binary.BigEndian, &fields); err != nil {
+			return nil, err
+		}
+		status := DeploymentStatus{DeploymentState(fields.State), int(fields.Since)}
+		sr.info.Deployments = append(sr.info.Deployments, SnapshotDeployment{string(name), status, int(fields.Signals)})
+	}
+	return sr, nil
+}
+
+func (sr *snapshotReader) readField() ([]byte, error) {
+	var length uint32
+	if err := binary.Read(sr.in, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > maxSnapshotField {
+		return nil, fmt.Errorf("snapshot field of %d bytes exceeds limit", length)
+	}
+	data := make([]byte, length)
+	_, err := io.ReadFull(sr.in, data)
+	return data, err
+}
+
+func (sr *snapshotReader) readEntry() ([]byte, TxOutputs, error) {
+	var outs TxOutputs
+	txID, err := sr.readField()
+	if err != nil {
+		return nil, outs, err
+	}
+
+	var height uint64
+	if err := binary.Read(sr.in, binary.BigEndian, &height); err != nil {
+		return nil, outs, err
+	}
+	outs.Height = int(height)
+	if err := binary.Read(sr.in, binary.BigEndian, &outs.Time); err != nil {
+		return nil, outs, err
+	}
+
+	var count uint32
+	if err := binary.Read(sr.in, binary.BigEndian, &count); err != nil {
+		return nil, outs, err
+	}
+	if count > maxSnapshotField {
+		return nil, outs, fmt.Errorf("snapshot entry with %d outputs exceeds limit", count)
+	}
+	for i := uint32(0); i < count; i++ {
+		var index uint32
+		if err := binary.Read(sr.in, binary.BigEndian, &index); err != nil {
+			return nil, outs, err
+		}
+		var value uint64
+		if err := binary.Read(sr.in, binary.BigEndian, &value); err != nil {
+			return nil, outs, err
+		}
+		script, err := sr.readField()
+		if err != nil {
+			return nil, outs, err
+		}
+		outs.add(int(index), TxOutput{Amount(value), script})
+	}
+	return txID, outs, nil
+}
+
+// verify reads the trailing content hash, checks it against what was read so far and that the
+// file ends there
+func (sr *snapshotReader) verify() error {
+	sum := sr.hasher.Sum(nil)
+	stored := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(sr.buf, stored); err != nil {
+		return err
+	}
+	if !bytes.Equal(sum, stored) {
+		return fmt.Errorf("snapshot content hash mismatch: file says %x, contents hash to %x", stored, sum)
+	}
+	if _, err := sr.buf.ReadByte(); err != io.EOF {
+		return errors.New("trailing data after the snapshot content hash")
+	}
+	sr.info.ContentHash = sum
+	return nil
+}
+
+// DumpSnapshot streams the UTXO set into the file at path. The snapshot is taken at the block
+// the UTXO set in badger was written for, read in the same transaction as the entries, after
+// flushing the UTXO cache so that is the tip.
+func (set UTXOSet) DumpSnapshot(path string) SnapshotInfo {
+	utils.Handle(set.BlockChain.FlushUTXOCache())
+
+	file, err := os.Create(path)
+	utils.Handle(err)
+	defer file.Close()
+
+	var info SnapshotInfo
+	err = set.BlockChain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(utxoTipKey)
+		if err == badger.ErrKeyNotFound {
+			return errors.New("the UTXO set is being rebuilt, run reindexutxo first")
+		}
+		if err != nil {
+			return err
+		}
+		if info.BaseHash, err = item.ValueCopy(nil); err != nil {
+			return err
+		}
+		if info.BaseHeight, err = set.BlockChain.blockHeight(info.BaseHash); err != nil {
+			return err
+		}
+		if info.Deployments, err = set.BlockChain.snapshotDeployments(info.BaseHash, info.BaseHeight); err != nil {
+			return err
+		}
+		return writeSnapshot(txn, file, utxoPrefix, &info)
+	})
+	utils.Handle(err)
+
+	return info
+}
+
+// writeSnapshot streams the entries stored under prefix, in key order, into a snapshot at the base
+// and with the deployments of info and sets its count and content hash. Both passes over the
+// entries see the view of txn.
+func writeSnapshot(txn *badger.Txn, w io.Writer, prefix []byte, info *SnapshotInfo) error {
+	// Count first so the header can carry it
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		info.Count++
+	}
+	it.Close()
+
+	sw, err := newSnapshotWriter(w, info)
+	if err != nil {
+		return err
+	}
+
+	it = txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		outs, err := decodeOutputs(v)
+		if err != nil {
+			return err
+		}
+		if err := sw.writeEntry(bytes.TrimPrefix(item.KeyCopy(nil), prefix), outs); err != nil {
+			return err
+		}
+	}
+
+	info.ContentHash, err = sw.finish()
+	return err
+}
+
+// ReadSnapshotInfo reads a whole snapshot file and checks its content hash without loading it
+func ReadSnapshotInfo(path string) (SnapshotInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	defer file.Close()
+
+	sr, err := newSnapshotReader(file)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	for i := uint64(0); i < sr.info.Count; i++ {
+		if _, _, err := sr.readEntry(); err != nil {
+			return SnapshotInfo{}, err
+		}
+	}
+	if err := sr.verify(); err != nil {
+		return SnapshotInfo{}, err
+	}
+	return sr.info, nil
+}
+
+// LoadSnapshot replaces the UTXO set with the contents of the snapshot file. The file is
+// verified before anything is written. A node without a chain starts at the snapshot base,
+// an existing chain must have the base as its tip.
+//
+// The set is too large for one badger transaction, so a marker is written first and removed
+// together with setting the tip. A chain still carrying the marker does not open, see
+// checkSnapshotLoad.
+func LoadSnapshot(path string) (*BlockChain, SnapshotInfo) {
+	info, err := ReadSnapshotInfo(path)
+	utils.Handle(err)
+
+	chain := openBlockChain()
+	if chain.LastHash != nil && !bytes.Equal(chain.LastHash, info.BaseHash) {
+		utils.Handle(chain.Database.Close())
+		log.Panicf("Snapshot base %x is not the chain tip %x", info.BaseHash, chain.LastHash)
+	}
+	chain.writeLock.Lock()
+	defer chain.writeLock.Unlock()
+
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(snapshotLoadingKey, info.BaseHash); err != nil {
+			return err
+		}
+		return txn.Delete(utxoTipKey)
+	})
+	utils.Handle(err)
+
+	set := UTXOSet{chain}
+	set.DeleteByPrefix(utxoPrefix)
+
+	file, err := os.Open(path)
+	utils.Handle(err)
+	defer file.Close()
+
+	sr, err := newSnapshotReader(file)
+	utils.Handle(err)
+
+	wb := chain.Database.NewWriteBatch()
+	defer wb.Cancel()
+	for i := uint64(0); i < info.Count; i++ {
+		txID, outputs, err := sr.readEntry()
+		utils.Handle(err)
+		utils.Handle(wb.Set(append(utxoPrefix, txID...), outputs.Serialize()))
+	}
+	utils.Handle(wb.Flush())
+
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		if err := txn.Set([]byte("lh"), info.BaseHash); err != nil {
+			return err
+		}
+		if err := txn.Set(snapshotKey, info.serialize()); err != nil {
+			return err
+		}
+		if err := txn.Set(utxoTipKey, info.BaseHash); err != nil {
+			return err
+		}
+		return txn.Delete(snapshotLoadingKey)
+	})
+	utils.Handle(err)
+	chain.setTip(info.BaseHash)
+
+	return chain, info
+}
+
+// checkSnapshotLoad fails with ErrSnapshotLoad when a LoadSnapshot did not finish
+func (bc *BlockChain) checkSnapshotLoad() error {
+	return bc.Database.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(snapshotLoadingKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err == nil {
+			return ErrSnapshotLoad
+		}
+		return err
+	})
+}
+
+// Snapshot returns the metadata of the snapshot this chain was loaded from
+func (bc *BlockChain) Snapshot() (SnapshotInfo, error) {
+	var info SnapshotInfo
+
+	err := bc.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(snapshotKey)
+		if err == badger.ErrKeyNotFound {
+			return ErrNoSnapshot
+		}
+		if err != nil {
+			return err
+		}
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		info, err = deserializeSnapshotInfo(v)
+		return err
+	})
+
+	return info, err
+}
+
+// ValidateSnapshot replays the history up to the snapshot base and checks that the resulting
+// UTXO set hashes to the snapshot content hash. Like a reindex the replay writes the set in
+// bounded batches, under snapshotCheckPrefix, and the set is hashed by streaming it back, so the
+// memory it takes does not grow with the set. Blocks can be connected meanwhile.
+func (bc *BlockChain) ValidateSnapshot() error {
+	info, err := bc.Snapshot()
+	if err != nil {
+		return err
+	}
+	if !bc.HasBlock(info.BaseHash) {
+		return ErrSnapshotHistory
+	}
+	base, err := bc.GetBlock(info.BaseHash)
+	if err != nil {
+		return err
+	}
+	hashes, err := bc.blockHashesTo(info.BaseHash)
+	if err != nil {
+		return err
+	}
+
+	set := UTXOSet{bc}
+	set.DeleteByPrefix(snapshotCheckPrefix)
+	defer set.DeleteByPrefix(snapshotCheckPrefix)
+
+	pending := make(map[string]TxOutputs)
+	get := pendingEntries(bc.Database, snapshotCheckPrefix, pending)
+	put := func(txID []byte, outs TxOutputs) error {
+		pending[string(txID)] = outs
+		return nil
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	prepared := set.prepareReindex(hashes, 0, stop)
+
+	for height := range hashes {
+		block := <-<-prepared
+		if block.err != nil {
+			return block.err
+		}
+		for _, changes := range block.changes {
+			if err := applyChanges(changes, get, put); err != nil {
+				return err
+			}
+		}
+
+		if len(pending) < reindexBatchSize && height != len(hashes)-1 {
+			continue
+		}
+		err := bc.Database.Update(func(txn *badger.Txn) error {
+			return writeEntries(txn, snapshotCheckPrefix, pending)
+		})
+		if err != nil {
+			return err
+		}
+		for txID := range pending {
+			delete(pending, txID)
+		}
+	}
+
+	history := SnapshotInfo{BaseHash: info.BaseHash, BaseHeight: base.Height}
+	if history.Deployments, err = bc.snapshotDeployments(info.BaseHash, base.Height); err != nil {
+		return err
+	}
+	err = bc.Database.View(func(txn *badger.Txn) error {
+		return writeSnapshot(txn, io.Discard, snapshotCheckPrefix, &history)
+	})
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(history.ContentHash, info.ContentHash) {
+		return fmt.Errorf("history hashes to %x, snapshot claims %x", history.ContentHash, info.ContentHash)
+	}
+
+	info.Validated = true
+	return bc.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(snapshotKey, info.serialize())
+	})
+}
+
+// SnapshotUnvalidated reports whether the chain was started from a snapshot that is not
+// validated yet although the history below it is stored
+func (bc *BlockChain) SnapshotUnvalidated() bool {
+	info, err := bc.Snapshot()
+	return err == nil && !info.Validated && bc.HasBlock(info.BaseHash)
+}
+
+// needsHistory reports whether the chain was started from a snapshot whose history has not been
+// stored yet
+func (bc *BlockChain) needsHistory() bool {
+	info, err := bc.Snapshot()
+	return err == nil && !bc.HasBlock(info.BaseHash)
+}
+
+// connectHistoryBlock stores a block below the snapshot base. The UTXO set and the tip already
+// reflect these blocks, so only the block itself is written.
+func (bc *BlockChain) connectHistoryBlock(block *Block) error {
+	bc.writeLock.Lock()
+	defer bc.writeLock.Unlock()
+
+	if bc.HasBlock(block.Hash) {
+		return ErrBlockKnown
+	}
+	if err := block.Validate(); err != nil {
+		return err
+	}
+	if len(block.PrevHash) != 0 && !bc.HasBlock(block.PrevHash) {
+		return fmt.Errorf("history block %x does not connect to a stored block", block.Hash)
+	}
+
+	return bc.Database.Update(func(txn *badger.Txn) error {
+		return bc.putBlock(txn, block)
+	})
+}
+
+// ValidateSnapshotInBackground runs ValidateSnapshot on its own goroutine and reports the result
+// on the returned channel. The chain must stay open until the result is in.
+func (bc *BlockChain) ValidateSnapshotInBackground() <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- bc.ValidateSnapshot()
+	}()
+	return done
+}
+
+func (info SnapshotInfo) serialize() []byte {
+	var e encoder
+	e.buf.WriteByte(encodingVersion)
+	e.putBytes(info.BaseHash)
+	e.putInt64(int64(info.BaseHeight))
+	e.putUint64(info.Count)
+	e.putBytes(info.ContentHash)
+	validated := byte(0)
+	if info.Validated {
+		validated = 1
+	}
+	e.buf.WriteByte(validated)
+	e.putUint32(uint32(len(info.Deployments)))
+	for _, d := range info.Deployments {
+		e.putBytes([]byte(d.Name))
+		e.putUint32(uint32(d.State))
+		e.putInt64(int64(d.Since))
+		e.putUint32(uint32(d.Signals))
+	}
+	return e.buf.Bytes()
+}
+
+func deserializeSnapshotInfo(data []byte) (SnapshotInfo, error) {
+	var info SnapshotInfo
+	d := decoder{data: data}
+
+	d.version()
+	info.BaseHash = d.bytes()
+	info.BaseHeight = int(d.int64())
+	info.Count = d.uint64()
+	info.ContentHash = d.bytes()
+	if validated := d.take(1); d.err == nil {
+		info.Validated = validated[0] == 1
+	}
+	for i, n := 0, d.count(4+4+8+4); i < n; i++ {
+		var deployment SnapshotDeployment
+		deployment.Name = string(d.bytes())
+		deployment.State = DeploymentState(d.uint32())
+		deployment.Since = int(d.int64())
+		deployment.Signals = int(d.uint32())
+		info.Deployments = append(info.Deployments, deployment)
+	}
+
+	if err := d.finish(); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("decode snapshot info: %w", err)
+	}
+	return info, nil
+}
@@ -0,0 +1,191 @@
+package models
+
+import (
+	"bytes"
+	"github.com/dgraph-io/badger"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// snapshotTestChain mines a chain with benchmarkBlocks and, with a UTXO cache holding the
+// changes of one more block, dumps its UTXO set. It returns the snapshot and a bootstrap file of
+// the chain, and the UTXO set the snapshot should hold.
+func snapshotTestChain(t *testing.T) (snapshot, bootstrap string, want map[string]TxOutputs, info SnapshotInfo) {
+	benchmarkBlocks(t)
+	dir := t.TempDir()
+	snapshot = filepath.Join(dir, "snapshot.dat")
+	bootstrap = filepath.Join(dir, "bootstrap.dat")
+
+	quiet(t, func() {
+		chain := OpenBlockChain()
+		defer chain.Database.Close()
+		chain.EnableUTXOCache(64 << 20)
+		chain.MineBlock(string(MakeWallet(KeyP256).Address()))
+
+		info = UTXOSet{chain}.DumpSnapshot(snapshot)
+		if !bytes.Equal(info.BaseHash, chain.Tip()) {
+			t.Errorf("snapshot at %x, want the tip %x", info.BaseHash, chain.Tip())
+		}
+		if _, err := chain.ExportChain(bootstrap, 0, chain.Height()); err != nil {
+			t.Fatal(err)
+		}
+		want = utxoEntries(chain)
+	})
+	return snapshot, bootstrap, want, info
+}
+
+// keysWithPrefix counts the keys stored under prefix
+func keysWithPrefix(t *testing.T, chain *BlockChain, prefix []byte) int {
+	n := 0
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	snapshot, bootstrap, want, dumped := snapshotTestChain(t)
+	if uint64(len(want)) != dumped.Count {
+		t.Errorf("snapshot has %d entries, the set %d", dumped.Count, len(want))
+	}
+
+	chdirTemp(t)
+	quiet(t, func() {
+		chain, info := LoadSnapshot(snapshot)
+		defer chain.Database.Close()
+
+		if !reflect.DeepEqual(info, dumped) {
+			t.Errorf("loaded %+v, dumped %+v", info, dumped)
+		}
+		if !bytes.Equal(chain.Tip(), dumped.BaseHash) || chain.Height() != dumped.BaseHeight {
+			t.Errorf("tip %x at height %d, want the snapshot base", chain.Tip(), chain.Height())
+		}
+		if got := utxoEntries(chain); !reflect.DeepEqual(got, want) {
+			t.Errorf("loaded UTXO set has %d entries, want %d", len(got), len(want))
+		}
+
+		again := filepath.Join(t.TempDir(), "again.dat")
+		UTXOSet{chain}.DumpSnapshot(again)
+		first, _ := os.ReadFile(snapshot)
+		second, _ := os.ReadFile(again)
+		if !bytes.Equal(first, second) {
+			t.Error("dumping the loaded set gave a different file")
+		}
+
+		// the history arrives after the snapshot and is validated in the background, replayed
+		// in batches small enough to spend outputs of earlier ones
+		useReindexBatchSize(t, 5)
+		stats, err := chain.ImportChain(bootstrap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Validation == nil {
+			t.Fatal("importing the history did not start the validation")
+		}
+		if err := <-stats.Validation; err != nil {
+			t.Fatal(err)
+		}
+		if info, err := chain.Snapshot(); err != nil || !info.Validated {
+			t.Errorf("snapshot %+v, %v after validation", info, err)
+		}
+		if chain.SnapshotUnvalidated() {
+			t.Error("validated snapshot reported as unvalidated")
+		}
+		if got := utxoEntries(chain); !reflect.DeepEqual(got, want) {
+			t.Errorf("UTXO set after the history has %d entries, want %d", len(got), len(want))
+		}
+		if n := keysWithPrefix(t, chain, snapshotCheckPrefix); n != 0 {
+			t.Errorf("validation left %d keys behind", n)
+		}
+	})
+}
+
+func TestValidateSnapshotMismatch(t *testing.T) {
+	snapshot, bootstrap, _, _ := snapshotTestChain(t)
+
+	chdirTemp(t)
+	quiet(t, func() {
+		chain, info := LoadSnapshot(snapshot)
+		defer chain.Database.Close()
+		if err := chain.ValidateSnapshot(); err != ErrSnapshotHistory {
+			t.Errorf("validation without history: error %v, want %v", err, ErrSnapshotHistory)
+		}
+
+		// a snapshot claiming another content hash does not match the history
+		info.ContentHash = bytes.Repeat([]byte{0x42}, len(info.ContentHash))
+		if err := chain.Database.Update(func(txn *badger.Txn) error {
+			return txn.Set(snapshotKey, info.serialize())
+		}); err != nil {
+			t.Fatal(err)
+		}
+		stats, err := chain.ImportChain(bootstrap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := <-stats.Validation; err == nil || !strings.Contains(err.Error(), "history hashes to") {
+			t.Errorf("validation error %v, want a hash mismatch", err)
+		}
+		if !chain.SnapshotUnvalidated() {
+			t.Error("snapshot marked validated after a mismatch")
+		}
+	})
+}
+
+func TestSnapshotFileRejected(t *testing.T) {
+	snapshot, _, _, _ := snapshotTestChain(t)
+	data, err := os.ReadFile(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// a changed byte in the last entry's script no longer matches the content hash
+	changed := append([]byte{}, data...)
+	changed[len(changed)-33] ^= 0xff
+	if _, err := ReadSnapshotInfo(write("changed.dat", changed)); err == nil || !strings.Contains(err.Error(), "hash mismatch") {
+		t.Errorf("changed snapshot: error %v, want a hash mismatch", err)
+	}
+
+	for _, n := range []int{0, 5, len(snapshotMagic) + 1, len(data) / 2, len(data) - 33, len(data) - 1} {
+		if _, err := ReadSnapshotInfo(write("truncated.dat", data[:n])); err == nil {
+			t.Errorf("snapshot truncated to %d of %d bytes read", n, len(data))
+		}
+	}
+	if _, err := ReadSnapshotInfo(write("trailing.dat", append(append([]byte{}, data...), 0))); err == nil {
+		t.Error("snapshot with a trailing byte read")
+	}
+
+	// LoadSnapshot checks the file before it touches the chain
+	chdirTemp(t)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("loading a changed snapshot did not fail")
+			}
+		}()
+		quiet(t, func() { LoadSnapshot(filepath.Join(dir, "changed.dat")) })
+	}()
+	if DBExists() {
+		t.Error("loading a changed snapshot created a chain")
+	}
+}
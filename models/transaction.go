@@ -2,50 +2,58 @@ package models
 
 import (
 	"bytes"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
 	"encoding/hex"
 	"fmt"
 	"github.com/bucks-go-wallet/utils"
 	"log"
-	"math/big"
+	"math"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Transaction struct {
-	ID      []byte
-	Inputs  []TxInput
-	Outputs []TxOutput
+	ID       []byte
+	Inputs   []TxInput
+	Outputs  []TxOutput
+	LockTime int // the transaction can only be in blocks above this height or time, 0 for any block
+	Version  uint32
 }
 
+// TxVersion is the version of the transactions the wallet builds. Blocks take any version, the
+// mempool only the ones up to TxVersion, so a later version can bring in rules with a soft fork.
+const TxVersion = 1
+
+// BlockSubsidy is what the coinbase of a block may pay on top of the fees of the block
+const BlockSubsidy = 100 * UnitsPerCoin
+
 // Cody is the coin base
 func CoinbaseTx(to, data string) *Transaction {
 	if data == "" {
 		data = fmt.Sprintf("Coins to %s", to)
 	}
 
-	txin := TxInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTxOutput(100, to)
+	txin := TxInput{[]byte{}, -1, PushScript([]byte(data)), SequenceFinal}
+	txout := NewTxOutput(BlockSubsidy, to)
 
-	tx := Transaction{nil, []TxInput{txin}, []TxOutput{*txout}}
+	tx := Transaction{nil, []TxInput{txin}, []TxOutput{*txout}, 0, TxVersion}
 	tx.SetID()
 
 	return &tx
 }
 
-func (tx *Transaction) SetID() {
-	var encoded bytes.Buffer
-	var hash [32]byte
-
-	encode := gob.NewEncoder(&encoded)
-	err := encode.Encode(tx)
-	utils.Handle(err)
+// NewCoinbaseTx pays the subsidy and the fees of a block at height to an address. The height in
+// the coinbase data keeps coinbases paying the same address apart.
+func NewCoinbaseTx(to string, height int, fees Amount) *Transaction {
+	tx := CoinbaseTx(to, fmt.Sprintf("Block %d coins to %s", height, to))
+	tx.Outputs[0].Value = BlockSubsidy + fees
+	tx.SetID()
+	return tx
+}
 
-	hash = sha256.Sum256(encoded.Bytes())
-	tx.ID = hash[:]
+func (tx *Transaction) SetID() {
+	tx.ID = tx.Hash()
 }
 
 // IsCoinbase check if the transaction is the coinbase. The coinbase has only one input
@@ -53,132 +61,452 @@ func (tx *Transaction) IsCoinbase() bool {
 	return len(tx.Inputs) == 1 && len(tx.Inputs[0].ID) == 0 && tx.Inputs[0].Out == -1
 }
 
-func NewTransaction(from, to string, amount int, set *UTXOSet) *Transaction {
-	var inputs []TxInput
+// SendOptions tune the transactions built by NewTransaction and NewSendManyTransaction
+type SendOptions struct {
+	LockTime int          // a non-zero lock time keeps the transaction out of blocks up to that height or time
+	Memo     []byte       // carried in a data output after the payments when set
+	Coins    CoinSelector // picks the outputs to spend, in UTXO set order when nil
+	Fee      Amount       // paid to the miner out of the change, overrides FeeRate when set
+	FeeRate  FeeRate      // sizes the fee to the signed transaction when Fee is zero
+}
+
+// NewTransaction pays amount from a wallet address to another address
+func NewTransaction(from, to string, amount Amount, opts SendOptions, set *UTXOSet) *Transaction {
+	return newTransaction(from, []TxOutput{*NewTxOutput(amount, to)}, opts, set)
+}
+
+// Payment is an amount to pay to an address
+type Payment struct {
+	Address string
+	Amount  Amount
+}
+
+// NewSendManyTransaction pays every payment from a wallet address in one transaction, with one
+// output per payment in the given order followed by the change
+func NewSendManyTransaction(from string, payments []Payment, opts SendOptions, set *UTXOSet) *Transaction {
 	var outputs []TxOutput
+	for _, payment := range payments {
+		if payment.Amount == 0 {
+			log.Panicf("Error: payment to %s is zero", payment.Address)
+		}
+		outputs = append(outputs, *NewTxOutput(payment.Amount, payment.Address))
+	}
+	return newTransaction(from, outputs, opts, set)
+}
+
+// SendManyChange returns the change of a transaction paying payments from an address: what it
+// pays to the address beyond the payments to it. It is zero when the coins spent match the
+// payments, as BranchAndBound picks them.
+func SendManyChange(tx *Transaction, from string, payments []Payment) (Amount, error) {
+	fromScript, err := AddressScript(from)
+	if err != nil {
+		return 0, err
+	}
+	var toFrom, paid Amount
+	for _, out := range tx.Outputs {
+		if bytes.Equal(out.Script, fromScript) {
+			if toFrom, err = toFrom.Add(out.Value); err != nil {
+				return 0, err
+			}
+		}
+	}
+	for _, payment := range payments {
+		if payment.Address == from {
+			if paid, err = paid.Add(payment.Amount); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return toFrom.Sub(paid)
+}
+
+// NewDataTransaction commits data to the chain in a data output, paid for by a wallet address
+func NewDataTransaction(from string, data []byte, opts SendOptions, set *UTXOSet) *Transaction {
+	opts.Memo = data
+	return newTransaction(from, nil, opts, set)
+}
+
+// newTransaction funds the outputs and the fee from a wallet address, sends the change back to
+// it and signs with the keys in the wallet file
+func newTransaction(from string, outputs []TxOutput, opts SendOptions, set *UTXOSet) *Transaction {
+	psbt := newPSBT(from, outputs, opts, set)
 
 	wallets, err := CreateWallets()
 	utils.Handle(err)
-	w := wallets.GetWallet(from)
-	pubKeyHash := PublicKeyHash(w.PublicKey)
-	acc, validOutputs := set.FindSpendableOutputs(pubKeyHash, amount)
+	if redeemScript, ok := wallets.RedeemScripts[from]; ok {
+		m, _, _ := multiSigParams(redeemScript)
+		if signers := wallets.MultiSigSigners(redeemScript); len(signers) < m {
+			log.Panicf("Error: %d signatures needed, only %d of the keys are in the wallet file", m, len(signers))
+		}
+	}
+	psbt.Sign(wallets, SigHashAll)
 
-	if acc < amount {
-		log.Panic("Error: not enough funds")
+	tx, err := psbt.Finalize()
+	utils.Handle(err)
+	return tx
+}
+
+// NewPSBT funds the payments like NewSendManyTransaction but leaves the transaction unsigned, for
+// keys held elsewhere to sign. Only the redeem script of a multisig address is taken from the
+// wallet file.
+func NewPSBT(from string, payments []Payment, opts SendOptions, set *UTXOSet) *PSBT {
+	var outputs []TxOutput
+	for _, payment := range payments {
+		if payment.Amount == 0 {
+			log.Panicf("Error: payment to %s is zero", payment.Address)
+		}
+		outputs = append(outputs, *NewTxOutput(payment.Amount, payment.Address))
 	}
+	return newPSBT(from, outputs, opts, set)
+}
 
-	for txid, outs := range validOutputs {
-		txID, err := hex.DecodeString(txid)
+// newPSBT funds the outputs and the fee from an address and sends the change back to it.
+// Outputs already spent by mempool transactions are left alone.
+func newPSBT(from string, outputs []TxOutput, opts SendOptions, set *UTXOSet) *PSBT {
+	if opts.LockTime < 0 || int64(opts.LockTime) > math.MaxUint32 {
+		log.Panicf("Error: lock time %d is out of range", opts.LockTime)
+	}
+	if opts.Fee > MaxMoney || opts.FeeRate < 0 {
+		log.Panic("Error: fee is out of range")
+	}
+	if opts.Memo != nil {
+		out, err := NewDataOutput(opts.Memo)
 		utils.Handle(err)
+		outputs = append(outputs, *out)
+	}
 
-		for _, out := range outs {
-			inputs = append(inputs, TxInput{txID, out, nil, w.PublicKey})
+	fromScript, err := AddressScript(from)
+	utils.Handle(err)
+	var redeemScript []byte
+	if ClassifyScript(fromScript) == ScriptHashScript {
+		wallets, _ := CreateWallets()
+		if redeemScript = wallets.RedeemScripts[from]; redeemScript == nil {
+			log.Panicf("Error: the redeem script of %s is not in the wallet file", from)
 		}
 	}
 
-	outputs = append(outputs, *NewTxOutput(amount, to))
+	spent := set.BlockChain.MempoolSpends()
+	var coins []Coin
+	for _, coin := range set.FindCoins(fromScript) {
+		if !spent[outpointKey(coin.TxID, coin.Index)] {
+			coins = append(coins, coin)
+		}
+	}
 
-	if acc > amount {
-		outputs = append(outputs, *NewTxOutput(acc-amount, from))
+	// A fee rate needs the size of the signed transaction, which depends on the inputs the fee
+	// makes it spend, so fund it until the fee covers its own size. It starts at the fee for the
+	// bytes every choice of inputs has, BranchAndBound pays for the inputs out of their values.
+	fee := opts.Fee
+	var inputFee Amount
+	if fee == 0 && opts.FeeRate != 0 {
+		fee = opts.FeeRate.Fee(len((&Transaction{make([]byte, 32), nil, outputs, opts.LockTime, TxVersion}).Serialize()))
+		inputFee = opts.FeeRate.Fee(inputSize(TxOutput{0, fromScript}, redeemScript))
+	}
+	for {
+		psbt := fundTransaction(from, outputs, fee, inputFee, coins, opts)
+		for i, prevOut := range psbt.PrevOutputs {
+			prevOut.Script = fromScript
+			psbt.PrevOutputs[i] = prevOut
+			psbt.RedeemScripts[i] = redeemScript
+		}
+		if opts.Fee != 0 || opts.FeeRate == 0 {
+			return psbt
+		}
+		needed := opts.FeeRate.Fee(psbt.SignedSize())
+		paid, err := psbt.Fee()
+		utils.Handle(err)
+		if needed <= paid {
+			return psbt
+		}
+		fee += needed - paid
 	}
+}
 
-	tx := Transaction{nil, inputs, outputs}
-	tx.ID = tx.Hash()
-	set.BlockChain.SignTransaction(&tx, w.PrivateKey)
+// fundTransaction picks coins paying the outputs and fee and adds the change. BranchAndBound
+// picks coins by their value less inputFee, the fee for the input spending them, and gets no
+// change output, what the coins pay beyond the outputs goes to the fee. The previous outputs it
+// returns carry only the values of the coins.
+func fundTransaction(from string, outputs []TxOutput, fee, inputFee Amount, coins []Coin, opts SendOptions) *PSBT {
+	var inputs []TxInput
+	var prevOuts []TxOutput
+	var err error
 
-	return &tx
+	sequence := SequenceFinal
+	if opts.LockTime != 0 {
+		sequence = SequenceFinal - 1
+	}
+
+	amount := fee
+	for _, out := range outputs {
+		if amount, err = amount.Add(out.Value); err != nil {
+			log.Panic("Error: ", err)
+		}
+	}
+	// a transaction only carrying data still needs an input
+	wanted := amount
+	if wanted == 0 {
+		wanted = 1
+	}
+	selector := opts.Coins
+	changeless := false
+	if bnb, ok := selector.(BranchAndBound); ok {
+		if bnb.MaxExcess == 0 {
+			rate := opts.FeeRate
+			if rate == 0 {
+				rate = MinFeeRate
+			}
+			bnb.MaxExcess = CostOfChange(rate)
+		}
+		selector, changeless = bnb, true
+	}
+	var selected []Coin
+	switch {
+	case selector == nil:
+		selected, err = takeUntil(coins, wanted)
+	case changeless && inputFee > 0:
+		var effective []Coin
+		for _, coin := range coins {
+			if coin.Value > inputFee {
+				effective = append(effective, Coin{coin.TxID, coin.Index, coin.Value - inputFee})
+			}
+		}
+		selected, err = selector.Select(effective, wanted)
+		for i := range selected {
+			selected[i].Value += inputFee
+		}
+	default:
+		selected, err = selector.Select(coins, wanted)
+	}
+	if err != nil {
+		log.Panic("Error: ", err)
+	}
+
+	var acc Amount
+	for _, coin := range selected {
+		acc += coin.Value
+		inputs = append(inputs, TxInput{coin.TxID, coin.Index, nil, sequence})
+		prevOuts = append(prevOuts, TxOutput{coin.Value, nil})
+	}
+	if acc < wanted {
+		log.Panic("Error: not enough funds")
+	}
+
+	outputs = append([]TxOutput{}, outputs...)
+	if acc > amount && !changeless {
+		outputs = append(outputs, *NewTxOutput(acc-amount, from))
+	}
+
+	tx := Transaction{nil, inputs, outputs, opts.LockTime, TxVersion}
+	tx.SetID()
+	return &PSBT{tx, prevOuts, make([][]byte, len(inputs))}
 }
 
+// Hash returns the transaction ID, the hash of the transaction without its witness: the
+// unlocking scripts holding signatures and public keys. Signing or re-encoding a signature
+// leaves it unchanged. The coinbase input holds data instead of a signature and is hashed whole.
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
 
 	txCopy := *tx
+	if !tx.IsCoinbase() {
+		txCopy = tx.TrimmedCopy()
+	}
 	txCopy.ID = []byte{}
 
 	hash = sha256.Sum256(txCopy.Serialize())
 	return hash[:]
 }
 
+// WitnessHash returns the hash of the whole transaction, unlocking scripts included
+func (tx *Transaction) WitnessHash() []byte {
+	txCopy := *tx
+	txCopy.ID = []byte{}
+
+	hash := sha256.Sum256(txCopy.Serialize())
+	return hash[:]
+}
+
 func (tx *Transaction) Serialize() []byte {
-	var encoded bytes.Buffer
+	var e encoder
+	encodeTransaction(&e, tx)
+	return e.buf.Bytes()
+}
 
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
+// DeserializeTransaction converts bytes written by Serialize back into a transaction
+func DeserializeTransaction(data []byte) Transaction {
+	tx, err := decodeTransaction(data)
 	utils.Handle(err)
+	return *tx
+}
 
-	return encoded.Bytes()
+// Hex returns the serialized transaction in hex, the form the raw transaction commands exchange
+func (tx *Transaction) Hex() string {
+	return hex.EncodeToString(tx.Serialize())
 }
 
-func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+// TransactionFromHex decodes a transaction written by Hex
+func TransactionFromHex(s string) (*Transaction, error) {
+	data, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	return decodeTransaction(data)
+}
+
+// Sign fills the unlocking scripts of the inputs spending pay-to-pubkey-hash outputs of privKey.
+// prevOuts are the outputs the inputs spend, in input order. Inputs locked to other keys or
+// scripts are left for their owners. hashType says what the signatures cover.
+func (tx *Transaction) Sign(privKey Signer, prevOuts []TxOutput, hashType SigHashType) {
 	if tx.IsCoinbase() {
 		return
 	}
+	if len(prevOuts) != len(tx.Inputs) {
+		log.Panic("ERROR: Previous outputs do not match the inputs")
+	}
 
-	for _, in := range tx.Inputs {
-		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
+	pubKey := privKey.Public().Bytes()
+	pubKeyHash := PublicKeyHash(pubKey)
+
+	for inId, prevOut := range prevOuts {
+		if !prevOut.IsLockedWithKey(pubKeyHash) {
+			continue
 		}
+
+		signature := tx.signInput(privKey, inId, prevOut.Script, prevOut, hashType)
+		tx.Inputs[inId].ScriptSig = PushScript(signature, pubKey)
 	}
+}
 
-	txCopy := tx.TrimmedCopy()
+// SignMultiSig adds the signature of privKey to the inputs spending the pay-to-script-hash
+// outputs of a multisig redeem script. Signatures already in the inputs are kept in key order,
+// so the transaction can go from one key holder to the next until enough are collected.
+func (tx *Transaction) SignMultiSig(privKey Signer, redeemScript []byte, prevOuts []TxOutput, hashType SigHashType) {
+	if tx.IsCoinbase() {
+		return
+	}
+	if len(prevOuts) != len(tx.Inputs) {
+		log.Panic("ERROR: Previous outputs do not match the inputs")
+	}
 
-	for inId, in := range txCopy.Inputs {
-		prevTX := prevTXs[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PubKey = prevTX.Outputs[in.Out].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[inId].PubKey = nil
+	_, pubKeys, ok := multiSigParams(redeemScript)
+	if !ok {
+		log.Panic("ERROR: Redeem script is not a multisig script")
+	}
+	found := false
+	for _, pubKey := range pubKeys {
+		found = found || bytes.Equal(pubKey, privKey.Public().Bytes())
+	}
+	if !found {
+		log.Panic("ERROR: Key is not part of the multisig script")
+	}
+	lockingScript := PayToScriptHashScript(ScriptHash(redeemScript))
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, txCopy.ID)
-		utils.Handle(err)
-		signature := append(r.Bytes(), s.Bytes()...)
+	for inId, prevOut := range prevOuts {
+		if !bytes.Equal(prevOut.Script, lockingScript) {
+			continue
+		}
 
-		tx.Inputs[inId].Signature = signature
+		signed := PushScript(tx.signInput(privKey, inId, redeemScript, prevOut, hashType), redeemScript)
+		tx.Inputs[inId].ScriptSig = tx.multiSigScriptSig(inId, redeemScript, prevOut, tx.Inputs[inId].ScriptSig, signed)
+	}
+}
 
+// multiSigScriptSig merges the signatures in the unlocking scripts of multisig input inId,
+// spending prevOut, into one unlocking script, holding at most m of them in key order. Signatures
+// that do not sign the input for one of the keys are dropped.
+func (tx *Transaction) multiSigScriptSig(inId int, redeemScript []byte, prevOut TxOutput, scriptSigs ...[]byte) []byte {
+	m, pubKeys, _ := multiSigParams(redeemScript)
+
+	sigs := make([][]byte, len(pubKeys))
+	for _, scriptSig := range scriptSigs {
+		elements, err := scriptPushes(scriptSig)
+		if err != nil || len(elements) == 0 {
+			continue
+		}
+		for _, sig := range elements[:len(elements)-1] {
+			for i, pubKey := range pubKeys {
+				if sigs[i] == nil && tx.checkSignature(inId, sig, pubKey, redeemScript, prevOut) {
+					sigs[i] = sig
+					break
+				}
+			}
+		}
 	}
+
+	var elements [][]byte
+	for _, sig := range sigs {
+		if sig != nil && len(elements) < m {
+			elements = append(elements, sig)
+		}
+	}
+	return PushScript(append(elements, redeemScript)...)
 }
 
-func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
+// Verify runs the unlocking script of every input. prevOuts are the outputs the inputs spend,
+// in input order.
+func (tx *Transaction) Verify(prevOuts []TxOutput) bool {
 	if tx.IsCoinbase() {
 		return true
 	}
+	if len(prevOuts) != len(tx.Inputs) {
+		return false
+	}
 
-	for _, in := range tx.Inputs {
-		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("Previous transaction not correct")
+	for inId, prevOut := range prevOuts {
+		if tx.VerifyInput(inId, prevOut) != nil {
+			return false
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
+	return true
+}
 
-	for inId, in := range tx.Inputs {
-		prevTx := prevTXs[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PubKey = prevTx.Outputs[in.Out].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[inId].PubKey = nil
+// VerifyInput runs the unlocking script of input inId against the output it spends
+func (tx *Transaction) VerifyInput(inId int, prevOut TxOutput) error {
+	return verifyScript(tx.Inputs[inId].ScriptSig, prevOut.Script, inputChecker{tx, inId, prevOut})
+}
 
-		r := big.Int{}
-		s := big.Int{}
+// inputChecker answers the questions scripts ask about the input being verified
+type inputChecker struct {
+	tx      *Transaction
+	inId    int
+	prevOut TxOutput
+}
 
-		sigLen := len(in.Signature)
-		r.SetBytes(in.Signature[:(sigLen / 2)])
-		s.SetBytes(in.Signature[(sigLen / 2):])
+func (c inputChecker) checkSig(sig, pubKey, subscript []byte) bool {
+	return c.tx.checkSignature(c.inId, sig, pubKey, subscript, c.prevOut)
+}
 
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(in.PubKey)
-		x.SetBytes(in.PubKey[:(keyLen / 2)])
-		y.SetBytes(in.PubKey[(keyLen / 2):])
+// checkLockTime requires the transaction lock time to be of the same kind, height or time, and
+// at least lockTime, and the input to not turn it off with SequenceFinal
+func (c inputChecker) checkLockTime(lockTime int64) bool {
+	txLockTime := int64(c.tx.LockTime)
+	if lockTime < 0 || (lockTime < LockTimeThreshold) != (txLockTime < LockTimeThreshold) {
+		return false
+	}
+	return txLockTime >= lockTime && c.tx.Inputs[c.inId].Sequence != SequenceFinal
+}
 
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if ecdsa.Verify(&rawPubKey, txCopy.ID, &r, &s) == false {
-			return false
-		}
+// checkSequence requires the input's relative lock time to be of the same kind, blocks or
+// time, and at least sequence
+func (c inputChecker) checkSequence(sequence int64) bool {
+	if sequence < 0 {
+		return false
+	}
+	if uint32(sequence)&SequenceLockTimeDisabled != 0 {
+		return true
 	}
 
-	return true
+	txSequence := c.tx.Inputs[c.inId].Sequence
+	if txSequence&SequenceLockTimeDisabled != 0 {
+		return false
+	}
+	mask := SequenceLockTimeTypeFlag | SequenceLockTimeMask
+	wanted, have := uint32(sequence)&mask, txSequence&mask
+	if (wanted&SequenceLockTimeTypeFlag == 0) != (have&SequenceLockTimeTypeFlag == 0) {
+		return false
+	}
+	return have >= wanted
 }
 
 func (tx *Transaction) TrimmedCopy() Transaction {
@@ -186,32 +514,54 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 	var outputs []TxOutput
 
 	for _, in := range tx.Inputs {
-		inputs = append(inputs, TxInput{in.ID, in.Out, nil, nil})
+		inputs = append(inputs, TxInput{in.ID, in.Out, nil, in.Sequence})
 	}
 
 	for _, out := range tx.Outputs {
-		outputs = append(outputs, TxOutput{out.Value, out.PubKeyHash})
+		outputs = append(outputs, TxOutput{out.Value, out.Script})
 	}
 
-	return Transaction{tx.ID, inputs, outputs}
+	return Transaction{tx.ID, inputs, outputs, tx.LockTime, tx.Version}
+}
+
+func isText(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
 }
 
 func (tx Transaction) String() string {
 	var lines []string
 
 	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
+	lines = append(lines, fmt.Sprintf("     Version: %d", tx.Version))
 	for i, input := range tx.Inputs {
 		lines = append(lines, fmt.Sprintf("     Input %d:", i))
 		lines = append(lines, fmt.Sprintf("       TXID:     %x", input.ID))
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Out))
-		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
-		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+		lines = append(lines, fmt.Sprintf("       ScriptSig: %s", DisassembleScript(input.ScriptSig)))
+		lines = append(lines, fmt.Sprintf("       Sequence:  %08x", input.Sequence))
 	}
 
 	for i, output := range tx.Outputs {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
-		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
-		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+		lines = append(lines, fmt.Sprintf("       Value:  %s", output.Value))
+		lines = append(lines, fmt.Sprintf("       Script: %s", DisassembleScript(output.Script)))
+		if data, ok := output.Data(); ok && isText(data) {
+			lines = append(lines, fmt.Sprintf("       Data:   %q", data))
+		} else if ok {
+			lines = append(lines, fmt.Sprintf("       Data:   %x", data))
+		}
+	}
+
+	if tx.LockTime != 0 {
+		lines = append(lines, fmt.Sprintf("     LockTime: %d", tx.LockTime))
 	}
 
 	return strings.Join(lines, "\n")
@@ -0,0 +1,264 @@
+package models
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// signedTestTx spends one output of the wallet's into one output, signed with hashType
+func signedTestTx(t *testing.T, w *Wallet, hashType SigHashType) (*Transaction, TxOutput) {
+	prevOut := *NewTxOutput(9*UnitsPerCoin, string(w.Address()))
+	tx := &Transaction{nil, []TxInput{{bytes.Repeat([]byte{0x11}, 32), 0, nil, SequenceFinal}},
+		[]TxOutput{{8 * UnitsPerCoin, PayToPubKeyHashScript(bytes.Repeat([]byte{0x33}, 20))}}, 0, TxVersion}
+	tx.SetID()
+	tx.Sign(w.PrivateKey, []TxOutput{prevOut}, hashType)
+	if !tx.Verify([]TxOutput{prevOut}) {
+		t.Fatal("signed transaction does not verify")
+	}
+	return tx, prevOut
+}
+
+func TestScriptSigLeavesIDAlone(t *testing.T) {
+	w := MakeWallet(KeyP256)
+	tx, prevOut := signedTestTx(t, w, SigHashAll)
+	id := append([]byte{}, tx.ID...)
+	witnessHash := tx.WitnessHash()
+	block := &Block{Transactions: []*Transaction{tx}}
+	txHashes, witnesses := block.HashTransactions(), block.HashWitnesses()
+
+	// the same pushes with a longer encoding still unlock the output
+	elements, err := scriptPushes(tx.Inputs[0].ScriptSig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reencoded []byte
+	for _, element := range elements {
+		reencoded = append(append(reencoded, OpPushData1, byte(len(element))), element...)
+	}
+	if bytes.Equal(reencoded, tx.Inputs[0].ScriptSig) {
+		t.Fatal("re-encoding did not change the unlocking script")
+	}
+
+	// and so does another signature of the same key
+	other, _ := signedTestTx(t, w, SigHashAll|SigHashAnyoneCanPay)
+
+	for name, scriptSig := range map[string][]byte{
+		"re-encoded pushes": reencoded,
+		"other signature":   other.Inputs[0].ScriptSig,
+		"no unlocking":      nil,
+	} {
+		changed := *tx
+		changed.Inputs = []TxInput{tx.Inputs[0]}
+		changed.Inputs[0].ScriptSig = scriptSig
+
+		if !bytes.Equal(changed.Hash(), id) {
+			t.Errorf("%s: ID changed to %x from %x", name, changed.Hash(), id)
+		}
+		if bytes.Equal(changed.WitnessHash(), witnessHash) {
+			t.Errorf("%s: witness hash unchanged", name)
+		}
+		if scriptSig != nil && !changed.Verify([]TxOutput{prevOut}) {
+			t.Errorf("%s: no longer verifies", name)
+		}
+
+		block := &Block{Transactions: []*Transaction{&changed}}
+		if !bytes.Equal(block.HashTransactions(), txHashes) {
+			t.Errorf("%s: block transaction hash changed", name)
+		}
+		if bytes.Equal(block.HashWitnesses(), witnesses) {
+			t.Errorf("%s: block witness hash unchanged", name)
+		}
+	}
+}
+
+func TestSendManyOutputsAndFee(t *testing.T) {
+	chdirTemp(t)
+	wallets, _ := CreateWallets()
+	from := wallets.AddWallet(KeyP256)
+	wallets.SaveFile()
+	other := func() string { return string(MakeWallet(KeyP256).Address()) }
+
+	quiet(t, func() {
+		chain := InitBlockChain(from, false)
+		defer chain.Database.Close()
+		set := UTXOSet{chain}
+		set.Reindex()
+		fromScript, _ := AddressScript(from)
+		coins := set.FindCoins(fromScript)
+		if len(coins) != 1 {
+			t.Fatalf("%d coins after the genesis block", len(coins))
+		}
+		coin := coins[0].Value
+
+		tests := []struct {
+			name     string
+			payments []Payment
+			opts     SendOptions
+			change   bool
+		}{
+			// the genesis coin pays the payments and the fee exactly, there is no change output although
+			// one of the outputs goes to from
+			{"no change", []Payment{{other(), UnitsPerCoin}, {from, coin - UnitsPerCoin - 700}},
+				SendOptions{Fee: 700, Coins: BranchAndBound{}}, false},
+			{"fixed fee", []Payment{{other(), 3 * UnitsPerCoin}, {other(), UnitsPerCoin / 2}, {other(), 1}},
+				SendOptions{Fee: 1000}, true},
+			{"fee rate", []Payment{{other(), 7 * UnitsPerCoin}, {other(), 2 * UnitsPerCoin}},
+				SendOptions{FeeRate: 3}, true},
+			// a payment to itself goes before the change to it
+			{"paying itself", []Payment{{from, 2 * UnitsPerCoin}, {other(), UnitsPerCoin}},
+				SendOptions{Fee: 500}, true},
+		}
+		for _, test := range tests {
+			tx := NewSendManyTransaction(from, test.payments, test.opts, &set)
+
+			var paid Amount
+			for i, payment := range test.payments {
+				script, _ := AddressScript(payment.Address)
+				if out := tx.Outputs[i]; out.Value != payment.Amount || !bytes.Equal(out.Script, script) {
+					t.Errorf("%s: output %d pays %s to %x, want %s to %s", test.name, i, out.Value, out.Script, payment.Amount, payment.Address)
+				}
+				paid += payment.Amount
+			}
+			wantOutputs := len(test.payments)
+			if test.change {
+				wantOutputs++
+			}
+			if len(tx.Outputs) != wantOutputs {
+				t.Fatalf("%s: %d outputs, want %d", test.name, len(tx.Outputs), wantOutputs)
+			}
+
+			prevOuts, err := chain.SpentOutputs(tx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var in, out Amount
+			for _, prevOut := range prevOuts {
+				in += prevOut.Value
+			}
+			for _, o := range tx.Outputs {
+				out += o.Value
+			}
+			fee := in - out
+
+			change, err := SendManyChange(tx, from, test.payments)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.change && change != tx.Outputs[len(test.payments)].Value {
+				t.Errorf("%s: change %s, the change output holds %s", test.name, change, tx.Outputs[len(test.payments)].Value)
+			}
+			if !test.change && change != 0 {
+				t.Errorf("%s: change %s without a change output", test.name, change)
+			}
+			if in != paid+change+fee {
+				t.Errorf("%s: inputs %s, payments %s, change %s and fee %s", test.name, in, paid, change, fee)
+			}
+			switch {
+			case test.opts.Fee != 0 && fee != test.opts.Fee:
+				t.Errorf("%s: fee %s, want %s", test.name, fee, test.opts.Fee)
+			case test.opts.FeeRate != 0 && fee < test.opts.FeeRate.Fee(len(tx.Serialize())):
+				t.Errorf("%s: fee %s below %d per byte of %d bytes", test.name, fee, test.opts.FeeRate, len(tx.Serialize()))
+			}
+
+			entry, err := chain.AddToMempool(tx)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			if entry.Fee != fee {
+				t.Errorf("%s: mempool fee %s, want %s", test.name, entry.Fee, fee)
+			}
+			chain.MineBlock(from)
+		}
+	})
+}
+
+func TestTransactionHex(t *testing.T) {
+	w := MakeWallet(KeyP256)
+	signed, _ := signedTestTx(t, w, SigHashAll)
+	unsigned := signed.TrimmedCopy()
+	data, _ := NewDataOutput([]byte("raw"))
+	unsigned.Outputs = append(unsigned.Outputs, *data)
+	unsigned.LockTime = 1234
+	unsigned.SetID()
+
+	for name, tx := range map[string]*Transaction{"signed": signed, "unsigned": &unsigned} {
+		for _, s := range []string{tx.Hex(), " " + tx.Hex() + "\n", strings.ToUpper(tx.Hex())} {
+			decoded, err := TransactionFromHex(s)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if !bytes.Equal(decoded.Serialize(), tx.Serialize()) || !bytes.Equal(decoded.ID, tx.Hash()) {
+				t.Errorf("%s: decoded to %x, want %x", name, decoded.Serialize(), tx.Serialize())
+			}
+		}
+	}
+
+	valid := signed.Hex()
+	for name, s := range map[string]string{
+		"empty":          "",
+		"odd length":     valid[1:],
+		"not hex":        "zz" + valid[2:],
+		"truncated":      valid[:len(valid)-2],
+		"trailing bytes": valid + "00",
+	} {
+		if _, err := TransactionFromHex(s); err == nil {
+			t.Errorf("%s: decoded", name)
+		}
+	}
+}
+
+func TestRawTransactionThroughMempool(t *testing.T) {
+	chdirTemp(t)
+	wallets, _ := CreateWallets()
+	from := wallets.AddWallet(KeyP256)
+	wallets.SaveFile()
+
+	quiet(t, func() {
+		chain := InitBlockChain(from, false)
+		defer chain.Database.Close()
+		set := UTXOSet{chain}
+		set.Reindex()
+		fromScript, _ := AddressScript(from)
+		coin := set.FindCoins(fromScript)[0]
+
+		// built from explicit inputs and outputs, signed from the hex with the spent outputs
+		// looked up on the chain, and sent as hex
+		raw := Transaction{nil, []TxInput{{coin.TxID, coin.Index, nil, SequenceFinal}},
+			[]TxOutput{*NewTxOutput(coin.Value-5000, string(MakeWallet(KeyP256).Address()))}, 0, TxVersion}
+		raw.SetID()
+		tx, err := TransactionFromHex(raw.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		prevOuts, err := chain.SpentOutputs(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		psbt := &PSBT{Tx: *tx, PrevOutputs: prevOuts, RedeemScripts: make([][]byte, len(prevOuts))}
+		if signed := psbt.Sign(wallets, SigHashAll); signed != 1 {
+			t.Fatalf("signed %d inputs", signed)
+		}
+		tx, err = TransactionFromHex(psbt.Tx.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(tx.ID, raw.ID) {
+			t.Errorf("signing changed the ID to %x from %x", tx.ID, raw.ID)
+		}
+
+		// an ID that is not the hash of the contents is refused
+		forged := *tx
+		forged.ID = bytes.Repeat([]byte{0x42}, 32)
+		if _, err := chain.AddToMempool(&forged); err == nil || !strings.Contains(err.Error(), "does not match") {
+			t.Errorf("forged ID: error %v", err)
+		}
+		entry, err := chain.AddToMempool(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Fee != 5000 {
+			t.Errorf("fee %s, want 5000", entry.Fee)
+		}
+	})
+}
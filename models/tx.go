@@ -2,60 +2,118 @@ package models
 
 import (
 	"bytes"
-	"encoding/gob"
+	"errors"
 	"github.com/bucks-go-wallet/utils"
 )
 
 type TxInput struct {
 	ID        []byte //refer transaction that output inside it
 	Out       int    // how many outputs?
-	Signature []byte // used for the output pubkey
-	PubKey    []byte
+	ScriptSig []byte // unlocking script, pushes what the output's script asks for
+	Sequence  uint32 // relative lock time, SequenceFinal when the input has none
 }
 
 type TxOutput struct {
-	Value      int    //token
-	PubKeyHash []byte //needed to unlock token inside Value field
+	Value  Amount //token
+	Script []byte //locking script, the conditions to spend the token inside Value field
 }
 
+// TxOutputs is a UTXO entry, the unspent outputs of a transaction and their positions in it.
+// Height and Time locate the block holding the transaction for relative lock times: its height
+// and the median time past of the block before it.
 type TxOutputs struct {
 	Outputs []TxOutput
+	Indexes []int
+	Height  int
+	Time    int64
 }
 
-func NewTxOutput(value int, address string) *TxOutput {
+func NewTxOutput(value Amount, address string) *TxOutput {
 	txo := &TxOutput{value, nil}
 	txo.Lock([]byte(address))
 	return txo
 }
 
+// NewDataOutput makes an unspendable output carrying data, which is left out of the UTXO set
+func NewDataOutput(data []byte) (*TxOutput, error) {
+	script, err := DataOutputScript(data)
+	if err != nil {
+		return nil, err
+	}
+	return &TxOutput{0, script}, nil
+}
+
+// UsesKey reports whether the unlocking script ends with the public key, or redeem script,
+// that hashes to pubKeyHash
 func (in *TxInput) UsesKey(pubKeyHash []byte) bool {
-	lockingHash := PublicKeyHash(in.PubKey)
+	elements, err := scriptPushes(in.ScriptSig)
+	if err != nil || len(elements) == 0 {
+		return false
+	}
+	lockingHash := PublicKeyHash(elements[len(elements)-1])
 
 	return bytes.Compare(lockingHash, pubKeyHash) == 0
 }
 
 func (out *TxOutput) Lock(address []byte) {
-	pubKeyHash := utils.Base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4] //remove checksum, version byte
-	out.PubKeyHash = pubKeyHash
+	script, err := AddressScript(string(address))
+	utils.Handle(err)
+	out.Script = script
 }
 
+// IsLockedWithKey reports whether the output is a pay-to-pubkey-hash output for pubKeyHash
 func (out *TxOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Compare(out.PubKeyHash, pubKeyHash) == 0
+	class, hash := scriptHashData(out.Script)
+	return class == PubKeyHashScript && bytes.Compare(hash, pubKeyHash) == 0
+}
+
+// Address returns the address the output pays to, if its script follows an address template
+func (out *TxOutput) Address() (string, error) {
+	class, hash := scriptHashData(out.Script)
+	switch class {
+	case PubKeyHashScript:
+		return string(encodeAddress(version, hash)), nil
+	case ScriptHashScript:
+		return string(encodeAddress(scriptHashVersion, hash)), nil
+	}
+	return "", errors.New("output script has no address")
+}
+
+func (outs *TxOutputs) add(index int, out TxOutput) {
+	outs.Outputs = append(outs.Outputs, out)
+	outs.Indexes = append(outs.Indexes, index)
+}
+
+// output returns the output at index of the transaction, if it is in the entry
+func (outs *TxOutputs) output(index int) (TxOutput, bool) {
+	for i, out := range outs.Outputs {
+		if outs.Indexes[i] == index {
+			return out, true
+		}
+	}
+	return TxOutput{}, false
+}
+
+// Data returns what a data output carries
+func (out *TxOutput) Data() ([]byte, bool) {
+	if ClassifyScript(out.Script) != DataCarrierScript {
+		return nil, false
+	}
+	elements, _ := scriptPushes(out.Script[1:])
+	if len(elements) == 0 {
+		return []byte{}, true
+	}
+	return elements[0], true
 }
 
 func (outs *TxOutputs) Serialize() []byte {
-	var buffer bytes.Buffer
-	encode := gob.NewEncoder(&buffer)
-	err := encode.Encode(outs)
-	utils.Handle(err)
-	return buffer.Bytes()
+	var e encoder
+	encodeOutputs(&e, outs)
+	return e.buf.Bytes()
 }
 
 func DeserializeOutputs(outputs []byte) TxOutputs {
-	var txOutputs TxOutputs
-	decode := gob.NewDecoder(bytes.NewReader(outputs))
-	err := decode.Decode(&txOutputs)
+	txOutputs, err := decodeOutputs(outputs)
 	utils.Handle(err)
 	return txOutputs
 }
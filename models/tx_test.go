@@ -0,0 +1,126 @@
+package models
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestDataOutputLimit(t *testing.T) {
+	for _, size := range []int{0, 1, 75, 76, MaxDataCarrierSize} {
+		data := bytes.Repeat([]byte{0xda}, size)
+		out, err := NewDataOutput(data)
+		if err != nil {
+			t.Errorf("%d bytes: %v", size, err)
+			continue
+		}
+		if class := ClassifyScript(out.Script); class != DataCarrierScript {
+			t.Errorf("%d bytes: classified %s", size, class)
+		}
+		if got, ok := out.Data(); !ok || !bytes.Equal(got, data) {
+			t.Errorf("%d bytes: carries %x, %v", size, got, ok)
+		}
+		if !isUnspendable(out.Script) {
+			t.Errorf("%d bytes: spendable", size)
+		}
+	}
+
+	tooLong := bytes.Repeat([]byte{0xda}, MaxDataCarrierSize+1)
+	if _, err := NewDataOutput(tooLong); err == nil {
+		t.Error("data output over the limit built")
+	}
+	// built by hand it is no data output, though it still can not be spent
+	script := appendPush([]byte{OpReturn}, tooLong)
+	if class := ClassifyScript(script); class != NonStandardScript {
+		t.Errorf("OP_RETURN with %d bytes classified %s", len(tooLong), class)
+	}
+	if _, ok := (&TxOutput{0, script}).Data(); ok {
+		t.Error("OP_RETURN over the limit read as data")
+	}
+	if !isUnspendable(script) {
+		t.Error("OP_RETURN over the limit is spendable")
+	}
+}
+
+func TestDataOutputsUnspendable(t *testing.T) {
+	chdirTemp(t)
+	wallets, _ := CreateWallets()
+	from := wallets.AddWallet(KeyP256)
+	wallets.SaveFile()
+	w := wallets.GetWallet(from)
+	memo := []byte("paid for the data output test")
+
+	quiet(t, func() {
+		chain := InitBlockChain(from, false)
+		defer chain.Database.Close()
+		set := UTXOSet{chain}
+		set.Reindex()
+		fromScript, _ := AddressScript(from)
+		coin := set.FindCoins(fromScript)[0]
+		prevOut := TxOutput{coin.Value, fromScript}
+
+		// a block may not carry an OP_RETURN output over the limit
+		tooLong := TxOutput{0, appendPush([]byte{OpReturn}, bytes.Repeat([]byte{0xda}, MaxDataCarrierSize+1))}
+		tx := Transaction{nil, []TxInput{{coin.TxID, coin.Index, nil, SequenceFinal}},
+			[]TxOutput{*NewTxOutput(coin.Value-1000, from), tooLong}, 0, TxVersion}
+		tx.SetID()
+		tx.Sign(w.PrivateKey, []TxOutput{prevOut}, SigHashAll)
+		if _, err := chain.AddToMempool(&tx); err == nil || !strings.Contains(err.Error(), "not a data output") {
+			t.Errorf("OP_RETURN over the limit: error %v", err)
+		}
+
+		paid := NewTransaction(from, from, UnitsPerCoin, SendOptions{Memo: memo, Fee: 1000}, &set)
+		if _, err := chain.AddToMempool(paid); err != nil {
+			t.Fatal(err)
+		}
+		chain.MineBlock(from)
+		dataIndex := -1
+		for i, out := range paid.Outputs {
+			if data, ok := out.Data(); ok && bytes.Equal(data, memo) {
+				dataIndex = i
+			}
+		}
+		if dataIndex < 0 {
+			t.Fatal("memo has no data output")
+		}
+		if found, _, err := chain.FindData(memo); err != nil || !bytes.Equal(found.ID, paid.ID) {
+			t.Errorf("memo found in %x, %v", found.ID, err)
+		}
+
+		// neither the UTXO set nor a replay of the chain holds the data output
+		if _, ok := set.FindOutput(paid.ID, dataIndex); ok {
+			t.Error("data output in the UTXO set")
+		}
+		key := hex.EncodeToString(paid.ID)
+		for _, outs := range []TxOutputs{utxoEntries(chain)[key], chain.FindUTXO()[key]} {
+			if len(outs.Outputs) != len(paid.Outputs)-1 {
+				t.Errorf("UTXO entry holds %d of the %d outputs", len(outs.Outputs), len(paid.Outputs))
+			}
+			for _, index := range outs.Indexes {
+				if index == dataIndex {
+					t.Error("UTXO entry holds the data output")
+				}
+			}
+		}
+
+		// no unlocking script spends it, nor does a transaction get past the mempool with it
+		dataOut := paid.Outputs[dataIndex]
+		spend := Transaction{nil, []TxInput{{paid.ID, dataIndex, nil, SequenceFinal}},
+			[]TxOutput{*NewTxOutput(0, from)}, 0, TxVersion}
+		spend.SetID()
+		for _, scriptSig := range [][]byte{nil, {Op1}, PushScript(memo)} {
+			spend.Inputs[0].ScriptSig = scriptSig
+			if spend.Verify([]TxOutput{dataOut}) {
+				t.Errorf("unlocking script %x spends the data output", scriptSig)
+			}
+		}
+		spend.Sign(w.PrivateKey, []TxOutput{dataOut}, SigHashAll)
+		if spend.Verify([]TxOutput{dataOut}) {
+			t.Error("signature spends the data output")
+		}
+		if _, err := chain.AddToMempool(&spend); err == nil {
+			t.Error("spend of the data output accepted")
+		}
+	})
+}
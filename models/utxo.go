@@ -2,83 +2,450 @@ package models
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/bucks-go-wallet/utils"
 	"github.com/dgraph-io/badger"
+	"runtime"
+	"time"
 )
 
+// reindexWindow bounds the blocks a reindex prepares ahead of the one it writes
+const reindexWindow = 256
+
 var (
+	// reindexBatchSize bounds the UTXO entries a reindex writes per transaction, far below
+	// badger's transaction limits
+	reindexBatchSize = 10000
+
 	utxoPrefix   = []byte("utxo-")
 	prefixLength = len(utxoPrefix)
+	reindexKey   = []byte("reindex")
+	utxoTipKey   = []byte("utxotip") // hash of the block the UTXO set in badger was written for
 )
 
 type UTXOSet struct {
 	BlockChain *BlockChain
 }
 
+// ReindexProgress reports how far a reindex has come
+type ReindexProgress struct {
+	Height  int // blocks replayed so far
+	Total   int
+	Elapsed time.Duration
+	ETA     time.Duration
+}
+
+// Reindex rebuilds the UTXO set from the blocks, see ReindexWithProgress
 func (set UTXOSet) Reindex() {
-	db := set.BlockChain.Database
-	set.DeleteByPrefix(utxoPrefix)
+	set.ReindexWithProgress(nil)
+}
 
-	UTXOs := set.BlockChain.FindUTXO()
+// ReindexWithProgress rebuilds the UTXO set by replaying the chain forward from the genesis
+// block. Changes are written in bounded batches, each in one transaction together with the
+// height it reached, so an interrupted reindex continues after the last batch when run again.
+// With a UTXO cache the written entries stay in it up to its limit, most spends are of recent
+// outputs and are then served from memory. report, when set, is called after every batch.
+func (set UTXOSet) ReindexWithProgress(report func(ReindexProgress)) {
+	chain := set.BlockChain
+	cache := chain.utxoCache
+
+	chain.writeLock.Lock()
+	defer chain.writeLock.Unlock()
+
+	hashes := chain.BlockHashes()
+	start := set.reindexStart(hashes)
+	if cache != nil {
+		cache.reset()
+	}
 
-	err := db.Update(func(txn *badger.Txn) error {
-		for txID, outputs := range UTXOs {
-			key, err := hex.DecodeString(txID)
-			if err != nil {
-				return err
+	pending := make(map[string]TxOutputs)
+	stored := pendingEntries(chain.Database, utxoPrefix, pending)
+	get := func(txID []byte) (TxOutputs, error) {
+		if _, ok := pending[string(txID)]; !ok && cache != nil {
+			if outs, ok := cache.cached(txID); ok {
+				return outs, nil
 			}
-
-			key = append(utxoPrefix, key...)
-			err = txn.Set(key, outputs.Serialize())
-			utils.Handle(err)
 		}
+		return stored(txID)
+	}
+	put := func(txID []byte, outs TxOutputs) error {
+		pending[string(txID)] = outs
 		return nil
-	})
-	utils.Handle(err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	prepared := set.prepareReindex(hashes, start, stop)
+
+	began := time.Now()
+	for height := start; height < len(hashes); height++ {
+		block := <-<-prepared
+		utils.Handle(block.err)
+		for _, changes := range block.changes {
+			utils.Handle(applyChanges(changes, get, put))
+		}
+
+		last := height == len(hashes)-1
+		if len(pending) < reindexBatchSize && !last {
+			continue
+		}
+		utils.Handle(set.writeReindexBatch(pending, height, hashes[height], last))
+		if cache != nil {
+			cache.keepWritten(pending, hashes[height])
+		}
+		for txID := range pending {
+			delete(pending, txID)
+		}
+
+		if report != nil {
+			elapsed := time.Since(began)
+			done := height + 1 - start
+			report(ReindexProgress{
+				Height:  height + 1,
+				Total:   len(hashes),
+				Elapsed: elapsed,
+				ETA:     elapsed / time.Duration(done) * time.Duration(len(hashes)-height-1),
+			})
+		}
+	}
 }
 
-func (set *UTXOSet) Update(block *Block) {
-	db := set.BlockChain.Database
+// reindexBlock holds the UTXO changes of one block, in transaction order
+type reindexBlock struct {
+	changes []utxoChanges
+	err     error
+}
 
-	err := db.Update(func(txn *badger.Txn) error {
-
-		for _, tx := range block.Transactions {
-			if tx.IsCoinbase() == false {
-				for _, in := range tx.Inputs {
-					updatedOuts := TxOutputs{}
-					inID := append(utxoPrefix, in.ID...)
-					item, err := txn.Get(inID)
-					utils.Handle(err)
-					v, err := item.ValueCopy(nil)
-					utils.Handle(err)
-					outs := DeserializeOutputs(v)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != in.Out {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
-					if len(updatedOuts.Outputs) == 0 {
-						utils.Handle(txn.Delete(inID))
-					} else {
-						utils.Handle(txn.Set(inID, updatedOuts.Serialize()))
-					}
+// prepareReindex reads the blocks from start on and works out their UTXO changes on as many
+// workers as there are CPUs. Reading and decoding a block and the ones before it for its median
+// time past is most of a reindex, applying the changes needs the set as the blocks before left
+// it and stays with one writer. The channel gives a channel per block in height order, at most
+// reindexWindow blocks ahead of the writer. Closing stop ends the workers.
+func (set UTXOSet) prepareReindex(hashes [][]byte, start int, stop <-chan struct{}) <-chan chan reindexBlock {
+	chain := set.BlockChain
+	type job struct {
+		hash   []byte
+		result chan reindexBlock
+	}
+	jobs := make(chan job)
+	ordered := make(chan chan reindexBlock, reindexWindow)
+
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		go func() {
+			for j := range jobs {
+				block, err := chain.GetBlock(j.hash)
+				if err != nil {
+					j.result <- reindexBlock{err: err}
+					continue
+				}
+				mtp := chain.MedianTimePast(block.PrevHash)
+				var prepared reindexBlock
+				for _, tx := range block.Transactions {
+					prepared.changes = append(prepared.changes, txChanges(tx, block.Height, mtp))
 				}
+				j.result <- prepared
 			}
-			newOutputs := TxOutputs{}
-			for _, out := range tx.Outputs {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for height := start; height < len(hashes); height++ {
+			result := make(chan reindexBlock, 1)
+			select {
+			case ordered <- result:
+			case <-stop:
+				return
 			}
-			txID := append(utxoPrefix, tx.ID...)
-			utils.Handle(txn.Set(txID, newOutputs.Serialize()))
+			select {
+			case jobs <- job{hashes[height], result}:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return ordered
+}
+
+// utxoTip returns the hash of the block the UTXO set in badger was written for, nil while it is
+// being rebuilt
+func (bc *BlockChain) utxoTip() ([]byte, error) {
+	var tip []byte
+	err := bc.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(utxoTipKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
 		}
+		if err != nil {
+			return err
+		}
+		tip, err = item.ValueCopy(nil)
+		return err
+	})
+	return tip, err
+}
+
+// catchUpUTXOSet brings the UTXO set to the chain tip when it was written for an earlier block,
+// as after a crash that lost the changes held in a UTXO cache. The blocks after the one the set
+// was written for are replayed. When that block is not on the chain, or replaying fails, the set
+// is rebuilt from the genesis block, which a chain loaded from a snapshot cannot do.
+func (bc *BlockChain) catchUpUTXOSet() error {
+	tip := bc.Tip()
+	if len(tip) == 0 {
 		return nil
+	}
+	setTip, err := bc.utxoTip()
+	if err != nil || bytes.Equal(setTip, tip) {
+		return err
+	}
+
+	if setTip != nil {
+		if err = bc.replayUTXO(setTip, tip); err == nil {
+			return nil
+		}
+	}
+	if _, snapErr := bc.Snapshot(); !errors.Is(snapErr, ErrNoSnapshot) {
+		return fmt.Errorf("the UTXO set is behind the tip %x and a chain loaded from a snapshot cannot rebuild it: %v", tip, err)
+	}
+	fmt.Println("The UTXO set is behind the chain tip, rebuilding it")
+	UTXOSet{bc}.Reindex()
+	return nil
+}
+
+// replayUTXO applies the blocks after from up to tip to the UTXO set, each in its own transaction
+func (bc *BlockChain) replayUTXO(from, tip []byte) error {
+	var hashes [][]byte
+	for hash := tip; !bytes.Equal(hash, from); {
+		if len(hash) == 0 {
+			return fmt.Errorf("block %x the UTXO set was written for is not on the chain", from)
+		}
+		hashes = append(hashes, hash)
+
+		prevHash, err := bc.prevHash(hash)
+		if err != nil {
+			return err
+		}
+		hash = prevHash
+	}
+
+	set := UTXOSet{bc}
+	for i := len(hashes) - 1; i >= 0; i-- {
+		block, err := bc.GetBlock(hashes[i])
+		if err != nil {
+			return err
+		}
+		err = bc.Database.Update(func(txn *badger.Txn) error {
+			return set.update(txn, block)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pendingEntries returns a get for applyChanges that reads the entries collected in pending
+// first and the ones stored under prefix after them. Callers clear pending once it is written
+// rather than replace it, get keeps reading the same map.
+func pendingEntries(db *badger.DB, prefix []byte, pending map[string]TxOutputs) func(txID []byte) (TxOutputs, error) {
+	return func(txID []byte) (TxOutputs, error) {
+		if outs, ok := pending[string(txID)]; ok {
+			if len(outs.Outputs) == 0 {
+				return outs, fmt.Errorf("utxo %x: %w", txID, badger.ErrKeyNotFound)
+			}
+			return outs, nil
+		}
+
+		var outs TxOutputs
+		err := db.View(func(txn *badger.Txn) error {
+			item, err := txn.Get(append(prefix, txID...))
+			if err != nil {
+				return err
+			}
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			outs, err = decodeOutputs(v)
+			return err
+		})
+		if err != nil {
+			return outs, fmt.Errorf("utxo %x: %w", txID, err)
+		}
+		return outs, nil
+	}
+}
+
+// writeEntries stores the collected entries under prefix, deleting the ones without outputs
+func writeEntries(txn *badger.Txn, prefix []byte, pending map[string]TxOutputs) error {
+	for txID, outs := range pending {
+		key := append(append([]byte{}, prefix...), txID...)
+		var err error
+		if len(outs.Outputs) == 0 {
+			err = txn.Delete(key)
+		} else {
+			err = txn.Set(key, outs.Serialize())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reindexStart returns the height to replay from. Without a usable progress marker the UTXO
+// set is cleared and the replay starts at the genesis block.
+func (set UTXOSet) reindexStart(hashes [][]byte) int {
+	var marker []byte
+	err := set.BlockChain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(reindexKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		marker, err = item.ValueCopy(nil)
+		return err
 	})
 	utils.Handle(err)
 
+	if len(marker) > 8 {
+		height := int(binary.BigEndian.Uint64(marker[:8]))
+		if height < len(hashes) && bytes.Equal(hashes[height], marker[8:]) {
+			return height + 1
+		}
+	}
+
+	err = set.BlockChain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Delete(utxoTipKey)
+	})
+	utils.Handle(err)
+	set.DeleteByPrefix(utxoPrefix)
+	return 0
+}
+
+// writeReindexBatch writes the collected UTXO changes and the progress marker in one
+// transaction. The marker is removed with the last batch, which records the block the set was
+// written for.
+func (set UTXOSet) writeReindexBatch(pending map[string]TxOutputs, height int, hash []byte, last bool) error {
+	return set.BlockChain.Database.Update(func(txn *badger.Txn) error {
+		if err := writeEntries(txn, utxoPrefix, pending); err != nil {
+			return err
+		}
+
+		if last {
+			if err := txn.Set(utxoTipKey, hash); err != nil {
+				return err
+			}
+			return txn.Delete(reindexKey)
+		}
+		marker := make([]byte, 8, 8+len(hash))
+		binary.BigEndian.PutUint64(marker, uint64(height))
+		return txn.Set(reindexKey, append(marker, hash...))
+	})
+}
+
+// update applies the outputs spent and created by the block inside an open transaction
+func (set *UTXOSet) update(txn *badger.Txn, block *Block) error {
+	get := func(txID []byte) (TxOutputs, error) {
+		item, err := txn.Get(append(utxoPrefix, txID...))
+		if err != nil {
+			return TxOutputs{}, err
+		}
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return TxOutputs{}, err
+		}
+		return decodeOutputs(v)
+	}
+	put := func(txID []byte, outs TxOutputs) error {
+		if len(outs.Outputs) == 0 {
+			return txn.Delete(append(utxoPrefix, txID...))
+		}
+		return txn.Set(append(utxoPrefix, txID...), outs.Serialize())
+	}
+
+	if err := applyBlock(block, set.BlockChain.MedianTimePast(block.PrevHash), get, put); err != nil {
+		return err
+	}
+	return txn.Set(utxoTipKey, block.Hash)
+}
+
+// applyBlock works out the UTXO entries changed by the block, on top of a chain with median time
+// past mtp. get loads the current entry of a transaction and put stores its new one, where an
+// entry without outputs means all were spent.
+func applyBlock(block *Block, mtp int64, get func(txID []byte) (TxOutputs, error), put func(txID []byte, outs TxOutputs) error) error {
+	for _, tx := range block.Transactions {
+		if err := applyTransaction(tx, block.Height, mtp, get, put); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// applyTransaction is applyBlock for one transaction of a block at height. Spending an output
+// the entry does not hold is an error.
+func applyTransaction(tx *Transaction, height int, mtp int64, get func(txID []byte) (TxOutputs, error), put func(txID []byte, outs TxOutputs) error) error {
+	return applyChanges(txChanges(tx, height, mtp), get, put)
+}
+
+// utxoChanges is what one transaction does to the UTXO set: the outputs it spends and the entry
+// of the outputs it creates. It is worked out without the set, so a reindex can prepare the
+// changes of many blocks at once.
+type utxoChanges struct {
+	tx      *Transaction
+	spends  []TxInput
+	created TxOutputs
+}
+
+// txChanges returns the changes of tx in a block at height on top of a chain with median time
+// past mtp
+func txChanges(tx *Transaction, height int, mtp int64) utxoChanges {
+	changes := utxoChanges{tx: tx, created: TxOutputs{Height: height, Time: mtp}}
+	if !tx.IsCoinbase() {
+		changes.spends = tx.Inputs
+	}
+	for outIdx, out := range tx.Outputs {
+		if !isUnspendable(out.Script) {
+			changes.created.add(outIdx, out)
+		}
+	}
+	return changes
+}
+
+// applyChanges removes the spent outputs from their entries and adds the created ones
+func applyChanges(changes utxoChanges, get func(txID []byte) (TxOutputs, error), put func(txID []byte, outs TxOutputs) error) error {
+	for _, in := range changes.spends {
+		outs, err := get(in.ID)
+		if err != nil {
+			return err
+		}
+		if _, ok := outs.output(in.Out); !ok {
+			return fmt.Errorf("transaction %x spends %x:%d, which is not unspent", changes.tx.ID, in.ID, in.Out)
+		}
+
+		updatedOuts := TxOutputs{Height: outs.Height, Time: outs.Time}
+		for i, out := range outs.Outputs {
+			if outs.Indexes[i] != in.Out {
+				updatedOuts.add(outs.Indexes[i], out)
+			}
+		}
+		if err := put(in.ID, updatedOuts); err != nil {
+			return err
+		}
+	}
+
+	if len(changes.created.Outputs) == 0 {
+		return nil
+	}
+	return put(changes.tx.ID, changes.created)
+}
+
+// DeleteByPrefix removes every key with the prefix. Callers hold the chain write lock.
 func (set *UTXOSet) DeleteByPrefix(prefix []byte) {
 	deleteKeys := func(keysForDelete [][]byte) error {
 		if err := set.BlockChain.Database.Update(func(txn *badger.Txn) error {
@@ -94,7 +461,7 @@ func (set *UTXOSet) DeleteByPrefix(prefix []byte) {
 		return nil
 	}
 
-	collectSize := 100000
+	collectSize := reindexBatchSize
 	set.BlockChain.Database.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		opts.PrefetchValues = false
@@ -105,13 +472,12 @@ func (set *UTXOSet) DeleteByPrefix(prefix []byte) {
 		keysCollected := 0
 
 		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			key, err := it.Item().ValueCopy(nil)
-			utils.Handle(err)
+			key := it.Item().KeyCopy(nil)
 			keysForDelete = append(keysForDelete, key)
 			keysCollected++
 
 			if keysCollected == collectSize {
-				err = deleteKeys(keysForDelete)
+				err := deleteKeys(keysForDelete)
 				utils.Handle(err)
 				keysForDelete = make([][]byte, 0, collectSize)
 				keysCollected = 0
@@ -125,10 +491,42 @@ func (set *UTXOSet) DeleteByPrefix(prefix []byte) {
 	})
 }
 
-func (set UTXOSet) CountTransactions() int {
+// forEach calls fn for every UTXO entry in key order
+func (set UTXOSet) forEach(fn func(txID []byte, outs TxOutputs)) {
+	if set.BlockChain.utxoCache != nil {
+		set.BlockChain.utxoCache.forEach(fn)
+		return
+	}
+
 	db := set.BlockChain.Database
+	err := db.View(func(txn *badger.Txn) error {
+
+		opts := badger.DefaultIteratorOptions
+		it := txn.NewIterator(opts)
+
+		defer it.Close()
+		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+			item := it.Item()
+			v, err := item.ValueCopy(nil)
+			utils.Handle(err)
+			fn(bytes.TrimPrefix(item.KeyCopy(nil), utxoPrefix), DeserializeOutputs(v))
+		}
+		return nil
+	})
+	utils.Handle(err)
+}
+
+func (set UTXOSet) CountTransactions() int {
 	counter := 0
 
+	if set.BlockChain.utxoCache != nil {
+		set.forEach(func(txID []byte, outs TxOutputs) {
+			counter++
+		})
+		return counter
+	}
+
+	db := set.BlockChain.Database
 	err := db.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 
@@ -144,65 +542,158 @@ func (set UTXOSet) CountTransactions() int {
 	return counter
 }
 
-func (set UTXOSet) FindUnspentTransactions(pubkeyHash []byte) []TxOutput {
+// FindUnspentTransactions returns the unspent outputs locked by script
+func (set UTXOSet) FindUnspentTransactions(script []byte) []TxOutput {
 	var UTXOs []TxOutput
 
-	db := set.BlockChain.Database
-	err := db.View(func(txn *badger.Txn) error {
-
-		opts := badger.DefaultIteratorOptions
-		it := txn.NewIterator(opts)
-
-		defer it.Close()
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+	set.forEach(func(txID []byte, outs TxOutputs) {
+		for _, out := range outs.Outputs {
+			if bytes.Equal(out.Script, script) {
+				UTXOs = append(UTXOs, out)
+			}
+		}
+	})
+	return UTXOs
+}
 
-			item := it.Item()
-			v, err := item.ValueCopy(nil)
-			utils.Handle(err)
-			outs := DeserializeOutputs(v)
+// FindCoins returns the unspent outputs locked by script as coins, in UTXO set order
+func (set *UTXOSet) FindCoins(script []byte) []Coin {
+	var coins []Coin
 
-			for _, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubkeyHash) {
-					UTXOs = append(UTXOs, out)
-				}
+	set.forEach(func(txID []byte, outs TxOutputs) {
+		for i, out := range outs.Outputs {
+			if bytes.Equal(out.Script, script) {
+				coins = append(coins, Coin{append([]byte{}, txID...), outs.Indexes[i], out.Value})
 			}
 		}
-		return nil
 	})
+	return coins
+}
+
+// FindOutput returns output index of transaction txID if it is unspent
+func (set UTXOSet) FindOutput(txID []byte, index int) (TxOutput, bool) {
+	outs, err := set.entry(txID)
+	if err == badger.ErrKeyNotFound {
+		return TxOutput{}, false
+	}
 	utils.Handle(err)
-	return UTXOs
+
+	return outs.output(index)
 }
 
-func (set *UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
-	unspentOuts := make(map[string][]int)
-	accumulated := 0
+// entry returns the UTXO entry of transaction txID, badger.ErrKeyNotFound when it has no
+// unspent outputs
+func (set UTXOSet) entry(txID []byte) (TxOutputs, error) {
+	if set.BlockChain.utxoCache != nil {
+		return set.BlockChain.utxoCache.get(txID)
+	}
 
-	db := set.BlockChain.Database
-	err := db.View(func(txn *badger.Txn) error {
+	var outs TxOutputs
+	err := set.BlockChain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(append(utxoPrefix, txID...))
+		if err != nil {
+			return err
+		}
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		outs, err = decodeOutputs(v)
+		return err
+	})
+	return outs, err
+}
 
-		opts := badger.DefaultIteratorOptions
-		it := txn.NewIterator(opts)
+// spentOutput is an unspent output an input spends, with the height and time of its UTXO entry
+type spentOutput struct {
+	TxOutput
+	height int
+	time   int64
+}
 
-		defer it.Close()
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			item := it.Item()
-			k := item.Key()
-			v, err := item.ValueCopy(nil)
-			utils.Handle(err)
-			k = bytes.TrimPrefix(k, utxoPrefix)
-			txID := hex.EncodeToString(k)
-			outs := DeserializeOutputs(v)
-
-			for outIdx, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
-					accumulated += out.Value
-					unspentOuts[txID] = append(unspentOuts[txID], outIdx)
-				}
+// utxoView is the UTXO set with the changes of the transactions applied to it so far, so the
+// transactions of a block can be checked in order: a transaction may spend outputs of the ones
+// before it, and an output spent by one of them is gone for the ones after it.
+type utxoView struct {
+	set     UTXOSet
+	changed map[string]TxOutputs // entries written by apply, without outputs when all are spent
+}
+
+func newUTXOView(set UTXOSet) *utxoView {
+	return &utxoView{set, make(map[string]TxOutputs)}
+}
+
+// get returns the entry of transaction txID, badger.ErrKeyNotFound when it has no unspent
+// outputs
+func (v *utxoView) get(txID []byte) (TxOutputs, error) {
+	outs, ok := v.changed[string(txID)]
+	if !ok {
+		return v.set.entry(txID)
+	}
+	if len(outs.Outputs) == 0 {
+		return TxOutputs{}, badger.ErrKeyNotFound
+	}
+	return outs, nil
+}
+
+func (v *utxoView) put(txID []byte, outs TxOutputs) error {
+	v.changed[string(txID)] = outs
+	return nil
+}
+
+// spentOutputs looks up the outputs the inputs of tx spend, in input order. Each input must
+// spend a different unspent output.
+func (v *utxoView) spentOutputs(tx *Transaction) ([]spentOutput, error) {
+	var spent []spentOutput
+	seen := make(map[string]bool)
+	for inId, in := range tx.Inputs {
+		key := outpointKey(in.ID, in.Out)
+		if seen[key] {
+			return nil, fmt.Errorf("transaction %x input %d spends %x:%d a second time", tx.ID, inId, in.ID, in.Out)
+		}
+		seen[key] = true
+
+		outs, err := v.get(in.ID)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return nil, err
+		}
+		out, ok := outs.output(in.Out)
+		if !ok {
+			return nil, fmt.Errorf("transaction %x input %d spends %x:%d, which is not unspent", tx.ID, inId, in.ID, in.Out)
+		}
+		spent = append(spent, spentOutput{out, outs.Height, outs.Time})
+	}
+	return spent, nil
+}
+
+// apply spends the outputs tx spends and adds the ones it creates, for a block at height on top
+// of a chain with median time past mtp. A transaction whose ID still has unspent outputs would
+// replace them and is refused.
+func (v *utxoView) apply(tx *Transaction, height int, mtp int64) error {
+	if _, err := v.get(tx.ID); err != badger.ErrKeyNotFound {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("transaction %x already has unspent outputs", tx.ID)
+	}
+	return applyTransaction(tx, height, mtp, v.get, v.put)
+}
+
+// FindSpendableOutputs collects outputs locked by script until they add up to amount
+func (set *UTXOSet) FindSpendableOutputs(script []byte, amount Amount) (Amount, map[string][]int) {
+	unspentOuts := make(map[string][]int)
+	var accumulated Amount
+
+	set.forEach(func(k []byte, outs TxOutputs) {
+		txID := hex.EncodeToString(k)
+
+		for i, out := range outs.Outputs {
+			if bytes.Equal(out.Script, script) && accumulated < amount {
+				accumulated += out.Value
+				unspentOuts[txID] = append(unspentOuts[txID], outs.Indexes[i])
 			}
 		}
-		return nil
 	})
-	utils.Handle(err)
 
 	return accumulated, unspentOuts
 }
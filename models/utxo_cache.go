@@ -0,0 +1,334 @@
+package models
+
+import (
+	"bytes"
+	"github.com/bucks-go-wallet/utils"
+	"github.com/dgraph-io/badger"
+	"sort"
+	"sync"
+)
+
+// entryOverhead approximates the map and struct cost of a cached entry on top of its data
+const entryOverhead = 96
+
+// UTXOCache keeps UTXO entries in memory in front of badger. Lookups are served from memory,
+// block updates are collected in memory and written in one WriteBatch when the cache grows past
+// its limit or on Flush. Each flush records the block the set was written for, changes that were
+// not flushed when the process dies are replayed from the blocks when the chain is opened again.
+type UTXOCache struct {
+	lock     sync.Mutex
+	db       *badger.DB
+	limit    int
+	size     int
+	entries  map[string]*cacheEntry
+	complete bool   // every entry in badger is also in entries, scans need not touch badger
+	tip      []byte // hash of the last block applied, written to badger on flush
+}
+
+type cacheEntry struct {
+	outs    TxOutputs
+	dirty   bool // differs from badger
+	deleted bool // all outputs spent, to be removed from badger
+}
+
+func newUTXOCache(db *badger.DB, limit int) *UTXOCache {
+	return &UTXOCache{db: db, limit: limit, entries: make(map[string]*cacheEntry)}
+}
+
+func entrySize(key string, outs TxOutputs) int {
+	size := entryOverhead + len(key)
+	for _, out := range outs.Outputs {
+		size += 12 + len(out.Script)
+	}
+	return size
+}
+
+// EnableUTXOCache puts a cache of at most limit bytes in front of the UTXO set. Call it before
+// other goroutines start using the chain.
+func (bc *BlockChain) EnableUTXOCache(limit int) {
+	bc.utxoCache = newUTXOCache(bc.Database, limit)
+}
+
+// FlushUTXOCache writes the pending UTXO changes to badger, call it before closing the database
+func (bc *BlockChain) FlushUTXOCache() error {
+	if bc.utxoCache == nil {
+		return nil
+	}
+
+	bc.writeLock.Lock()
+	defer bc.writeLock.Unlock()
+
+	return bc.utxoCache.flush()
+}
+
+func (c *UTXOCache) put(key string, entry *cacheEntry) {
+	if old, ok := c.entries[key]; ok {
+		c.size -= entrySize(key, old.outs)
+	}
+	c.entries[key] = entry
+	c.size += entrySize(key, entry.outs)
+}
+
+// fetch returns the entry for a transaction ID, loading it from badger on a miss
+func (c *UTXOCache) fetch(txn *badger.Txn, key string) (*cacheEntry, error) {
+	if entry, ok := c.entries[key]; ok {
+		if entry.deleted {
+			return nil, badger.ErrKeyNotFound
+		}
+		return entry, nil
+	}
+	if c.complete {
+		return nil, badger.ErrKeyNotFound
+	}
+
+	item, err := txn.Get(append(utxoPrefix, key...))
+	if err != nil {
+		return nil, err
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	outs, err := decodeOutputs(v)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &cacheEntry{outs: outs}
+	c.put(key, entry)
+	return entry, nil
+}
+
+// get returns the entry of a transaction ID
+func (c *UTXOCache) get(txID []byte) (TxOutputs, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var outs TxOutputs
+	err := c.db.View(func(txn *badger.Txn) error {
+		entry, err := c.fetch(txn, string(txID))
+		if err != nil {
+			return err
+		}
+		outs = entry.outs
+		return nil
+	})
+	return outs, err
+}
+
+// stage records the entries a block changed, as worked out by a utxoView, and returns a function
+// that puts the cache back as it was, for when the block fails to be stored
+func (c *UTXOCache) stage(block *Block, changed map[string]TxOutputs) (rollback func()) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	old := make(map[string]*cacheEntry, len(changed))
+	size, tip := c.size, c.tip
+	for key, outs := range changed {
+		old[key] = c.entries[key]
+		c.put(key, &cacheEntry{outs, true, len(outs.Outputs) == 0})
+	}
+	c.tip = block.Hash
+
+	return func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+
+		for key, entry := range old {
+			if entry == nil {
+				delete(c.entries, key)
+			} else {
+				c.entries[key] = entry
+			}
+		}
+		c.size, c.tip = size, tip
+	}
+}
+
+// trim flushes when the cache is over its limit
+func (c *UTXOCache) trim() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.size > c.limit {
+		return c.flushLocked()
+	}
+	return nil
+}
+
+func (c *UTXOCache) flush() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.flushLocked()
+}
+
+// flushLocked writes dirty entries in one WriteBatch, followed by the tip they were written for,
+// then drops clean entries while the cache is still over its limit
+func (c *UTXOCache) flushLocked() error {
+	wb := c.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for key, entry := range c.entries {
+		if !entry.dirty {
+			continue
+		}
+		var err error
+		if entry.deleted {
+			err = wb.Delete(append(utxoPrefix, key...))
+		} else {
+			err = wb.Set(append(utxoPrefix, key...), entry.outs.Serialize())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if c.tip != nil {
+		if err := wb.Set(utxoTipKey, c.tip); err != nil {
+			return err
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return err
+	}
+
+	for key, entry := range c.entries {
+		if entry.deleted {
+			c.size -= entrySize(key, entry.outs)
+			delete(c.entries, key)
+			continue
+		}
+		entry.dirty = false
+	}
+
+	if c.size > c.limit {
+		c.entries = make(map[string]*cacheEntry)
+		c.size = 0
+		c.complete = false
+	}
+	return nil
+}
+
+// keepWritten puts entries a reindex just wrote to badger, as the blocks up to tip left them,
+// into the cache as clean entries. Entries without outputs were deleted. Once the cache is over
+// its limit it starts over empty.
+func (c *UTXOCache) keepWritten(written map[string]TxOutputs, tip []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for key, outs := range written {
+		if old, ok := c.entries[key]; ok {
+			c.size -= entrySize(key, old.outs)
+			delete(c.entries, key)
+		}
+		if len(outs.Outputs) > 0 {
+			c.put(key, &cacheEntry{outs: outs})
+		}
+	}
+	if c.size > c.limit {
+		c.entries = make(map[string]*cacheEntry)
+		c.size = 0
+	}
+	c.complete = false
+	c.tip = tip
+}
+
+// cached returns the entry of a transaction ID when it is in memory, without reading badger
+func (c *UTXOCache) cached(txID []byte) (TxOutputs, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.entries[string(txID)]
+	if !ok || entry.deleted {
+		return TxOutputs{}, false
+	}
+	return entry.outs, true
+}
+
+// reset drops every entry, used after the UTXO set was rewritten in badger directly
+func (c *UTXOCache) reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries = make(map[string]*cacheEntry)
+	c.size = 0
+	c.complete = false
+	c.tip = nil
+}
+
+// forEach calls fn for every UTXO entry in key order, merging pending changes over badger.
+// A full scan that fits in the limit marks the cache complete so later scans stay in memory.
+func (c *UTXOCache) forEach(fn func(txID []byte, outs TxOutputs)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.complete {
+		keys := make([]string, 0, len(c.entries))
+		for key, entry := range c.entries {
+			if !entry.deleted {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fn([]byte(key), c.entries[key].outs)
+		}
+		return
+	}
+
+	// Entries created since the last flush are not in badger yet
+	var pending []string
+	for key, entry := range c.entries {
+		if entry.dirty && !entry.deleted {
+			pending = append(pending, key)
+		}
+	}
+	sort.Strings(pending)
+
+	fits := true
+	err := c.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+			key := string(bytes.TrimPrefix(it.Item().Key(), utxoPrefix))
+
+			for len(pending) > 0 && pending[0] < key {
+				fn([]byte(pending[0]), c.entries[pending[0]].outs)
+				pending = pending[1:]
+			}
+			if len(pending) > 0 && pending[0] == key {
+				pending = pending[1:]
+			}
+
+			if entry, ok := c.entries[key]; ok {
+				if !entry.deleted {
+					fn([]byte(key), entry.outs)
+				}
+				continue
+			}
+
+			v, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			outs, err := decodeOutputs(v)
+			if err != nil {
+				return err
+			}
+			if c.size+entrySize(key, outs) <= c.limit {
+				c.put(key, &cacheEntry{outs: outs})
+			} else {
+				fits = false
+			}
+			fn([]byte(key), outs)
+		}
+		return nil
+	})
+	utils.Handle(err)
+
+	for _, key := range pending {
+		fn([]byte(key), c.entries[key].outs)
+	}
+	c.complete = fits
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+// utxoEntries reads the UTXO set in badger
+func utxoEntries(chain *BlockChain) map[string]TxOutputs {
+	entries := make(map[string]TxOutputs)
+	UTXOSet{chain}.forEach(func(txID []byte, outs TxOutputs) {
+		entries[hex.EncodeToString(txID)] = outs
+	})
+	return entries
+}
+
+// useReindexBatchSize sets reindexBatchSize for the rest of the test
+func useReindexBatchSize(tb testing.TB, size int) {
+	saved := reindexBatchSize
+	reindexBatchSize = size
+	tb.Cleanup(func() { reindexBatchSize = saved })
+}
+
+func TestReindexMatchesChain(t *testing.T) {
+	benchmarkBlocks(t)
+
+	quiet(t, func() {
+		chain := OpenBlockChain()
+		defer chain.Database.Close()
+		set := UTXOSet{chain}
+		built := utxoEntries(chain)
+
+		// small batches spend outputs written by earlier ones, the small cache starts over
+		// several times
+		for _, test := range []struct{ batch, cache int }{{reindexBatchSize, 0}, {5, 0}, {5, 64 << 20}, {5, 16 << 10}} {
+			useReindexBatchSize(t, test.batch)
+			chain.utxoCache = nil
+			if test.cache > 0 {
+				chain.EnableUTXOCache(test.cache)
+			}
+			set.Reindex()
+			got := utxoEntries(chain)
+			if want := chain.FindUTXO(); !reflect.DeepEqual(got, want) {
+				t.Errorf("%+v: reindexed UTXO set has %d entries, the chain %d", test, len(got), len(want))
+			}
+			if !reflect.DeepEqual(got, built) {
+				t.Errorf("%+v: reindexed UTXO set has %d entries, the one built block by block %d", test, len(got), len(built))
+			}
+			if tip, err := chain.utxoTip(); err != nil || !reflect.DeepEqual(tip, chain.Tip()) {
+				t.Errorf("%+v: UTXO tip %x, %v, want %x", test, tip, err, chain.Tip())
+			}
+			if err := chain.FlushUTXOCache(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkReindex(b *testing.B) {
+	b.Run("badger", func(b *testing.B) { benchmarkReindex(b, 0) })
+	b.Run("cache", func(b *testing.B) { benchmarkReindex(b, 64<<20) })
+}
+
+// benchmarkReindex reindexes a chain of its own, in batches of a few blocks as on a chain many
+// times longer than the batch size
+func benchmarkReindex(b *testing.B, cacheSize int) {
+	benchmarkBlocks(b)
+	useReindexBatchSize(b, 4*benchTxsPerBlock)
+
+	quiet(b, func() {
+		chain := OpenBlockChain()
+		defer chain.Database.Close()
+		if cacheSize > 0 {
+			chain.EnableUTXOCache(cacheSize)
+		}
+		set := UTXOSet{chain}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			set.Reindex()
+		}
+	})
+}
@@ -2,28 +2,31 @@ package models
 
 import (
 	"bytes"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/sha256"
+	"fmt"
 	"github.com/bucks-go-wallet/utils"
 	"golang.org/x/crypto/ripemd160"
 )
 
 const (
-	checksumLength = 4
-	version        = byte(0x00)
+	checksumLength    = 4
+	version           = byte(0x00) // pay-to-pubkey-hash addresses
+	scriptHashVersion = byte(0x05) // pay-to-script-hash addresses
 )
 
 type Wallet struct {
-	PrivateKey ecdsa.PrivateKey
+	PrivateKey Signer
 	PublicKey  []byte
 }
 
 func (w Wallet) Address() []byte {
 	pubHash := PublicKeyHash(w.PublicKey)
 
-	versionedHash := append([]byte{version}, pubHash...)
+	return encodeAddress(version, pubHash)
+}
+
+func encodeAddress(addrVersion byte, hash []byte) []byte {
+	versionedHash := append([]byte{addrVersion}, hash...)
 	checksum := Checksum(versionedHash)
 
 	fullHash := append(versionedHash, checksum...)
@@ -32,18 +35,39 @@ func (w Wallet) Address() []byte {
 	return address
 }
 
-func NewKeyPair() (ecdsa.PrivateKey, []byte) {
-	curve := elliptic.P256()
+func NewKeyPair(keyType KeyType) (Signer, []byte) {
+	private := NewSigner(keyType)
+	return private, private.Public().Bytes()
+}
 
-	private, err := ecdsa.GenerateKey(curve, rand.Reader)
-	utils.Handle(err)
+// GobEncode stores the private key as its P-256 scalar or Ed25519 seed, the key type follows
+// from the public key
+func (w Wallet) GobEncode() ([]byte, error) {
+	var e encoder
+	e.putBytes(signerBytes(w.PrivateKey))
+	e.putBytes(w.PublicKey)
+	return e.buf.Bytes(), nil
+}
 
-	pub := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
-	return *private, pub
+func (w *Wallet) GobDecode(data []byte) error {
+	d := decoder{data: data}
+	private := d.bytes()
+	w.PublicKey = d.bytes()
+	if err := d.finish(); err != nil {
+		return err
+	}
+
+	keyType := KeyP256
+	if len(w.PublicKey) == pubKeySize && w.PublicKey[0] == ed25519KeyPrefix {
+		keyType = KeyEd25519
+	}
+	var err error
+	w.PrivateKey, err = signerFromBytes(keyType, private)
+	return err
 }
 
-func MakeWallet() *Wallet {
-	private, public := NewKeyPair()
+func MakeWallet(keyType KeyType) *Wallet {
+	private, public := NewKeyPair(keyType)
 	return &Wallet{private, public}
 }
 
@@ -65,11 +89,32 @@ func Checksum(payload []byte) []byte {
 }
 
 func ValidateAddress(address string) bool {
-	pubKeyHash := utils.Base58Decode([]byte(address))
+	_, err := AddressScript(address)
+	return err == nil
+}
+
+// AddressScript returns the locking script that pays to an address
+func AddressScript(address string) ([]byte, error) {
+	pubKeyHash, err := utils.Base58Decode([]byte(address))
+	if err != nil {
+		return nil, fmt.Errorf("address %q is not base58: %w", address, err)
+	}
+	if len(pubKeyHash) != 1+20+checksumLength {
+		return nil, fmt.Errorf("address %q has the wrong length", address)
+	}
 	actualChecksum := pubKeyHash[len(pubKeyHash)-checksumLength:]
-	version := pubKeyHash[0]
+	addrVersion := pubKeyHash[0]
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-checksumLength]
-	targetChecksum := Checksum(append([]byte{version}, pubKeyHash...))
-
-	return bytes.Compare(actualChecksum, targetChecksum) == 0
+	targetChecksum := Checksum(append([]byte{addrVersion}, pubKeyHash...))
+
+	if bytes.Compare(actualChecksum, targetChecksum) != 0 {
+		return nil, fmt.Errorf("address %q has a bad checksum", address)
+	}
+	switch addrVersion {
+	case version:
+		return PayToPubKeyHashScript(pubKeyHash), nil
+	case scriptHashVersion:
+		return PayToScriptHashScript(pubKeyHash), nil
+	}
+	return nil, fmt.Errorf("address %q has unknown version %d", address, addrVersion)
 }
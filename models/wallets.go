@@ -2,7 +2,6 @@ package models
 
 import (
 	"bytes"
-	"crypto/elliptic"
 	"encoding/gob"
 	"fmt"
 	"github.com/bucks-go-wallet/utils"
@@ -12,7 +11,8 @@ import (
 const walletFile = "../tmp/wallets.data"
 
 type Wallets struct {
-	Wallets map[string]*Wallet
+	Wallets       map[string]*Wallet
+	RedeemScripts map[string][]byte // multisig redeem scripts by pay-to-script-hash address
 }
 
 func (ws *Wallets) LoadFile() error {
@@ -27,7 +27,6 @@ func (ws *Wallets) LoadFile() error {
 		return err
 	}
 
-	gob.Register(elliptic.P256())
 	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
 	err = decoder.Decode(&wallets)
 
@@ -36,14 +35,15 @@ func (ws *Wallets) LoadFile() error {
 	}
 
 	ws.Wallets = wallets.Wallets
+	if wallets.RedeemScripts != nil {
+		ws.RedeemScripts = wallets.RedeemScripts
+	}
 	return nil
 }
 
 func (ws *Wallets) SaveFile() {
 	var content bytes.Buffer
 
-	gob.Register(elliptic.P256())
-
 	encoder := gob.NewEncoder(&content)
 	err := encoder.Encode(ws)
 
@@ -56,6 +56,7 @@ func (ws *Wallets) SaveFile() {
 func CreateWallets() (*Wallets, error) {
 	wallets := Wallets{}
 	wallets.Wallets = make(map[string]*Wallet)
+	wallets.RedeemScripts = make(map[string][]byte)
 
 	err := wallets.LoadFile()
 	return &wallets, err
@@ -74,10 +75,45 @@ func (ws *Wallets) GetAllAddresses() []string {
 	return addresses
 }
 
-func (ws *Wallets) AddWallet() string {
-	wallet := MakeWallet()
+func (ws *Wallets) AddWallet(keyType KeyType) string {
+	wallet := MakeWallet(keyType)
 	address := fmt.Sprintf("%s", wallet.Address())
 
 	ws.Wallets[address] = wallet
 	return address
 }
+
+// AddMultiSig stores a multisig redeem script so its address can be spent from, and returns the
+// address
+func (ws *Wallets) AddMultiSig(redeemScript []byte) string {
+	address := ScriptAddress(redeemScript)
+
+	ws.RedeemScripts[address] = redeemScript
+	return address
+}
+
+// MultiSigSigners returns the wallets holding keys of a multisig redeem script, in key order
+func (ws Wallets) MultiSigSigners(redeemScript []byte) []Wallet {
+	var signers []Wallet
+
+	_, pubKeys, _ := multiSigParams(redeemScript)
+	for _, pubKey := range pubKeys {
+		for _, wallet := range ws.Wallets {
+			if bytes.Equal(wallet.PublicKey, pubKey) {
+				signers = append(signers, *wallet)
+				break
+			}
+		}
+	}
+	return signers
+}
+
+// walletForPubKeyHash finds the wallet whose public key hashes to pubKeyHash
+func (ws Wallets) walletForPubKeyHash(pubKeyHash []byte) (Wallet, bool) {
+	for _, wallet := range ws.Wallets {
+		if bytes.Equal(PublicKeyHash(wallet.PublicKey), pubKeyHash) {
+			return *wallet, true
+		}
+	}
+	return Wallet{}, false
+}
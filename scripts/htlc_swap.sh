@@ -0,0 +1,84 @@
+#!/bin/sh
+# Swaps coins between two local chains with HTLCs, then refunds an HTLC after its lock height.
+# Each chain lives in its own directory, the CLI keeps its data in ../tmp of the working
+# directory. Run from the repository root: sh scripts/htlc_swap.sh
+set -e
+
+WORK=$(mktemp -d)
+trap 'rm -rf "$WORK"' EXIT
+go build -o "$WORK/bw" ./cmd
+mkdir -p "$WORK/a/run" "$WORK/a/tmp" "$WORK/b/run" "$WORK/b/tmp"
+
+# on CHAIN COMMAND ARGS... runs the CLI against chain a or b and drops the database logs
+on() {
+	chain=$1
+	shift
+	(cd "$WORK/$chain/run" && "$WORK/bw" "$@" 2>&1 | tr -d '\r' | grep -av badger) || true
+}
+
+field() {
+	grep -a "^$1:" | awk '{print $NF}'
+}
+
+height() {
+	on "$1" printchain | grep -a -m1 '^Height:' | awk '{print $NF}'
+}
+
+balance() {
+	on "$1" getbalance -address "$2" | awk '{print $NF}'
+}
+
+expect() {
+	if [ "$2" != "$3" ]; then
+		echo "FAIL: $1: got '$2', want '$3'"
+		exit 1
+	fi
+	echo "ok: $1"
+}
+
+ALICE_A=$(on a createwallet | grep -a address | awk '{print $NF}')
+BOB_A=$(on a createwallet | grep -a address | awk '{print $NF}')
+ALICE_B=$(on b createwallet | grep -a address | awk '{print $NF}')
+BOB_B=$(on b createwallet | grep -a address | awk '{print $NF}')
+on a createblockchain -address "$ALICE_A" >/dev/null
+on b createblockchain -address "$BOB_B" >/dev/null
+
+# Every command mines its own block, the sender or redeemer gets its subsidy and the fee back.
+# Alice locks her coins on chain a to Bob behind a new secret. Bob locks his on chain b to Alice
+# behind the same hash, with a shorter lock so he can refund before Alice could.
+OUT=$(on a createhtlc -from "$ALICE_A" -to "$BOB_A" -amount 60 -locktime $(($(height a) + 10)))
+SECRET=$(echo "$OUT" | field Secret)
+HASH=$(echo "$OUT" | field Hash)
+SCRIPT_A=$(echo "$OUT" | field "Redeem script")
+SCRIPT_B=$(on b createhtlc -from "$BOB_B" -to "$ALICE_B" -amount 60 -locktime $(($(height b) + 5)) -hash "$HASH" | field "Redeem script")
+expect "htlc on a funded" "$(on a inspecthtlc -script "$SCRIPT_A" | field Balance)" 60
+expect "htlc on b funded" "$(on b inspecthtlc -script "$SCRIPT_B" | field Balance)" 60
+
+# Redeeming needs the secret
+on a redeemhtlc -script "$SCRIPT_A" -preimage 00 >/dev/null
+expect "wrong secret rejected" "$(balance a "$BOB_A")" 0
+
+# Alice claims on chain b, which reveals the secret for Bob to claim on chain a
+on b redeemhtlc -script "$SCRIPT_B" -preimage "$SECRET" >/dev/null
+REVEALED=$(on b inspecthtlc -script "$SCRIPT_B" | field Secret)
+expect "secret revealed on b" "$REVEALED" "$SECRET"
+on a redeemhtlc -script "$SCRIPT_A" -preimage "$REVEALED" >/dev/null
+
+expect "alice on a" "$(balance a "$ALICE_A")" 140
+expect "bob on a" "$(balance a "$BOB_A")" 160
+expect "alice on b" "$(balance b "$ALICE_B")" 160
+expect "bob on b" "$(balance b "$BOB_B")" 140
+
+# A refund only goes through in blocks above the lock height
+LOCK=$(($(height b) + 2))
+SCRIPT=$(on b createhtlc -from "$ALICE_B" -to "$BOB_B" -amount 40 -locktime $LOCK | field "Redeem script")
+on b refundhtlc -script "$SCRIPT" >/dev/null
+expect "early refund rejected" "$(balance b "$ALICE_B")" 220
+
+on b mine -miner "$BOB_B" >/dev/null
+expect "chain b at the lock height" "$(height b)" $LOCK
+on b refundhtlc -script "$SCRIPT" >/dev/null
+expect "refund above the lock height" "$(balance b "$ALICE_B")" 360
+expect "htlc on b emptied" "$(on b inspecthtlc -script "$SCRIPT" | field Balance)" 0
+
+echo "PASS"
@@ -10,10 +10,9 @@ func Base58Encode(input []byte) []byte {
 	return []byte(encode)
 }
 
-func Base58Decode(input []byte) []byte {
-	decode, err := base58.Decode(string(input[:]))
-	Handle(err)
-	return decode
+// Base58Decode fails on characters outside the base58 alphabet
+func Base58Decode(input []byte) ([]byte, error) {
+	return base58.Decode(string(input[:]))
 }
 
 // Base58 remove: 0 O 1 I + / to avoid confuse